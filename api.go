@@ -1,17 +1,238 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 //API
 type TodoDTO struct {
-	ID      string  `Json:"id"`
-	Content string  `json:"content"`
-	Done    bool    `json:"done"`
-	Index   float64 `json:"index"`
+	ID          string        `Json:"id"`
+	OwnerID     string        `json:"ownerId,omitempty"`
+	Content     string        `json:"content"`
+	Done        bool          `json:"done"`
+	Index       float64       `json:"index"`
+	Rank        string        `json:"rank"`
+	DueAt       *time.Time    `json:"dueAt,omitempty"`
+	StartAt     *time.Time    `json:"startAt,omitempty"`
+	Overdue     bool          `json:"overdue"`
+	Recurrence  string        `json:"recurrence,omitempty"`
+	Timezone    string        `json:"timezone,omitempty"`
+	SeriesID    string        `json:"seriesId,omitempty"`
+	Occurrence  int           `json:"occurrence,omitempty"`
+	Priority    string        `json:"priority"`
+	Tags        []string      `json:"tags,omitempty"`
+	Notes       string        `json:"notes,omitempty"`
+	NotesHTML   string        `json:"notesHtml,omitempty"`
+	DeletedAt   *time.Time    `json:"deletedAt,omitempty"`
+	Archived    bool          `json:"archived"`
+	ArchivedAt  *time.Time    `json:"archivedAt,omitempty"`
+	Version     int64         `json:"version"`
+	CompletedAt *time.Time    `json:"completedAt,omitempty"`
+	Reminders   []ReminderDTO `json:"reminders,omitempty"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	UpdatedAt   time.Time     `json:"updatedAt"`
+}
+
+type ReminderDTO struct {
+	ID        string     `json:"id"`
+	At        *time.Time `json:"at,omitempty"`
+	BeforeDue string     `json:"beforeDue,omitempty"`
+	FireAt    *time.Time `json:"fireAt,omitempty"`
+	SentAt    *time.Time `json:"sentAt,omitempty"`
+}
+
+type TodoFilterDTO struct {
+	Due      string
+	Timezone string
+	SeriesID string
+	Priority string
+	Tags     string
+	AnyTags  string
+	NoTags   string
+	Search   string
+	Sort     string
+	Archived string
+}
+
+const RenderHTML = "html"
+
+type UndoDTO struct {
+	Operations []string  `json:"operations"`
+	TodoList   []TodoDTO `json:"todolist"`
+}
+
+type FieldChangeDTO struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type HistoryDTO struct {
+	ID      string           `json:"id"`
+	TodoID  string           `json:"todoId"`
+	Actor   string           `json:"actor"`
+	Action  string           `json:"action"`
+	At      time.Time        `json:"at"`
+	Changes []FieldChangeDTO `json:"changes"`
+}
+
+type HistoryListDTO struct {
+	History []HistoryDTO `json:"history"`
+	Page    Page         `json:"page"`
+}
+
+type AuditFilterDTO struct {
+	TodoID string
+	Actor  string
+	Action string
+	From   string
+	To     string
+}
+
+type TagDTO struct {
+	Name  string `json:"name"`
+	Count int    `json:"count,omitempty"`
+}
+
+type TagListDTO struct {
+	Tags []TagDTO `json:"tags"`
+}
+
+type TagMergeDTO struct {
+	From []string `json:"from"`
+	Into string   `json:"into"`
+}
+
+type ShareDTO struct {
+	OwnerID   string    `json:"ownerId"`
+	UserID    string    `json:"userId"`
+	Role      string    `json:"role"`
+	InvitedBy string    `json:"invitedBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ShareListDTO struct {
+	Shares []ShareDTO `json:"shares"`
+}
+
+type WebhookDTO struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookListDTO struct {
+	Webhooks []WebhookDTO `json:"webhooks"`
+}
+
+type WebhookDeliveryDTO struct {
+	ID             string          `json:"id"`
+	WebhookID      string          `json:"webhookId"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"nextAttemptAt,omitempty"`
+	LastAttemptAt  *time.Time      `json:"lastAttemptAt,omitempty"`
+	LastStatusCode int             `json:"lastStatusCode,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+type WebhookDeliveryListDTO struct {
+	Deliveries []WebhookDeliveryDTO `json:"deliveries"`
+	Page       Page                 `json:"page"`
+}
+
+type SyncDTO struct {
+	Todos   []TodoDTO      `json:"todos"`
+	Deleted []TombstoneDTO `json:"deleted"`
+	Token   string         `json:"token"`
+	HasMore bool           `json:"hasMore"`
+}
+
+type TombstoneDTO struct {
+	ID        string    `json:"id"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// SyncChangeDTO is a change made on a client while it was offline. Fields
+// lists the changed fields for the merge strategy; all of them are taken
+// when it is empty.
+type SyncChangeDTO struct {
+	ID        string    `json:"id"`
+	Deleted   bool      `json:"deleted"`
+	Todo      *TodoDTO  `json:"todo,omitempty"`
+	Fields    []string  `json:"fields,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type SyncPushDTO struct {
+	Strategy string          `json:"strategy"`
+	Changes  []SyncChangeDTO `json:"changes"`
+}
+
+type SyncResultDTO struct {
+	ID        string   `json:"id"`
+	Status    string   `json:"status"`
+	Todo      *TodoDTO `json:"todo,omitempty"`
+	Conflicts []string `json:"conflicts,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+type SyncPushResultDTO struct {
+	Results []SyncResultDTO `json:"results"`
+}
+
+// CalendarFeedDTO carries the token and URL of a feed only when it is
+// created.
+type CalendarFeedDTO struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type CalendarFeedListDTO struct {
+	Feeds []CalendarFeedDTO `json:"feeds"`
+}
+
+type ImportResultDTO struct {
+	DryRun  bool           `json:"dryRun"`
+	Valid   int            `json:"valid"`
+	Created int            `json:"created"`
+	Failed  int            `json:"failed"`
+	Rows    []ImportRowDTO `json:"rows"`
+}
+
+type ImportRowDTO struct {
+	Row    int      `json:"row"`
+	Status string   `json:"status"`
+	Error  string   `json:"error,omitempty"`
+	Todo   *TodoDTO `json:"todo,omitempty"`
+}
+
+type TenantDTO struct {
+	Name      string    `json:"name"`
+	Isolation string    `json:"isolation,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type TenantListDTO struct {
+	Tenants []TenantDTO `json:"tenants"`
 }
 
 type TodoListDTO struct {
@@ -20,19 +241,86 @@ type TodoListDTO struct {
 }
 
 type Api struct {
-	service *Service
+	service  *Service
+	presence *Presence
 }
 
 func NewAPI(service *Service) *Api {
 	return &Api{
-		service: service,
+		service:  service,
+		presence: NewPresence(),
+	}
+}
+
+// actor identifies the caller from the authenticated principal or, when
+// authentication is disabled, from the X-Actor header on the anonymous list.
+// An authenticated caller works on its own list unless the X-List-Owner
+// header selects a list shared with it.
+func (api *Api) actor(ctx *fiber.Ctx) *Actor {
+	if principal, ok := ctx.Locals(PrincipalKey).(*Principal); ok {
+		ownerId := principal.ID
+		if listOwner := ctx.Get("X-List-Owner"); listOwner != "" {
+			ownerId = listOwner
+		}
+		return &Actor{ID: principal.ID, OwnerID: ownerId}
+	}
+	return &Actor{ID: ctx.Get("X-Actor")}
+}
+
+// tenantService returns the Service of the tenant resolved by
+// TenantMiddleware.
+func (api *Api) tenantService(ctx *fiber.Ctx) (*Service, error) {
+	tenant, _ := ctx.Locals(TenantKey).(string)
+	service, err := api.service.ForTenant(tenant)
+
+	switch err {
+	case nil:
+		return service, nil
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return nil, err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return nil, err
+	}
+}
+
+// authorize resolves the Service of the tenant and the actor, and checks
+// the actor holds at least the given role on the list it works on.
+func (api *Api) authorize(ctx *fiber.Ctx, role string) (*Service, *Actor, error) {
+	service, err := api.tenantService(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	actor := api.actor(ctx)
+	err = service.AuthorizeService(actor, role)
+
+	switch err {
+	case nil:
+		return service, actor, nil
+
+	case fiber.ErrForbidden:
+		ctx.Status(fiber.StatusForbidden)
+		return nil, nil, err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return nil, nil, err
 	}
 }
 
 func (api *Api) PostTodoApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
 	todoDTO := TodoDTO{}
 	ctx.BodyParser(&todoDTO)
-	returnedData, err := api.service.PostTodoService(&todoDTO)
+	returnedData, err := service.PostTodoService(actor, &todoDTO)
 
 	switch err {
 	case nil:
@@ -42,6 +330,9 @@ func (api *Api) PostTodoApi(ctx *fiber.Ctx) error {
 	case fiber.ErrConflict:
 		ctx.Status(fiber.StatusConflict)
 		return err
+	case ErrQuotaExceeded:
+		ctx.Status(fiber.StatusForbidden)
+		return err
 	case fiber.ErrBadRequest:
 		ctx.Status(fiber.StatusBadRequest)
 		return err
@@ -51,9 +342,81 @@ func (api *Api) PostTodoApi(ctx *fiber.Ctx) error {
 	}
 }
 
+// GetTodoEventsApi streams the changes of the list as Server-Sent Events.
+// Clients resume with the Last-Event-ID header; a reset event asks them to
+// reload the list because events were missed.
+func (api *Api) GetTodoEventsApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	lastEventID := uint64(0)
+	if lastEventIDStr := ctx.Get("Last-Event-ID"); lastEventIDStr != "" {
+		lastEventID, err = strconv.ParseUint(lastEventIDStr, 10, 64)
+		if err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			return fiber.ErrBadRequest
+		}
+	}
+
+	subscription := service.SubscribeTodoEventsService(actor, lastEventID)
+
+	ctx.Set(fiber.HeaderContentType, "text/event-stream")
+	ctx.Set(fiber.HeaderCacheControl, "no-cache")
+	ctx.Set(fiber.HeaderConnection, "keep-alive")
+	ctx.Status(fiber.StatusOK)
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer subscription.Close()
+
+		if subscription.Reset {
+			fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		}
+		for i := range subscription.Missed {
+			WriteTodoEvent(w, &subscription.Missed[i])
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(EventHeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case event, ok := <-subscription.Events:
+				if !ok {
+					return
+				}
+				WriteTodoEvent(w, &event)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
 func (api *Api) GetTodoApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
 	id := ctx.Params("id")
-	returnedData, err := api.service.GetTodoService(id)
+	render := ctx.Query("render")
+	if render != "" && render != RenderHTML {
+		ctx.Status(fiber.StatusBadRequest)
+		return fiber.ErrBadRequest
+	}
+
+	returnedData, err := service.GetTodoService(actor, id)
+	if err == nil && render == RenderHTML {
+		err = RenderTodoNotes(returnedData)
+	}
 
 	switch err {
 	case nil:
@@ -65,6 +428,10 @@ func (api *Api) GetTodoApi(ctx *fiber.Ctx) error {
 		ctx.Status(fiber.StatusBadRequest)
 		return err
 
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
 	default:
 		ctx.Status(fiber.StatusInternalServerError)
 		return err
@@ -72,6 +439,11 @@ func (api *Api) GetTodoApi(ctx *fiber.Ctx) error {
 }
 
 func (api *Api) GetTodoListApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
 	pageStr := ctx.Query("page")
 	page := 0
 	if len(pageStr) != 0 {
@@ -83,6 +455,12 @@ func (api *Api) GetTodoListApi(ctx *fiber.Ctx) error {
 		}
 	}
 
+	render := ctx.Query("render")
+	if render != "" && render != RenderHTML {
+		ctx.Status(fiber.StatusBadRequest)
+		return fiber.ErrBadRequest
+	}
+
 	sizeStr := ctx.Query("size")
 	size := 20
 	if len(sizeStr) != 0 {
@@ -94,7 +472,27 @@ func (api *Api) GetTodoListApi(ctx *fiber.Ctx) error {
 		}
 	}
 
-	returnedData, err := api.service.GetTodoListService(page, size)
+	filterDTO := TodoFilterDTO{
+		Due:      ctx.Query("due"),
+		Timezone: ctx.Query("tz"),
+		SeriesID: ctx.Query("series"),
+		Priority: ctx.Query("priority"),
+		Tags:     ctx.Query("tag"),
+		AnyTags:  ctx.Query("anyTag"),
+		NoTags:   ctx.Query("noTag"),
+		Search:   ctx.Query("q"),
+		Sort:     ctx.Query("sort"),
+		Archived: ctx.Query("archived"),
+	}
+
+	returnedData, err := service.GetTodoListService(actor, &filterDTO, page, size)
+	if err == nil && render == RenderHTML {
+		for i := range returnedData.TodoList {
+			if err = RenderTodoNotes(&returnedData.TodoList[i]); err != nil {
+				break
+			}
+		}
+	}
 
 	switch err {
 	case nil:
@@ -113,10 +511,15 @@ func (api *Api) GetTodoListApi(ctx *fiber.Ctx) error {
 }
 
 func (api *Api) PutTodoApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
 	id := ctx.Params("id")
 	todoDTO := TodoDTO{}
 	ctx.BodyParser(&todoDTO)
-	returnedData, err := api.service.UpdateTodoService(id, &todoDTO)
+	returnedData, err := service.UpdateTodoService(actor, id, &todoDTO)
 
 	switch err {
 	case nil:
@@ -128,6 +531,10 @@ func (api *Api) PutTodoApi(ctx *fiber.Ctx) error {
 		ctx.Status(fiber.StatusBadRequest)
 		return err
 
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
 	default:
 		ctx.Status(fiber.StatusInternalServerError)
 		return err
@@ -135,11 +542,16 @@ func (api *Api) PutTodoApi(ctx *fiber.Ctx) error {
 }
 
 func (api *Api) PutSortApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
 	currentId := ctx.Query("currentid")
 	backId := ctx.Query("backid")
 	frontId := ctx.Query("frontid")
 
-	returnedData, err := api.service.UpdateTodoSortService(currentId, backId, frontId)
+	returnedData, err := service.UpdateTodoSortService(actor, currentId, backId, frontId)
 
 	switch err {
 	case nil:
@@ -151,6 +563,10 @@ func (api *Api) PutSortApi(ctx *fiber.Ctx) error {
 		ctx.Status(fiber.StatusBadRequest)
 		return err
 
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
 	default:
 		ctx.Status(fiber.StatusInternalServerError)
 		return err
@@ -158,12 +574,964 @@ func (api *Api) PutSortApi(ctx *fiber.Ctx) error {
 }
 
 func (api *Api) DeleteTodoApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	id := ctx.Params("id")
+	err = service.DeleteTodoService(actor, id)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		return nil
+
+	case fiber.ErrBadRequest:
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) GetTagsApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	returnedData, err := service.GetTagsService(actor)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) PutTagApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	name, err := url.PathUnescape(ctx.Params("name"))
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return fiber.ErrBadRequest
+	}
+	tagDTO := TagDTO{}
+	ctx.BodyParser(&tagDTO)
+	returnedData, err := service.RenameTagService(actor, name, &tagDTO)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrBadRequest:
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) PostMergeTagsApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	tagMergeDTO := TagMergeDTO{}
+	ctx.BodyParser(&tagMergeDTO)
+	returnedData, err := service.MergeTagsService(actor, &tagMergeDTO)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrBadRequest:
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+// pageQuery reads the page and size query parameters, defaulting to the
+// first page of 20 elements.
+func pageQuery(ctx *fiber.Ctx) (int, int, error) {
+	page := 0
+	if pageStr := ctx.Query("page"); len(pageStr) != 0 {
+		var err error
+		page, err = strconv.Atoi(pageStr)
+		if page < 0 || err != nil {
+			return 0, 0, fiber.ErrBadRequest
+		}
+	}
+
+	size := 20
+	if sizeStr := ctx.Query("size"); len(sizeStr) != 0 {
+		var err error
+		size, err = strconv.Atoi(sizeStr)
+		if size <= 0 || err != nil {
+			return 0, 0, fiber.ErrBadRequest
+		}
+	}
+
+	return page, size, nil
+}
+
+func (api *Api) GetTrashApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	page, size, err := pageQuery(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+	}
+
+	returnedData, err := service.GetTrashService(actor, page, size)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) PostRestoreTodoApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
 	id := ctx.Params("id")
-	err := api.service.DeleteTodoService(id)
+	returnedData, err := service.RestoreTodoService(actor, id)
 
 	switch err {
 	case nil:
 		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) DeleteTrashTodoApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	id := ctx.Params("id")
+	err = service.PurgeTodoService(actor, id)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		return nil
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) DeleteTrashApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	err = service.EmptyTrashService(actor)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		return nil
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) GetTodoHistoryApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	id := ctx.Params("id")
+	page, size, err := pageQuery(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+	}
+
+	returnedData, err := service.GetTodoHistoryService(actor, id, page, size)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) GetAuditApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	page, size, err := pageQuery(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+	}
+
+	auditFilterDTO := AuditFilterDTO{
+		TodoID: ctx.Query("todoId"),
+		Actor:  ctx.Query("actor"),
+		Action: ctx.Query("action"),
+		From:   ctx.Query("from"),
+		To:     ctx.Query("to"),
+	}
+
+	returnedData, err := service.GetAuditService(actor, &auditFilterDTO, page, size)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrBadRequest:
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) PostUndoApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	steps, err := strconv.Atoi(ctx.Query("steps", "1"))
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return fiber.ErrBadRequest
+	}
+
+	returnedData, err := service.UndoService(actor, steps)
+	return api.undoResponse(ctx, returnedData, err)
+}
+
+func (api *Api) PostRedoApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	steps, err := strconv.Atoi(ctx.Query("steps", "1"))
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return fiber.ErrBadRequest
+	}
+
+	returnedData, err := service.RedoService(actor, steps)
+	return api.undoResponse(ctx, returnedData, err)
+}
+
+func (api *Api) undoResponse(ctx *fiber.Ctx, returnedData *UndoDTO, err error) error {
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrBadRequest:
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	case fiber.ErrForbidden:
+		ctx.Status(fiber.StatusForbidden)
+		return err
+
+	case fiber.ErrConflict:
+		ctx.Status(fiber.StatusConflict)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) GetSharesApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	returnedData, err := service.GetSharesService(actor)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) GetSharedListsApi(ctx *fiber.Ctx) error {
+	service, err := api.tenantService(ctx)
+	if err != nil {
+		return err
+	}
+
+	returnedData, err := service.GetSharedListsService(api.actor(ctx))
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) PostShareApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	shareDTO := ShareDTO{}
+	ctx.BodyParser(&shareDTO)
+	returnedData, err := service.ShareListService(actor, &shareDTO)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusCreated)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrBadRequest:
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) DeleteShareApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	userId, err := url.PathUnescape(ctx.Params("userId"))
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return fiber.ErrBadRequest
+	}
+	err = service.RevokeShareService(actor, userId)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		return nil
+
+	case fiber.ErrForbidden:
+		ctx.Status(fiber.StatusForbidden)
+		return err
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) PostArchiveTodoApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	id := ctx.Params("id")
+	returnedData, err := service.ArchiveTodoService(actor, id)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) PostUnarchiveTodoApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	id := ctx.Params("id")
+	returnedData, err := service.UnarchiveTodoService(actor, id)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) GetTenantsApi(ctx *fiber.Ctx) error {
+	returnedData, err := api.service.GetTenantsService()
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) PostTenantApi(ctx *fiber.Ctx) error {
+	tenantDTO := TenantDTO{}
+	ctx.BodyParser(&tenantDTO)
+	returnedData, err := api.service.CreateTenantService(&tenantDTO)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusCreated)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrBadRequest:
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+
+	case fiber.ErrConflict:
+		ctx.Status(fiber.StatusConflict)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) DeleteTenantApi(ctx *fiber.Ctx) error {
+	err := api.service.DeleteTenantService(ctx.Params("name"))
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		return nil
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) GetWebhooksApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	returnedData, err := service.GetWebhooksService(actor)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) PostWebhookApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	webhookDTO := WebhookDTO{}
+	ctx.BodyParser(&webhookDTO)
+	returnedData, err := service.CreateWebhookService(actor, &webhookDTO)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusCreated)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrBadRequest:
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) DeleteWebhookApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	err = service.DeleteWebhookService(actor, ctx.Params("id"))
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		return nil
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) GetWebhookDeliveriesApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	page, size, err := pageQuery(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+	}
+
+	returnedData, err := service.GetWebhookDeliveriesService(actor, ctx.Params("id"), page, size)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) GetDeadLettersApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	page, size, err := pageQuery(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+	}
+
+	returnedData, err := service.GetDeadLettersService(actor, page, size)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) PostRetryDeliveryApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	returnedData, err := service.RetryWebhookDeliveryService(actor, ctx.Params("id"))
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+// TodoEncoder writes exported to-dos in one format.
+type TodoEncoder interface {
+	Encode(todoDTO *TodoDTO) error
+	Close() error
+}
+
+// GetExportApi streams every to-do of the list that is not in the trash,
+// in the order of the list.
+func (api *Api) GetExportApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	format := ctx.Query("format", FormatCSV)
+	fileName := "todos." + format
+	if format == FormatTodoTxt {
+		fileName = "todo.txt"
+	}
+	ctx.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	return exportTodos(ctx, service, actor, format)
+}
+
+// GetCalendarFeedApi serves the list of a calendar feed as iCalendar. The
+// token in the URL is the only credential, so calendar apps can subscribe.
+func (api *Api) GetCalendarFeedApi(ctx *fiber.Ctx) error {
+	token := strings.TrimSuffix(ctx.Params("token"), "."+FormatICS)
+	service, actor, err := api.service.CalendarFeedService(token)
+
+	switch err {
+	case nil:
+		return exportTodos(ctx, service, actor, FormatICS)
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func exportTodos(ctx *fiber.Ctx, service *Service, actor *Actor, format string) error {
+	var newEncoder func(w io.Writer) (TodoEncoder, error)
+	switch format {
+	case FormatCSV:
+		ctx.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		newEncoder = func(w io.Writer) (TodoEncoder, error) {
+			return NewCSVTodoEncoder(w)
+		}
+	case FormatICS:
+		ctx.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+		newEncoder = func(w io.Writer) (TodoEncoder, error) {
+			return NewICSTodoEncoder(w, "To-dos")
+		}
+	case FormatTodoTxt:
+		ctx.Set(fiber.HeaderContentType, "text/plain; charset=utf-8")
+		newEncoder = func(w io.Writer) (TodoEncoder, error) {
+			return NewTodoTxtTodoEncoder(w)
+		}
+	default:
+		ctx.Status(fiber.StatusBadRequest)
+		return fiber.ErrBadRequest
+	}
+
+	ctx.Status(fiber.StatusOK)
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		encoder, err := newEncoder(w)
+		if err == nil {
+			err = service.ExportTodosService(actor, encoder.Encode)
+		}
+		if err == nil {
+			err = encoder.Close()
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			log.Printf("exporting %s: %v", format, err)
+		}
+	})
+
+	return nil
+}
+
+// PostImportApi adds the to-dos of the file in the body on top of the list.
+// The response reports every row; dryRun=true only checks them.
+func (api *Api) PostImportApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	var dryRun bool
+	switch ctx.Query("dryRun") {
+	case "", "false":
+	case "true":
+		dryRun = true
+	default:
+		ctx.Status(fiber.StatusBadRequest)
+		return fiber.ErrBadRequest
+	}
+
+	var rows []ImportRowModel
+	switch ctx.Query("format", FormatCSV) {
+	case FormatCSV:
+		var mapping map[string]string
+		mapping, err = ParseCSVMapping(ctx.Query("map"))
+		if err == nil {
+			rows, err = DecodeCSVTodos(bytes.NewReader(ctx.Body()), mapping)
+		}
+	case FormatICS:
+		rows, err = DecodeICSTodos(bytes.NewReader(ctx.Body()))
+	case FormatTodoTxt:
+		rows, err = DecodeTodoTxtTodos(bytes.NewReader(ctx.Body()))
+	default:
+		err = fiber.ErrBadRequest
+	}
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	returnedData, err := service.ImportTodosService(actor, rows, dryRun)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) GetCalendarFeedsApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	tenant, _ := ctx.Locals(TenantKey).(string)
+	returnedData, err := service.GetCalendarFeedsService(actor, tenant)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+// PostCalendarFeedApi returns the subscribable URL of a new feed.
+func (api *Api) PostCalendarFeedApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	tenant, _ := ctx.Locals(TenantKey).(string)
+	returnedData, err := service.CreateCalendarFeedService(actor, tenant)
+
+	switch err {
+	case nil:
+		returnedData.URL = fmt.Sprintf("%s/ical/%s.%s", ctx.BaseURL(), returnedData.Token, FormatICS)
+		ctx.Status(fiber.StatusCreated)
+		ctx.JSON(returnedData)
+		return nil
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) DeleteCalendarFeedApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	tenant, _ := ctx.Locals(TenantKey).(string)
+	err = service.DeleteCalendarFeedService(actor, tenant, ctx.Params("id"))
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		return nil
+
+	case fiber.ErrNotFound:
+		ctx.Status(fiber.StatusNotFound)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+// GetSyncApi returns the changes of the list after the since token, or the
+// whole list without one, together with the token of the next sync.
+func (api *Api) GetSyncApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	limit, err := strconv.Atoi(ctx.Query("limit", strconv.Itoa(DefaultSyncLimit)))
+	if err != nil || limit <= 0 {
+		ctx.Status(fiber.StatusBadRequest)
+		return fiber.ErrBadRequest
+	}
+
+	returnedData, err := service.GetSyncService(actor, ctx.Query("since"), limit)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
+		return nil
+
+	case fiber.ErrBadRequest:
+		ctx.Status(fiber.StatusBadRequest)
+		return err
+
+	default:
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+}
+
+func (api *Api) PostSyncApi(ctx *fiber.Ctx) error {
+	service, actor, err := api.authorize(ctx, RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	syncPushDTO := SyncPushDTO{}
+	if err := ctx.BodyParser(&syncPushDTO); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return fiber.ErrBadRequest
+	}
+	returnedData, err := service.PushSyncService(actor, &syncPushDTO)
+
+	switch err {
+	case nil:
+		ctx.Status(fiber.StatusOK)
+		ctx.JSON(returnedData)
 		return nil
 
 	case fiber.ErrBadRequest:
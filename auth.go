@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const PrincipalKey = "principal"
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+const (
+	AuthMethodAPIKey = "apikey"
+	AuthMethodJWT    = "jwt"
+)
+
+var DefaultScopes = []string{ScopeRead, ScopeWrite}
+
+// Principal is the authenticated caller, available to Api handlers through
+// ctx.Locals(PrincipalKey).
+type Principal struct {
+	ID      string
+	Method  string
+	Scopes  []string
+	Tenants []string
+	Claims  jwt.MapClaims
+}
+
+func (principal *Principal) HasScope(scope string) bool {
+	for _, s := range principal.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// InTenant reports whether the principal belongs to the tenant, either
+// through its API key or through the named token claim, which holds one
+// tenant or a list of them.
+func (principal *Principal) InTenant(tenant string, claim string) bool {
+	for _, t := range principal.Tenants {
+		if t == tenant {
+			return true
+		}
+	}
+
+	switch value := principal.Claims[claim].(type) {
+	case string:
+		return value == tenant
+	case []interface{}:
+		for _, t := range value {
+			if t == tenant {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// APIKeyConfig holds the SHA-256 hex digest of a static API key, never the
+// key itself.
+type APIKeyConfig struct {
+	Hash      string
+	Principal string
+	Scopes    []string
+	Tenants   []string
+}
+
+type AuthConfig struct {
+	APIKeys   []APIKeyConfig
+	JWTSecret string
+	JWKSFile  string
+}
+
+func (config AuthConfig) Enabled() bool {
+	return len(config.APIKeys) > 0 || config.JWTSecret != "" || config.JWKSFile != ""
+}
+
+type Authenticator struct {
+	apiKeys    map[string]APIKeyConfig
+	hmacSecret []byte
+	jwks       map[string]*rsa.PublicKey
+	repository *Repository
+}
+
+// NewAuthenticator accepts API keys from the config and, when a repository
+// is given, from its apikeys collection.
+func NewAuthenticator(config AuthConfig, repository *Repository) (*Authenticator, error) {
+	authenticator := Authenticator{
+		apiKeys:    map[string]APIKeyConfig{},
+		hmacSecret: []byte(config.JWTSecret),
+		jwks:       map[string]*rsa.PublicKey{},
+		repository: repository,
+	}
+
+	for _, apiKey := range config.APIKeys {
+		authenticator.apiKeys[strings.ToLower(apiKey.Hash)] = apiKey
+	}
+
+	if config.JWKSFile != "" {
+		jwks, err := LoadJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticator.jwks = jwks
+	}
+
+	return &authenticator, nil
+}
+
+// Middleware authenticates every request with either an X-API-Key header or
+// an Authorization bearer token, and checks the scope the method needs:
+// read for safe methods, write for everything else.
+func (authenticator *Authenticator) Middleware(ctx *fiber.Ctx) error {
+	principal, err := authenticator.authenticate(ctx)
+	if err == nil && principal.ID == ReservedOwnerID {
+		err = fiber.ErrUnauthorized
+	}
+	if err != nil {
+		ctx.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+		ctx.Status(fiber.StatusUnauthorized)
+		return fiber.ErrUnauthorized
+	}
+
+	scope := ScopeWrite
+	if ctx.Method() == fiber.MethodGet || ctx.Method() == fiber.MethodHead {
+		scope = ScopeRead
+	}
+	if !principal.HasScope(scope) {
+		ctx.Status(fiber.StatusForbidden)
+		return fiber.ErrForbidden
+	}
+
+	ctx.Locals(PrincipalKey, principal)
+	return ctx.Next()
+}
+
+// RequireScope guards routes that need more than the method-based scope.
+// They are closed when authentication is not configured.
+func RequireScope(scope string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		principal, ok := ctx.Locals(PrincipalKey).(*Principal)
+		if !ok {
+			ctx.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			ctx.Status(fiber.StatusUnauthorized)
+			return fiber.ErrUnauthorized
+		}
+		if !principal.HasScope(scope) {
+			ctx.Status(fiber.StatusForbidden)
+			return fiber.ErrForbidden
+		}
+		return ctx.Next()
+	}
+}
+
+func (authenticator *Authenticator) authenticate(ctx *fiber.Ctx) (*Principal, error) {
+	if apiKey := ctx.Get("X-API-Key"); apiKey != "" {
+		return authenticator.authenticateAPIKey(apiKey)
+	}
+
+	authorization := ctx.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return nil, fiber.ErrUnauthorized
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+
+	if strings.Count(token, ".") == 2 {
+		return authenticator.authenticateJWT(token)
+	}
+	return authenticator.authenticateAPIKey(token)
+}
+
+func (authenticator *Authenticator) authenticateAPIKey(apiKey string) (*Principal, error) {
+	hash := HashAPIKey(apiKey)
+
+	// A key without a principal would act on the anonymous list.
+	if entry, ok := authenticator.apiKeys[hash]; ok {
+		if entry.Principal == "" {
+			return nil, fiber.ErrUnauthorized
+		}
+		return &Principal{ID: entry.Principal, Method: AuthMethodAPIKey, Scopes: scopesOrDefault(entry.Scopes), Tenants: entry.Tenants}, nil
+	}
+
+	if authenticator.repository != nil {
+		apiKeyEntity, err := authenticator.repository.GetAPIKeyRepository(hash)
+		if err == mongo.ErrNoDocuments {
+			return nil, fiber.ErrUnauthorized
+		}
+		if err != nil {
+			return nil, err
+		}
+		if apiKeyEntity.Principal == "" {
+			return nil, fiber.ErrUnauthorized
+		}
+		return &Principal{ID: apiKeyEntity.Principal, Method: AuthMethodAPIKey, Scopes: scopesOrDefault(apiKeyEntity.Scopes), Tenants: apiKeyEntity.Tenants}, nil
+	}
+
+	return nil, fiber.ErrUnauthorized
+}
+
+func (authenticator *Authenticator) authenticateJWT(token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if token.Method.Alg() != jwt.SigningMethodHS256.Alg() || len(authenticator.hmacSecret) == 0 {
+				return nil, fiber.ErrUnauthorized
+			}
+			return authenticator.hmacSecret, nil
+		case *jwt.SigningMethodRSA:
+			kid, _ := token.Header["kid"].(string)
+			key, ok := authenticator.jwks[kid]
+			if !ok {
+				return nil, fiber.ErrUnauthorized
+			}
+			return key, nil
+		default:
+			return nil, fiber.ErrUnauthorized
+		}
+	})
+	if err != nil {
+		return nil, fiber.ErrUnauthorized
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fiber.ErrUnauthorized
+	}
+
+	return &Principal{ID: subject, Method: AuthMethodJWT, Scopes: scopesFromClaims(claims), Claims: claims}, nil
+}
+
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// scopesFromClaims reads the space separated OAuth "scope" claim.
+func scopesFromClaims(claims jwt.MapClaims) []string {
+	scope, _ := claims["scope"].(string)
+	return scopesOrDefault(strings.Fields(scope))
+}
+
+func scopesOrDefault(scopes []string) []string {
+	if len(scopes) == 0 {
+		return DefaultScopes
+	}
+	return scopes
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// LoadJWKS reads the RSA keys of a local JSON Web Key Set file, keyed by
+// key id.
+func LoadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, err
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// ParseAPIKeys reads "hash:principal:scope,scope:tenant,tenant" entries
+// separated by semicolons. Entries without a hash or a principal are
+// skipped.
+func ParseAPIKeys(value string) []APIKeyConfig {
+	apiKeys := []APIKeyConfig{}
+	for _, entry := range strings.Split(value, ";") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 4)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+
+		apiKey := APIKeyConfig{Hash: parts[0], Principal: parts[1]}
+		if len(parts) >= 3 && parts[2] != "" {
+			apiKey.Scopes = strings.Split(parts[2], ",")
+		}
+		if len(parts) == 4 && parts[3] != "" {
+			apiKey.Tenants = strings.Split(parts[3], ",")
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	return apiKeys
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Authenticator(t *testing.T) {
+	Convey("Given an app behind the authentication middleware", t, func() {
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+		jwksFile := writeTestJWKS(t, "key-1", &privateKey.PublicKey)
+
+		authenticator, err := NewAuthenticator(AuthConfig{
+			APIKeys: []APIKeyConfig{
+				{Hash: HashAPIKey("writer-key"), Principal: "writer"},
+				{Hash: HashAPIKey("reader-key"), Principal: "reader", Scopes: []string{ScopeRead}},
+				{Hash: HashAPIKey("wildcard-key"), Principal: ReservedOwnerID},
+				{Hash: HashAPIKey("nobody-key"), Principal: ""},
+			},
+			JWTSecret: "secret",
+			JWKSFile:  jwksFile,
+		}, nil)
+		So(err, ShouldBeNil)
+
+		app := fiber.New()
+		app.Use(authenticator.Middleware)
+		handler := func(ctx *fiber.Ctx) error {
+			return ctx.SendString(ctx.Locals(PrincipalKey).(*Principal).ID)
+		}
+		app.Get("/todo", handler)
+		app.Post("/todo", handler)
+
+		request := func(method string, header string, value string) (int, string) {
+			req := httptest.NewRequest(method, "/todo", nil)
+			if header != "" {
+				req.Header.Set(header, value)
+			}
+			resp, err := app.Test(req)
+			So(err, ShouldBeNil)
+			body, _ := ioutil.ReadAll(resp.Body)
+			return resp.StatusCode, string(body)
+		}
+
+		Convey("When no credentials are sent", func() {
+			status, _ := request("GET", "", "")
+
+			Convey("Then the request should be unauthorized", func() {
+				So(status, ShouldEqual, fiber.StatusUnauthorized)
+			})
+		})
+
+		Convey("When a known API key is sent", func() {
+			status, body := request("GET", "X-API-Key", "writer-key")
+
+			Convey("Then its principal should reach the handler", func() {
+				So(status, ShouldEqual, fiber.StatusOK)
+				So(body, ShouldEqual, "writer")
+			})
+		})
+
+		Convey("When an unknown API key is sent", func() {
+			status, _ := request("GET", "X-API-Key", "other-key")
+
+			Convey("Then the request should be unauthorized", func() {
+				So(status, ShouldEqual, fiber.StatusUnauthorized)
+			})
+		})
+
+		Convey("When a key of the reserved principal is sent", func() {
+			status, _ := request("GET", "X-API-Key", "wildcard-key")
+
+			Convey("Then the request should be unauthorized", func() {
+				So(status, ShouldEqual, fiber.StatusUnauthorized)
+			})
+		})
+
+		Convey("When a key without a principal is sent", func() {
+			status, _ := request("GET", "X-API-Key", "nobody-key")
+
+			Convey("Then the request should be unauthorized", func() {
+				So(status, ShouldEqual, fiber.StatusUnauthorized)
+			})
+		})
+
+		Convey("When a read only API key writes", func() {
+			status, _ := request("POST", "X-API-Key", "reader-key")
+
+			Convey("Then the request should be forbidden", func() {
+				So(status, ShouldEqual, fiber.StatusForbidden)
+			})
+		})
+
+		Convey("When an HS256 token is sent", func() {
+			token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"sub": "hs-user",
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}).SignedString([]byte("secret"))
+			status, body := request("POST", "Authorization", "Bearer "+token)
+
+			Convey("Then its subject should reach the handler", func() {
+				So(status, ShouldEqual, fiber.StatusOK)
+				So(body, ShouldEqual, "hs-user")
+			})
+		})
+
+		Convey("When an expired token is sent", func() {
+			token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"sub": "hs-user",
+				"exp": time.Now().Add(-time.Hour).Unix(),
+			}).SignedString([]byte("secret"))
+			status, _ := request("GET", "Authorization", "Bearer "+token)
+
+			Convey("Then the request should be unauthorized", func() {
+				So(status, ShouldEqual, fiber.StatusUnauthorized)
+			})
+		})
+
+		Convey("When an RS256 token signed by a JWKS key is sent", func() {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+				"sub":   "rs-user",
+				"scope": "read",
+			})
+			token.Header["kid"] = "key-1"
+			signed, _ := token.SignedString(privateKey)
+
+			Convey("Then reads should be allowed", func() {
+				status, body := request("GET", "Authorization", "Bearer "+signed)
+				So(status, ShouldEqual, fiber.StatusOK)
+				So(body, ShouldEqual, "rs-user")
+			})
+
+			Convey("Then writes should be forbidden by its scope", func() {
+				status, _ := request("POST", "Authorization", "Bearer "+signed)
+				So(status, ShouldEqual, fiber.StatusForbidden)
+			})
+		})
+	})
+}
+
+func Test_RequireScope(t *testing.T) {
+	Convey("Given an admin route", t, func() {
+		authenticator, err := NewAuthenticator(AuthConfig{
+			APIKeys: []APIKeyConfig{
+				{Hash: HashAPIKey("writer-key"), Principal: "writer"},
+				{Hash: HashAPIKey("admin-key"), Principal: "admin", Scopes: []string{ScopeAdmin}},
+			},
+		}, nil)
+		So(err, ShouldBeNil)
+
+		handler := func(ctx *fiber.Ctx) error {
+			return ctx.SendString("audit")
+		}
+		request := func(app *fiber.App, apiKey string) int {
+			req := httptest.NewRequest("GET", "/audit", nil)
+			if apiKey != "" {
+				req.Header.Set("X-API-Key", apiKey)
+			}
+			resp, err := app.Test(req)
+			So(err, ShouldBeNil)
+			return resp.StatusCode
+		}
+
+		Convey("When authentication is not configured", func() {
+			app := fiber.New()
+			app.Get("/audit", RequireScope(ScopeAdmin), handler)
+
+			Convey("Then the request should be unauthorized", func() {
+				So(request(app, ""), ShouldEqual, fiber.StatusUnauthorized)
+			})
+		})
+
+		Convey("When it is behind the authentication middleware", func() {
+			app := fiber.New()
+			app.Use(authenticator.Middleware)
+			app.Get("/audit", RequireScope(ScopeAdmin), handler)
+
+			Convey("Then a principal without the scope should be forbidden", func() {
+				So(request(app, "writer-key"), ShouldEqual, fiber.StatusForbidden)
+			})
+
+			Convey("Then a principal with the scope should pass", func() {
+				So(request(app, "admin-key"), ShouldEqual, fiber.StatusOK)
+			})
+		})
+	})
+}
+
+func writeTestJWKS(t *testing.T, kid string, publicKey *rsa.PublicKey) string {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}},
+	})
+	path := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_ParseAPIKeys(t *testing.T) {
+	Convey("Given API key entries", t, func() {
+		apiKeys := ParseAPIKeys("h1:alice:read,write; h2:; h3::read; :bob; h4:carol; h5:dave::acme,globex")
+
+		Convey("Then entries without a hash or a principal should be skipped", func() {
+			So(apiKeys, ShouldResemble, []APIKeyConfig{
+				{Hash: "h1", Principal: "alice", Scopes: []string{"read", "write"}},
+				{Hash: "h4", Principal: "carol"},
+				{Hash: "h5", Principal: "dave", Tenants: []string{"acme", "globex"}},
+			})
+		})
+	})
+}
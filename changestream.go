@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// reorderFields are the only fields UpdateTodoSortRepository changes.
+var reorderFields = map[string]bool{
+	"index":     true,
+	"rank":      true,
+	"updatedat": true,
+	"version":   true,
+	"seq":       true,
+}
+
+// ChangeStreamWatcher tails the to-do collection of a service and
+// publishes every change on its event bus, including the writes of other
+// services. The resume token is saved after each change, so a restarted
+// watcher continues where it stopped.
+type ChangeStreamWatcher struct {
+	service *Service
+	name    string
+	retry   time.Duration
+}
+
+func NewChangeStreamWatcher(service *Service, name string) *ChangeStreamWatcher {
+	return &ChangeStreamWatcher{
+		service: service,
+		name:    name,
+		retry:   5 * time.Second,
+	}
+}
+
+// Start watches on its own goroutine, reopening the stream after errors,
+// until the returned stop function is called.
+func (watcher *ChangeStreamWatcher) Start() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for {
+			err := watcher.watch(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("change stream %s: %v", watcher.name, err)
+
+			select {
+			case <-time.After(watcher.retry):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (watcher *ChangeStreamWatcher) watch(ctx context.Context) error {
+	repository := watcher.service.repository
+
+	resumeToken, err := repository.GetResumeTokenRepository(watcher.name)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	stream, err := repository.WatchTodoChangesRepository(ctx, resumeToken)
+	if err != nil {
+		// The oplog no longer has the change after the token; the next
+		// attempt starts from now.
+		var commandErr mongo.CommandError
+		if resumeToken != nil && errors.As(err, &commandErr) && commandErr.Code == 286 {
+			log.Printf("change stream %s: history lost, changes since the last run are not published", watcher.name)
+			if err := repository.DeleteResumeTokenRepository(watcher.name); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	tombstones := repository.collection("tombstones").Name()
+	for stream.Next(ctx) {
+		var event TodoEvent
+		var ok bool
+		if collection, _ := stream.Current.Lookup("ns", "coll").StringValueOK(); collection == tombstones {
+			changeEntity := TombstoneChangeEntity{}
+			if err := stream.Decode(&changeEntity); err != nil {
+				return err
+			}
+			event, ok = TodoEventFromTombstoneChange(&changeEntity)
+		} else {
+			changeEntity := TodoChangeEntity{}
+			if err := stream.Decode(&changeEntity); err != nil {
+				return err
+			}
+			event, ok = TodoEventFromChange(&changeEntity)
+		}
+
+		if ok {
+			watcher.service.publishEvent(event, stream.ResumeToken().String())
+		}
+
+		if err := repository.SaveResumeTokenRepository(watcher.name, stream.ResumeToken()); err != nil {
+			return err
+		}
+	}
+	return stream.Err()
+}
+
+// TodoEventFromChange translates a change event into the event publish
+// would have produced. Changes inside the trash are skipped. A delete only
+// carries the id of the to-do; it is published from its tombstone instead.
+func TodoEventFromChange(changeEntity *TodoChangeEntity) (TodoEvent, bool) {
+	event := TodoEvent{At: time.Now().UTC()}
+	after := changeEntity.FullDocument
+	updatedFields := changeEntity.UpdateDescription.UpdatedFields
+
+	switch changeEntity.OperationType {
+	case "insert":
+		if after == nil || after.DeletedAt != nil {
+			return event, false
+		}
+		event.Type = EventCreated
+
+	case "update", "replace":
+		if after == nil {
+			return event, false
+		}
+		deletedAt, trashChanged := updatedFields["deletedat"]
+		switch {
+		case trashChanged && deletedAt != nil:
+			event.Type = EventDeleted
+		case trashChanged:
+			event.Type = EventCreated
+		case after.DeletedAt != nil:
+			return event, false
+		case changeEntity.OperationType == "update" && onlyReorderFields(updatedFields, changeEntity.UpdateDescription.RemovedFields):
+			event.Type = EventReordered
+		default:
+			event.Type = EventUpdated
+			event.Completed = updatedFields["done"] == true
+		}
+
+	default:
+		return event, false
+	}
+
+	event.OwnerID = after.OwnerID
+	event.Todo = ConvertTodoEntitytoDTO(after)
+	return event, true
+}
+
+// TodoEventFromTombstoneChange publishes a to-do deleted for good to its
+// owner. To-dos purged from the trash were already published when they
+// were trashed.
+func TodoEventFromTombstoneChange(changeEntity *TombstoneChangeEntity) (TodoEvent, bool) {
+	event := TodoEvent{At: time.Now().UTC()}
+	tombstone := changeEntity.FullDocument
+	if changeEntity.OperationType != "insert" && changeEntity.OperationType != "update" && changeEntity.OperationType != "replace" {
+		return event, false
+	}
+	if tombstone == nil || tombstone.FromTrash {
+		return event, false
+	}
+
+	event.Type = EventDeleted
+	event.OwnerID = tombstone.OwnerID
+	event.Todo = &TodoDTO{ID: tombstone.ID}
+	return event, true
+}
+
+func onlyReorderFields(updatedFields map[string]interface{}, removedFields []string) bool {
+	if len(removedFields) > 0 {
+		return false
+	}
+	for field := range updatedFields {
+		if !reorderFields[field] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func Test_TodoEventFromChange(t *testing.T) {
+	Convey("Given a to-do in a list", t, func() {
+		todoEntity := TodoEntity{ID: "1", OwnerID: "murat", Content: "Sut al.", Done: true}
+		change := func(operationType string, updatedFields bson.M) *TodoChangeEntity {
+			changeEntity := TodoChangeEntity{OperationType: operationType, FullDocument: &todoEntity}
+			changeEntity.DocumentKey.ID = todoEntity.ID
+			changeEntity.UpdateDescription.UpdatedFields = updatedFields
+			return &changeEntity
+		}
+
+		Convey("When it is inserted", func() {
+			event, ok := TodoEventFromChange(change("insert", nil))
+
+			Convey("Then a created event should be published to its owner", func() {
+				So(ok, ShouldBeTrue)
+				So(event.Type, ShouldEqual, EventCreated)
+				So(event.OwnerID, ShouldEqual, "murat")
+				So(event.Todo.Content, ShouldEqual, "Sut al.")
+			})
+		})
+
+		Convey("When it is marked as done", func() {
+			event, ok := TodoEventFromChange(change("update", bson.M{"done": true, "version": 2}))
+
+			Convey("Then a completed update should be published", func() {
+				So(ok, ShouldBeTrue)
+				So(event.Type, ShouldEqual, EventUpdated)
+				So(event.Completed, ShouldBeTrue)
+			})
+		})
+
+		Convey("When only its index changes", func() {
+			event, _ := TodoEventFromChange(change("update", bson.M{"index": 3.5, "version": 2}))
+
+			Convey("Then a reordered event should be published", func() {
+				So(event.Type, ShouldEqual, EventReordered)
+			})
+		})
+
+		Convey("When it is moved to the trash", func() {
+			deletedAt := time.Now().UTC()
+			todoEntity.DeletedAt = &deletedAt
+			event, ok := TodoEventFromChange(change("update", bson.M{"deletedat": deletedAt}))
+
+			Convey("Then a deleted event should be published", func() {
+				So(ok, ShouldBeTrue)
+				So(event.Type, ShouldEqual, EventDeleted)
+			})
+
+			Convey("Then later changes inside the trash should be skipped", func() {
+				_, ok := TodoEventFromChange(change("update", bson.M{"content": "Ekmek al."}))
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When it is deleted by another service", func() {
+			_, ok := TodoEventFromChange(&TodoChangeEntity{OperationType: "delete", DocumentKey: change("delete", nil).DocumentKey})
+			event, tombstoneOk := TodoEventFromTombstoneChange(&TombstoneChangeEntity{
+				OperationType: "insert",
+				FullDocument:  &TombstoneEntity{ID: todoEntity.ID, OwnerID: todoEntity.OwnerID},
+			})
+
+			Convey("Then the deleted id should be published to its owner from the tombstone", func() {
+				So(ok, ShouldBeFalse)
+				So(tombstoneOk, ShouldBeTrue)
+				So(event.Type, ShouldEqual, EventDeleted)
+				So(event.OwnerID, ShouldEqual, "murat")
+				So(event.Todo.ID, ShouldEqual, "1")
+			})
+		})
+
+		Convey("When it is purged from the trash", func() {
+			_, ok := TodoEventFromTombstoneChange(&TombstoneChangeEntity{
+				OperationType: "insert",
+				FullDocument:  &TombstoneEntity{ID: todoEntity.ID, OwnerID: todoEntity.OwnerID, FromTrash: true},
+			})
+
+			Convey("Then nothing should be published again", func() {
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const FormatCSV = "csv"
+
+// CSVColumns are the columns of an export. Imports read the same columns
+// but ignore archivedAt, updatedAt, index and rank: imported to-dos are new,
+// active and keep the order of the rows.
+var CSVColumns = []string{
+	"id", "content", "done", "priority", "tags", "notes", "dueAt", "startAt",
+	"recurrence", "archivedAt", "completedAt", "createdAt", "updatedAt", "index", "rank",
+}
+
+// CSVHeaderAliases are header names spreadsheets commonly use for a column.
+var CSVHeaderAliases = map[string]string{
+	"title":       "content",
+	"task":        "content",
+	"name":        "content",
+	"summary":     "content",
+	"completed":   "done",
+	"labels":      "tags",
+	"description": "notes",
+	"due":         "dueAt",
+	"duedate":     "dueAt",
+	"start":       "startAt",
+	"startdate":   "startAt",
+	"rrule":       "recurrence",
+}
+
+// csvTimeLayouts are tried in order when a date is imported. Dates without
+// a zone are read as UTC.
+var csvTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// CSVTodoEncoder writes exported to-dos as CSV rows under a header.
+type CSVTodoEncoder struct {
+	writer *csv.Writer
+}
+
+func NewCSVTodoEncoder(w io.Writer) (*CSVTodoEncoder, error) {
+	encoder := CSVTodoEncoder{writer: csv.NewWriter(w)}
+	return &encoder, encoder.writer.Write(CSVColumns)
+}
+
+func (encoder *CSVTodoEncoder) Encode(todoDTO *TodoDTO) error {
+	return encoder.writer.Write([]string{
+		todoDTO.ID,
+		escapeCSVText(todoDTO.Content),
+		strconv.FormatBool(todoDTO.Done),
+		todoDTO.Priority,
+		escapeCSVText(strings.Join(todoDTO.Tags, ";")),
+		escapeCSVText(todoDTO.Notes),
+		formatCSVTime(todoDTO.DueAt),
+		formatCSVTime(todoDTO.StartAt),
+		escapeCSVText(todoDTO.Recurrence),
+		formatCSVTime(todoDTO.ArchivedAt),
+		formatCSVTime(todoDTO.CompletedAt),
+		formatCSVTime(&todoDTO.CreatedAt),
+		formatCSVTime(&todoDTO.UpdatedAt),
+		strconv.FormatFloat(todoDTO.Index, 'f', -1, 64),
+		todoDTO.Rank,
+	})
+}
+
+func (encoder *CSVTodoEncoder) Close() error {
+	encoder.writer.Flush()
+	return encoder.writer.Error()
+}
+
+// ParseCSVMapping reads a header mapping such as "Task:content,Deadline:dueAt".
+// Mapped headers take precedence over the column names and aliases.
+func ParseCSVMapping(mappingStr string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if mappingStr == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(mappingStr, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid mapping %q", pair)
+		}
+		column, ok := csvColumn(parts[1])
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", parts[1])
+		}
+		mapping[normalizeCSVHeader(parts[0])] = column
+	}
+	return mapping, nil
+}
+
+// DecodeCSVTodos reads the rows of a CSV file with a header. Columns are
+// matched by the mapping, then by name and alias; others are ignored. Row
+// numbers count the header as row 1.
+func DecodeCSVTodos(r io.Reader, mapping map[string]string) ([]ImportRowModel, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing header")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, len(header))
+	hasContent := false
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		column, ok := mapping[normalizeCSVHeader(name)]
+		if !ok {
+			column, _ = csvColumn(name)
+		}
+		columns[i] = column
+		hasContent = hasContent || column == "content"
+	}
+	if !hasContent {
+		return nil, errors.New("no content column")
+	}
+
+	rows := []ImportRowModel{}
+	for number := 2; ; number++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+
+		row := ImportRowModel{Row: number, Todo: &TodoDTO{}}
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			row.Error = parseErr.Err.Error()
+		case err != nil:
+			return nil, err
+		default:
+			for i, value := range record {
+				if i >= len(columns) || columns[i] == "" {
+					continue
+				}
+				if err := setCSVField(row.Todo, columns[i], value); err != nil {
+					row.Error = fmt.Sprintf("%s: %v", header[i], err)
+					break
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+func setCSVField(todoDTO *TodoDTO, column string, value string) error {
+	value = strings.TrimSpace(value)
+	var err error
+	switch column {
+	case "id":
+		todoDTO.ID = value
+	case "content":
+		todoDTO.Content = unescapeCSVText(value)
+	case "done":
+		todoDTO.Done, err = parseCSVBool(value)
+	case "priority":
+		todoDTO.Priority = value
+	case "tags":
+		todoDTO.Tags = NormalizeTags(strings.FieldsFunc(unescapeCSVText(value), func(r rune) bool {
+			return r == ';' || r == ','
+		}))
+	case "notes":
+		todoDTO.Notes = unescapeCSVText(value)
+	case "dueAt":
+		todoDTO.DueAt, err = parseCSVTime(value)
+	case "startAt":
+		todoDTO.StartAt, err = parseCSVTime(value)
+	case "recurrence":
+		todoDTO.Recurrence = unescapeCSVText(value)
+	case "completedAt":
+		todoDTO.CompletedAt, err = parseCSVTime(value)
+	case "createdAt":
+		var createdAt *time.Time
+		createdAt, err = parseCSVTime(value)
+		if createdAt != nil {
+			todoDTO.CreatedAt = *createdAt
+		}
+	}
+	return err
+}
+
+// escapeCSVText prefixes text that a spreadsheet would run as a formula
+// with a quote, which spreadsheets show as text. Text that already starts
+// with quotes before such a character gets one more, so the import can
+// always take exactly one off.
+func escapeCSVText(value string) string {
+	if isCSVFormula(value) {
+		return "'" + value
+	}
+	return value
+}
+
+func unescapeCSVText(value string) string {
+	if strings.HasPrefix(value, "'") && isCSVFormula(value) {
+		return value[1:]
+	}
+	return value
+}
+
+func isCSVFormula(value string) bool {
+	value = strings.TrimLeft(value, "'")
+	return value != "" && strings.ContainsRune("=+-@", rune(value[0]))
+}
+
+func csvColumn(name string) (string, bool) {
+	name = normalizeCSVHeader(name)
+	for _, column := range CSVColumns {
+		if strings.ToLower(column) == name {
+			return column, true
+		}
+	}
+	column, ok := CSVHeaderAliases[name]
+	return column, ok
+}
+
+func normalizeCSVHeader(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(name)))
+}
+
+func parseCSVBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", "no", "n":
+		return false, nil
+	case "yes", "y", "x":
+		return true, nil
+	}
+	done, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean %q", value)
+	}
+	return done, nil
+}
+
+func parseCSVTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range csvTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			t = t.UTC()
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid date %q", value)
+}
+
+func formatCSVTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CSVTodos(t *testing.T) {
+	Convey("Given an exported to-do", t, func() {
+		dueAt := time.Date(2021, time.August, 6, 9, 0, 0, 0, time.UTC)
+		todoDTO := TodoDTO{
+			ID:        "6f1c1d2e-4b0a-4a57-9a52-3f5a3b8d2c11",
+			Content:   "Buy milk, eggs",
+			Done:      true,
+			Priority:  "high",
+			Tags:      []string{"home", "market"},
+			Notes:     "two \"large\" ones\nfrom the corner shop",
+			DueAt:     &dueAt,
+			Index:     20,
+			CreatedAt: time.Date(2021, time.August, 1, 8, 0, 0, 0, time.UTC),
+		}
+
+		var buffer bytes.Buffer
+		encoder, err := NewCSVTodoEncoder(&buffer)
+		So(err, ShouldBeNil)
+		So(encoder.Encode(&todoDTO), ShouldBeNil)
+		So(encoder.Close(), ShouldBeNil)
+
+		Convey("When it is imported again", func() {
+			rows, err := DecodeCSVTodos(&buffer, nil)
+			So(err, ShouldBeNil)
+
+			Convey("Then the fields should survive the round trip", func() {
+				So(len(rows), ShouldEqual, 1)
+				So(rows[0].Row, ShouldEqual, 2)
+				So(rows[0].Error, ShouldBeEmpty)
+				So(rows[0].Todo.ID, ShouldEqual, todoDTO.ID)
+				So(rows[0].Todo.Content, ShouldEqual, todoDTO.Content)
+				So(rows[0].Todo.Done, ShouldBeTrue)
+				So(rows[0].Todo.Priority, ShouldEqual, "high")
+				So(rows[0].Todo.Tags, ShouldResemble, todoDTO.Tags)
+				So(rows[0].Todo.Notes, ShouldEqual, todoDTO.Notes)
+				So(*rows[0].Todo.DueAt, ShouldEqual, dueAt)
+				So(rows[0].Todo.CreatedAt, ShouldEqual, todoDTO.CreatedAt)
+			})
+		})
+	})
+
+	Convey("Given an exported to-do whose text looks like a formula", t, func() {
+		todoDTO := TodoDTO{
+			Content:    "=HYPERLINK(\"http://example.com\")",
+			Tags:       []string{"@home"},
+			Notes:      "'+1 from the team",
+			Recurrence: "-",
+		}
+
+		var buffer bytes.Buffer
+		encoder, err := NewCSVTodoEncoder(&buffer)
+		So(err, ShouldBeNil)
+		So(encoder.Encode(&todoDTO), ShouldBeNil)
+		So(encoder.Close(), ShouldBeNil)
+
+		Convey("Then no cell should start a formula", func() {
+			So(buffer.String(), ShouldContainSubstring, "'=HYPERLINK")
+			So(buffer.String(), ShouldContainSubstring, ",'@home,")
+			So(buffer.String(), ShouldContainSubstring, ",''+1 from the team,")
+			So(buffer.String(), ShouldContainSubstring, ",'-,")
+		})
+
+		Convey("When it is imported again", func() {
+			rows, err := DecodeCSVTodos(&buffer, nil)
+			So(err, ShouldBeNil)
+
+			Convey("Then the text should come back unchanged", func() {
+				So(len(rows), ShouldEqual, 1)
+				So(rows[0].Todo.Content, ShouldEqual, todoDTO.Content)
+				So(rows[0].Todo.Tags, ShouldResemble, todoDTO.Tags)
+				So(rows[0].Todo.Notes, ShouldEqual, todoDTO.Notes)
+				So(rows[0].Todo.Recurrence, ShouldEqual, todoDTO.Recurrence)
+			})
+		})
+	})
+
+	Convey("Given a spreadsheet with its own headers", t, func() {
+		source := "\ufeffTask,Deadline,Labels,Owner\n" +
+			"Write report,2021-08-06,work;urgent,murat\n" +
+			"Call bank,next week,,ayse\n"
+
+		Convey("When it is imported with a mapping", func() {
+			mapping, err := ParseCSVMapping("Deadline:dueAt")
+			So(err, ShouldBeNil)
+			rows, err := DecodeCSVTodos(strings.NewReader(source), mapping)
+			So(err, ShouldBeNil)
+
+			Convey("Then headers should be matched by mapping and alias", func() {
+				So(len(rows), ShouldEqual, 2)
+				So(rows[0].Todo.Content, ShouldEqual, "Write report")
+				So(*rows[0].Todo.DueAt, ShouldEqual, time.Date(2021, time.August, 6, 0, 0, 0, 0, time.UTC))
+				So(rows[0].Todo.Tags, ShouldResemble, []string{"work", "urgent"})
+			})
+
+			Convey("Then a row that cannot be read should be reported", func() {
+				So(rows[1].Row, ShouldEqual, 3)
+				So(rows[1].Error, ShouldContainSubstring, "Deadline")
+			})
+		})
+
+		Convey("When nothing maps to the content", func() {
+			_, err := DecodeCSVTodos(strings.NewReader("Owner\nmurat\n"), nil)
+
+			Convey("Then the file should be refused", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the mapping names an unknown column", func() {
+			_, err := ParseCSVMapping("Owner:assignee")
+
+			Convey("Then it should be refused", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const DefaultEventBufferSize = 1000
+
+// EventHeartbeatInterval keeps idle event streams from being closed by
+// proxies.
+const EventHeartbeatInterval = 15 * time.Second
+
+const (
+	EventCreated   = "created"
+	EventUpdated   = "updated"
+	EventReordered = "reordered"
+	EventDeleted   = "deleted"
+)
+
+// TodoEvent is a change of a to-do in a list. Completed marks an update
+// that marked the to-do as done.
+type TodoEvent struct {
+	ID        uint64
+	Type      string
+	OwnerID   string
+	Todo      *TodoDTO
+	Completed bool
+	At        time.Time
+}
+
+// EventSubscription delivers the buffered events after the requested one
+// in Missed and the live ones on Events. Reset reports that some events
+// were already dropped from the buffer, so the subscriber has to reload
+// the list. Events is closed when the subscriber falls too far behind.
+type EventSubscription struct {
+	Missed []TodoEvent
+	Reset  bool
+	Events <-chan TodoEvent
+	Close  func()
+}
+
+type eventSubscriber struct {
+	events chan TodoEvent
+	filter func(*TodoEvent) bool
+}
+
+// EventBus keeps the latest to-do events in a bounded ring buffer and fans
+// them out to subscribers. Event IDs start from the boot time so they keep
+// increasing across restarts.
+type EventBus struct {
+	mutex       sync.Mutex
+	buffer      []TodoEvent
+	start       int
+	nextID      uint64
+	subscribers map[*eventSubscriber]struct{}
+}
+
+func NewEventBus(size int) *EventBus {
+	return &EventBus{
+		buffer:      make([]TodoEvent, 0, size),
+		nextID:      uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		subscribers: map[*eventSubscriber]struct{}{},
+	}
+}
+
+func (bus *EventBus) Publish(event TodoEvent) TodoEvent {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	event.ID = bus.nextID
+	bus.nextID++
+
+	if len(bus.buffer) < cap(bus.buffer) {
+		bus.buffer = append(bus.buffer, event)
+	} else {
+		bus.buffer[bus.start] = event
+		bus.start = (bus.start + 1) % len(bus.buffer)
+	}
+
+	for subscriber := range bus.subscribers {
+		if !subscriber.filter(&event) {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			bus.unsubscribe(subscriber)
+		}
+	}
+
+	return event
+}
+
+// Subscribe starts delivering the events accepted by filter. A zero
+// lastEventID only subscribes to new events.
+func (bus *EventBus) Subscribe(lastEventID uint64, filter func(*TodoEvent) bool) *EventSubscription {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	subscriber := &eventSubscriber{events: make(chan TodoEvent, 64), filter: filter}
+	bus.subscribers[subscriber] = struct{}{}
+
+	subscription := EventSubscription{
+		Events: subscriber.events,
+		Close: func() {
+			bus.mutex.Lock()
+			defer bus.mutex.Unlock()
+			bus.unsubscribe(subscriber)
+		},
+	}
+	if lastEventID == 0 {
+		return &subscription
+	}
+
+	buffered := bus.buffered()
+	if lastEventID+1 < bus.nextID && (len(buffered) == 0 || buffered[0].ID > lastEventID+1) {
+		subscription.Reset = true
+	}
+	for _, event := range buffered {
+		if event.ID > lastEventID && filter(&event) {
+			subscription.Missed = append(subscription.Missed, event)
+		}
+	}
+
+	return &subscription
+}
+
+func (bus *EventBus) unsubscribe(subscriber *eventSubscriber) {
+	if _, ok := bus.subscribers[subscriber]; ok {
+		delete(bus.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+func (bus *EventBus) buffered() []TodoEvent {
+	buffered := append([]TodoEvent{}, bus.buffer[bus.start:]...)
+	return append(buffered, bus.buffer[:bus.start]...)
+}
+
+// WriteTodoEvent writes an event in the Server-Sent Events format with the
+// to-do as JSON data.
+func WriteTodoEvent(w io.Writer, event *TodoEvent) error {
+	data, err := json.Marshal(event.Todo)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
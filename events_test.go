@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_EventBus(t *testing.T) {
+	Convey("Given an event bus buffering three events", t, func() {
+		bus := NewEventBus(3)
+		all := func(*TodoEvent) bool { return true }
+
+		first := bus.Publish(TodoEvent{Type: EventCreated, Todo: &TodoDTO{ID: "1"}})
+
+		Convey("When a subscriber resumes after the first event", func() {
+			second := bus.Publish(TodoEvent{Type: EventUpdated, Todo: &TodoDTO{ID: "1"}})
+			subscription := bus.Subscribe(first.ID, all)
+			defer subscription.Close()
+
+			Convey("Then it should receive the missed event", func() {
+				So(subscription.Reset, ShouldBeFalse)
+				So(len(subscription.Missed), ShouldEqual, 1)
+				So(subscription.Missed[0].ID, ShouldEqual, second.ID)
+			})
+
+			Convey("Then it should receive new events", func() {
+				third := bus.Publish(TodoEvent{Type: EventDeleted, Todo: &TodoDTO{ID: "1"}})
+				event := <-subscription.Events
+				So(event.ID, ShouldEqual, third.ID)
+				So(event.Type, ShouldEqual, EventDeleted)
+			})
+		})
+
+		Convey("When a subscriber resumes after events left the buffer", func() {
+			for i := 0; i < 4; i++ {
+				bus.Publish(TodoEvent{Type: EventUpdated, Todo: &TodoDTO{ID: "1"}})
+			}
+			subscription := bus.Subscribe(first.ID, all)
+			defer subscription.Close()
+
+			Convey("Then it should be asked to reload", func() {
+				So(subscription.Reset, ShouldBeTrue)
+				So(len(subscription.Missed), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When a subscriber filters by owner", func() {
+			subscription := bus.Subscribe(0, func(event *TodoEvent) bool { return event.OwnerID == "murat" })
+			defer subscription.Close()
+			bus.Publish(TodoEvent{Type: EventCreated, OwnerID: "ayse", Todo: &TodoDTO{ID: "2"}})
+			bus.Publish(TodoEvent{Type: EventCreated, OwnerID: "murat", Todo: &TodoDTO{ID: "3"}})
+
+			Convey("Then it should only receive events of that owner", func() {
+				event := <-subscription.Events
+				So(event.Todo.ID, ShouldEqual, "3")
+			})
+		})
+	})
+}
+
+func Test_WriteTodoEvent(t *testing.T) {
+	Convey("Given a to-do event", t, func() {
+		event := TodoEvent{ID: 42, Type: EventCreated, Todo: &TodoDTO{ID: "1", Content: "Sut al."}}
+
+		Convey("When it is written", func() {
+			var buffer bytes.Buffer
+			err := WriteTodoEvent(&buffer, &event)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be in the Server-Sent Events format", func() {
+				So(buffer.String(), ShouldStartWith, "id: 42\nevent: created\ndata: {")
+				So(buffer.String(), ShouldContainSubstring, `"content":"Sut al."`)
+				So(buffer.String(), ShouldEndWith, "}\n\n")
+			})
+		})
+	})
+}
@@ -3,8 +3,12 @@ module example.com/todo-list
 go 1.16
 
 require (
-	github.com/gofiber/fiber/v2 v2.16.0 // indirect
-	github.com/google/uuid v1.3.0 // indirect
-	github.com/smartystreets/goconvey v1.6.4 // indirect
-	go.mongodb.org/mongo-driver v1.7.1 // indirect
+	github.com/fasthttp/websocket v0.0.0-20200320073529-1554a54587ab
+	github.com/gofiber/fiber/v2 v2.16.0
+	github.com/gofiber/websocket/v2 v2.0.7
+	github.com/golang-jwt/jwt/v4 v4.0.0
+	github.com/google/uuid v1.3.0
+	github.com/smartystreets/goconvey v1.6.4
+	github.com/yuin/goldmark v1.4.0
+	go.mongodb.org/mongo-driver v1.7.1
 )
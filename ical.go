@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const FormatICS = "ics"
+
+const (
+	icsDateTime    = "20060102T150405Z"
+	icsLocalTime   = "20060102T150405"
+	icsDate        = "20060102"
+	icsLineLength  = 75
+	icsProductID   = "-//todo-list-service//To-dos//EN"
+	icsCalendarTag = "VCALENDAR"
+	icsTodoTag     = "VTODO"
+)
+
+// icsPriorities maps priorities to RFC 5545 PRIORITY values, where 1 is the
+// highest and 0 undefined.
+var icsPriorities = map[string]int{"urgent": 1, "high": 3, "medium": 5, "low": 7}
+
+// ICSTodoEncoder writes exported to-dos as VTODO components of one
+// calendar.
+type ICSTodoEncoder struct {
+	writer *bufio.Writer
+}
+
+func NewICSTodoEncoder(w io.Writer, name string) (*ICSTodoEncoder, error) {
+	encoder := ICSTodoEncoder{writer: bufio.NewWriter(w)}
+	encoder.line("BEGIN", icsCalendarTag)
+	encoder.line("VERSION", "2.0")
+	encoder.line("PRODID", icsProductID)
+	encoder.line("CALSCALE", "GREGORIAN")
+	if name != "" {
+		encoder.line("X-WR-CALNAME", escapeICSText(name))
+	}
+	return &encoder, nil
+}
+
+func (encoder *ICSTodoEncoder) Encode(todoDTO *TodoDTO) error {
+	encoder.line("BEGIN", icsTodoTag)
+	encoder.line("UID", todoDTO.ID)
+	encoder.line("DTSTAMP", todoDTO.UpdatedAt.UTC().Format(icsDateTime))
+	encoder.line("CREATED", todoDTO.CreatedAt.UTC().Format(icsDateTime))
+	encoder.line("LAST-MODIFIED", todoDTO.UpdatedAt.UTC().Format(icsDateTime))
+	encoder.line("SUMMARY", escapeICSText(todoDTO.Content))
+	if todoDTO.Notes != "" {
+		encoder.line("DESCRIPTION", escapeICSText(todoDTO.Notes))
+	}
+	if todoDTO.StartAt != nil {
+		encoder.line("DTSTART", todoDTO.StartAt.UTC().Format(icsDateTime))
+	}
+	if todoDTO.DueAt != nil {
+		encoder.line("DUE", todoDTO.DueAt.UTC().Format(icsDateTime))
+	}
+	if priority, ok := icsPriorities[todoDTO.Priority]; ok {
+		encoder.line("PRIORITY", strconv.Itoa(priority))
+	}
+	if len(todoDTO.Tags) > 0 {
+		categories := []string{}
+		for _, tag := range todoDTO.Tags {
+			categories = append(categories, escapeICSText(tag))
+		}
+		encoder.line("CATEGORIES", strings.Join(categories, ","))
+	}
+	if todoDTO.Recurrence != "" {
+		encoder.line("RRULE", todoDTO.Recurrence)
+	}
+	if todoDTO.Done {
+		encoder.line("STATUS", "COMPLETED")
+		if todoDTO.CompletedAt != nil {
+			encoder.line("COMPLETED", todoDTO.CompletedAt.UTC().Format(icsDateTime))
+		}
+	} else {
+		encoder.line("STATUS", "NEEDS-ACTION")
+	}
+	encoder.line("END", icsTodoTag)
+	return nil
+}
+
+func (encoder *ICSTodoEncoder) Close() error {
+	encoder.line("END", icsCalendarTag)
+	return encoder.writer.Flush()
+}
+
+// line writes a content line folded into lines of at most 75 octets,
+// counting the space that starts a continuation, without splitting a UTF-8
+// sequence. Write errors surface on Close.
+func (encoder *ICSTodoEncoder) line(name string, value string) {
+	line := name + ":" + value
+	limit := icsLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		encoder.writer.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = icsLineLength - 1
+	}
+	encoder.writer.WriteString(line + "\r\n")
+}
+
+// icsProperty is one content line of a component.
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// DecodeICSTodos reads the VTODO components of a calendar. Other components
+// are skipped. Row numbers count the VTODO components from 1. UIDs that are
+// not UUIDs are turned into one, so importing a calendar twice is detected.
+func DecodeICSTodos(r io.Reader) ([]ImportRowModel, error) {
+	lines, err := unfoldICSLines(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(strings.TrimPrefix(lines[0], "\ufeff"), "BEGIN:"+icsCalendarTag) {
+		return nil, errors.New("not a calendar")
+	}
+
+	rows := []ImportRowModel{}
+	var properties []icsProperty
+	depth := 0
+	for _, line := range lines {
+		property, ok := parseICSProperty(line)
+		if !ok {
+			continue
+		}
+		switch {
+		case property.name == "BEGIN" && strings.EqualFold(property.value, icsTodoTag) && depth == 0:
+			properties = []icsProperty{}
+			depth = 1
+		case property.name == "BEGIN" && depth > 0:
+			depth++
+		case property.name == "END" && depth > 1:
+			depth--
+		case property.name == "END" && depth == 1:
+			row := ImportRowModel{Row: len(rows) + 1, Todo: &TodoDTO{}}
+			if err := setICSTodo(row.Todo, properties); err != nil {
+				row.Error = err.Error()
+			}
+			rows = append(rows, row)
+			depth = 0
+		case depth == 1:
+			properties = append(properties, property)
+		}
+	}
+	return rows, nil
+}
+
+func setICSTodo(todoDTO *TodoDTO, properties []icsProperty) error {
+	status := ""
+	for _, property := range properties {
+		var err error
+		switch property.name {
+		case "UID":
+			todoDTO.ID = ICSTodoID(property.value)
+		case "SUMMARY":
+			todoDTO.Content = unescapeICSText(property.value)
+		case "DESCRIPTION":
+			todoDTO.Notes = unescapeICSText(property.value)
+		case "DTSTART":
+			todoDTO.StartAt, err = parseICSTime(property)
+		case "DUE":
+			todoDTO.DueAt, err = parseICSTime(property)
+		case "COMPLETED":
+			todoDTO.CompletedAt, err = parseICSTime(property)
+		case "CREATED":
+			var createdAt *time.Time
+			createdAt, err = parseICSTime(property)
+			if createdAt != nil {
+				todoDTO.CreatedAt = *createdAt
+			}
+		case "PRIORITY":
+			todoDTO.Priority, err = parseICSPriority(property.value)
+		case "CATEGORIES":
+			for _, category := range splitICSList(property.value) {
+				todoDTO.Tags = append(todoDTO.Tags, unescapeICSText(category))
+			}
+		case "RRULE":
+			todoDTO.Recurrence = property.value
+		case "STATUS":
+			status = strings.ToUpper(property.value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", property.name, err)
+		}
+	}
+	todoDTO.Tags = NormalizeTags(todoDTO.Tags)
+	todoDTO.Done = status == "COMPLETED" || (status == "" && todoDTO.CompletedAt != nil)
+	return nil
+}
+
+// ICSTodoID keeps UUID UIDs, which this service exports, and derives a
+// stable UUID from any other UID.
+func ICSTodoID(uid string) string {
+	if id, err := uuid.Parse(uid); err == nil {
+		return id.String()
+	}
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("urn:ical:"+uid)).String()
+}
+
+func unfoldICSLines(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lines := []string{}
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// parseICSProperty splits "NAME;PARAM=VALUE:value". Parameter values may be
+// quoted and contain colons.
+func parseICSProperty(line string) (icsProperty, bool) {
+	property := icsProperty{params: map[string]string{}}
+
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return property, false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	property.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		keyValue := strings.SplitN(param, "=", 2)
+		if len(keyValue) == 2 {
+			property.params[strings.ToUpper(keyValue[0])] = strings.Trim(keyValue[1], `"`)
+		}
+	}
+	property.value = line[colon+1:]
+	return property, true
+}
+
+// parseICSTime reads UTC, zoned (TZID with an IANA name) and floating times
+// and dates; floating ones are read as UTC.
+func parseICSTime(property icsProperty) (*time.Time, error) {
+	location := time.UTC
+	if tzid := property.params["TZID"]; tzid != "" {
+		if zone, err := time.LoadLocation(tzid); err == nil {
+			location = zone
+		}
+	}
+
+	for _, layout := range []string{icsDateTime, icsLocalTime, icsDate} {
+		if t, err := time.ParseInLocation(layout, property.value, location); err == nil {
+			t = t.UTC()
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid date %q", property.value)
+}
+
+func parseICSPriority(value string) (string, error) {
+	priority, err := strconv.Atoi(value)
+	if err != nil || priority < 0 || priority > 9 {
+		return "", fmt.Errorf("invalid priority %q", value)
+	}
+	switch {
+	case priority == 0:
+		return "", nil
+	case priority == 1:
+		return "urgent", nil
+	case priority < 5:
+		return "high", nil
+	case priority == 5:
+		return "medium", nil
+	default:
+		return "low", nil
+	}
+}
+
+func escapeICSText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+func unescapeICSText(text string) string {
+	var builder strings.Builder
+	escaped := false
+	for _, r := range text {
+		switch {
+		case escaped && (r == 'n' || r == 'N'):
+			builder.WriteRune('\n')
+		case escaped:
+			builder.WriteRune(r)
+		case r == '\\':
+			escaped = true
+			continue
+		default:
+			builder.WriteRune(r)
+		}
+		escaped = false
+	}
+	return builder.String()
+}
+
+// splitICSList splits a comma separated value at unescaped commas.
+func splitICSList(value string) []string {
+	items := []string{}
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case ',':
+			items = append(items, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, value[start:])
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ICSTodos(t *testing.T) {
+	Convey("Given an exported to-do", t, func() {
+		dueAt := time.Date(2021, time.August, 6, 9, 0, 0, 0, time.UTC)
+		completedAt := time.Date(2021, time.August, 5, 18, 30, 0, 0, time.UTC)
+		todoDTO := TodoDTO{
+			ID:          "6f1c1d2e-4b0a-4a57-9a52-3f5a3b8d2c11",
+			Content:     "Buy milk; eggs, and bread",
+			Done:        true,
+			Priority:    "high",
+			Tags:        []string{"home", "market"},
+			Notes:       strings.Repeat("Ask for the large ones. ", 10) + "\nÇarşı",
+			DueAt:       &dueAt,
+			Recurrence:  "FREQ=WEEKLY;BYDAY=FR",
+			CompletedAt: &completedAt,
+			CreatedAt:   time.Date(2021, time.August, 1, 8, 0, 0, 0, time.UTC),
+			UpdatedAt:   time.Date(2021, time.August, 5, 18, 30, 0, 0, time.UTC),
+		}
+
+		var buffer bytes.Buffer
+		encoder, err := NewICSTodoEncoder(&buffer, "To-dos")
+		So(err, ShouldBeNil)
+		So(encoder.Encode(&todoDTO), ShouldBeNil)
+		So(encoder.Close(), ShouldBeNil)
+		calendar := buffer.String()
+
+		Convey("Then it should be a VTODO with folded lines", func() {
+			So(calendar, ShouldStartWith, "BEGIN:VCALENDAR\r\n")
+			So(calendar, ShouldContainSubstring, "UID:"+todoDTO.ID+"\r\n")
+			So(calendar, ShouldContainSubstring, "STATUS:COMPLETED\r\n")
+			So(calendar, ShouldContainSubstring, "DTSTAMP:20210805T183000Z\r\n")
+			So(calendar, ShouldContainSubstring, "LAST-MODIFIED:20210805T183000Z\r\n")
+			for _, line := range strings.Split(calendar, "\r\n") {
+				So(len(line), ShouldBeLessThanOrEqualTo, 75)
+			}
+		})
+
+		Convey("When it is imported again", func() {
+			rows, err := DecodeICSTodos(&buffer)
+			So(err, ShouldBeNil)
+
+			Convey("Then the fields should survive the round trip", func() {
+				So(len(rows), ShouldEqual, 1)
+				So(rows[0].Error, ShouldBeEmpty)
+				So(rows[0].Todo.ID, ShouldEqual, todoDTO.ID)
+				So(rows[0].Todo.Content, ShouldEqual, todoDTO.Content)
+				So(rows[0].Todo.Notes, ShouldEqual, todoDTO.Notes)
+				So(rows[0].Todo.Done, ShouldBeTrue)
+				So(rows[0].Todo.Priority, ShouldEqual, "high")
+				So(rows[0].Todo.Tags, ShouldResemble, todoDTO.Tags)
+				So(rows[0].Todo.Recurrence, ShouldEqual, todoDTO.Recurrence)
+				So(*rows[0].Todo.DueAt, ShouldEqual, dueAt)
+				So(*rows[0].Todo.CompletedAt, ShouldEqual, completedAt)
+				So(rows[0].Todo.CreatedAt, ShouldEqual, todoDTO.CreatedAt)
+			})
+		})
+	})
+
+	Convey("Given a calendar from another app", t, func() {
+		source := "BEGIN:VCALENDAR\r\n" +
+			"BEGIN:VEVENT\r\nUID:meeting@example.com\r\nSUMMARY:Meeting\r\nEND:VEVENT\r\n" +
+			"BEGIN:VTODO\r\nUID:task-1@example.com\r\nSUMMARY:Pay the\r\n  rent\r\n" +
+			"DUE;TZID=Europe/Istanbul:20210806T090000\r\nPRIORITY:9\r\n" +
+			"BEGIN:VALARM\r\nTRIGGER:-PT15M\r\nDESCRIPTION:Reminder\r\nEND:VALARM\r\n" +
+			"END:VTODO\r\n" +
+			"BEGIN:VTODO\r\nSUMMARY:Broken\r\nDUE:tomorrow\r\nEND:VTODO\r\n" +
+			"END:VCALENDAR\r\n"
+
+		Convey("When it is imported", func() {
+			rows, err := DecodeICSTodos(strings.NewReader(source))
+			So(err, ShouldBeNil)
+
+			Convey("Then only the VTODO components should be read", func() {
+				So(len(rows), ShouldEqual, 2)
+				So(rows[0].Todo.Content, ShouldEqual, "Pay the rent")
+				So(rows[0].Todo.Notes, ShouldBeEmpty)
+				So(rows[0].Todo.Priority, ShouldEqual, "low")
+				So(*rows[0].Todo.DueAt, ShouldEqual, time.Date(2021, time.August, 6, 6, 0, 0, 0, time.UTC))
+			})
+
+			Convey("Then the UID should become a stable id", func() {
+				So(rows[0].Todo.ID, ShouldEqual, ICSTodoID("task-1@example.com"))
+				So(rows[0].Todo.ID, ShouldNotEqual, ICSTodoID("task-2@example.com"))
+			})
+
+			Convey("Then a component that cannot be read should be reported", func() {
+				So(rows[1].Row, ShouldEqual, 2)
+				So(rows[1].Error, ShouldContainSubstring, "DUE")
+			})
+		})
+	})
+}
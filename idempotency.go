@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyRecord is the first response to a request with an
+// Idempotency-Key. A zero Status marks a request that is still running.
+type IdempotencyRecord struct {
+	RequestHash string
+	Status      int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore keeps the records for the idempotency window. The
+// in-memory store only covers a single instance; a shared implementation
+// covers retries that reach another instance.
+type IdempotencyStore interface {
+	// Begin claims the key for a new request and returns nil, or returns
+	// the record of an earlier request with the same key.
+	Begin(key string, requestHash string, expiresAt time.Time) (*IdempotencyRecord, error)
+	Complete(key string, record *IdempotencyRecord) error
+	Abort(key string) error
+}
+
+type MemoryIdempotencyStore struct {
+	mutex     sync.Mutex
+	records   map[string]*IdempotencyRecord
+	sweptAt   time.Time
+	sweepEach time.Duration
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records:   map[string]*IdempotencyRecord{},
+		sweepEach: time.Minute,
+	}
+}
+
+func (store *MemoryIdempotencyStore) Begin(key string, requestHash string, expiresAt time.Time) (*IdempotencyRecord, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	store.sweep(now)
+
+	if record, ok := store.records[key]; ok && !record.ExpiresAt.Before(now) {
+		return record, nil
+	}
+	store.records[key] = &IdempotencyRecord{RequestHash: requestHash, ExpiresAt: expiresAt}
+	return nil, nil
+}
+
+// sweep forgets expired records. Begin ignores an expired record on its
+// own, so sweeping at most once in a while only bounds the memory.
+func (store *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(store.sweptAt) < store.sweepEach {
+		return
+	}
+	store.sweptAt = now
+
+	for key, record := range store.records {
+		if record.ExpiresAt.Before(now) {
+			delete(store.records, key)
+		}
+	}
+}
+
+func (store *MemoryIdempotencyStore) Complete(key string, record *IdempotencyRecord) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.records[key] = record
+	return nil
+}
+
+func (store *MemoryIdempotencyStore) Abort(key string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.records, key)
+	return nil
+}
+
+type Idempotency struct {
+	store  IdempotencyStore
+	window time.Duration
+}
+
+func NewIdempotency(store IdempotencyStore, window time.Duration) *Idempotency {
+	return &Idempotency{
+		store:  store,
+		window: window,
+	}
+}
+
+// Middleware replays the stored response of a POST request retried with
+// the same Idempotency-Key. Reusing a key with a different body is
+// rejected with 422 and a retry that overtakes the first request with 409.
+// Failed requests are not stored, so they can be retried. Keys are scoped
+// to the caller, so it has to run after authentication.
+func (idempotency *Idempotency) Middleware(ctx *fiber.Ctx) error {
+	idempotencyKey := ctx.Get(IdempotencyKeyHeader)
+	if ctx.Method() != fiber.MethodPost || idempotencyKey == "" {
+		return ctx.Next()
+	}
+
+	key := idempotencyScope(ctx) + " " + ctx.Path() + " " + idempotencyKey
+	sum := sha256.Sum256(ctx.Body())
+	requestHash := hex.EncodeToString(sum[:])
+
+	record, err := idempotency.store.Begin(key, requestHash, time.Now().Add(idempotency.window))
+	if err != nil {
+		ctx.Status(fiber.StatusInternalServerError)
+		return err
+	}
+
+	if record != nil {
+		switch {
+		case record.RequestHash != requestHash:
+			ctx.Status(fiber.StatusUnprocessableEntity)
+			return fiber.ErrUnprocessableEntity
+		case record.Status == 0:
+			ctx.Status(fiber.StatusConflict)
+			return fiber.ErrConflict
+		default:
+			ctx.Set("Idempotent-Replayed", "true")
+			ctx.Set(fiber.HeaderContentType, record.ContentType)
+			return ctx.Status(record.Status).Send(record.Body)
+		}
+	}
+
+	err = ctx.Next()
+	status := ctx.Response().StatusCode()
+	if err != nil || status >= fiber.StatusInternalServerError {
+		idempotency.store.Abort(key)
+		return err
+	}
+
+	return idempotency.store.Complete(key, &IdempotencyRecord{
+		RequestHash: requestHash,
+		Status:      status,
+		ContentType: string(ctx.Response().Header.ContentType()),
+		Body:        append([]byte(nil), ctx.Response().Body()...),
+		ExpiresAt:   time.Now().Add(idempotency.window),
+	})
+}
+
+// idempotencyScope keeps the keys of different callers and tenants apart.
+func idempotencyScope(ctx *fiber.Ctx) string {
+	tenant, _ := ctx.Locals(TenantKey).(string)
+	if principal, ok := ctx.Locals(PrincipalKey).(*Principal); ok {
+		return tenant + " principal:" + principal.ID
+	}
+	return tenant + " " + RateLimitKey(ctx)
+}
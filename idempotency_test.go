@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Idempotency(t *testing.T) {
+	Convey("Given an app creating to-dos behind idempotency keys", t, func() {
+		created := 0
+		app := fiber.New()
+		app.Use(NewIdempotency(NewMemoryIdempotencyStore(), time.Hour).Middleware)
+		app.Post("/todo", func(ctx *fiber.Ctx) error {
+			created++
+			ctx.Status(fiber.StatusCreated)
+			return ctx.JSON(map[string]string{"id": strconv.Itoa(created)})
+		})
+
+		send := func(key string, body string) (int, string, string) {
+			req := httptest.NewRequest("POST", "/todo", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(IdempotencyKeyHeader, key)
+			resp, err := app.Test(req)
+			So(err, ShouldBeNil)
+			responseBody, _ := ioutil.ReadAll(resp.Body)
+			return resp.StatusCode, string(responseBody), resp.Header.Get("Idempotent-Replayed")
+		}
+
+		Convey("When a request is retried with the same key", func() {
+			firstStatus, firstBody, _ := send("retry-1", `{"content":"Sut al."}`)
+			secondStatus, secondBody, replayed := send("retry-1", `{"content":"Sut al."}`)
+
+			Convey("Then the first response should be replayed", func() {
+				So(created, ShouldEqual, 1)
+				So(firstStatus, ShouldEqual, fiber.StatusCreated)
+				So(secondStatus, ShouldEqual, fiber.StatusCreated)
+				So(secondBody, ShouldEqual, firstBody)
+				So(replayed, ShouldEqual, "true")
+			})
+		})
+
+		Convey("When the key is reused with a different body", func() {
+			send("retry-2", `{"content":"Sut al."}`)
+			status, _, _ := send("retry-2", `{"content":"Ekmek al."}`)
+
+			Convey("Then Status Code Should be 422", func() {
+				So(status, ShouldEqual, fiber.StatusUnprocessableEntity)
+				So(created, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When different keys are sent", func() {
+			send("retry-3", `{"content":"Sut al."}`)
+			send("retry-4", `{"content":"Sut al."}`)
+
+			Convey("Then both requests should be handled", func() {
+				So(created, ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func Test_MemoryIdempotencyStore(t *testing.T) {
+	Convey("Given a store with an expired record", t, func() {
+		store := NewMemoryIdempotencyStore()
+		store.Begin("expired", "hash", time.Now().Add(-time.Second))
+
+		Convey("When the key is used again before the sweep", func() {
+			store.sweptAt = time.Now()
+			record, err := store.Begin("expired", "other", time.Now().Add(time.Hour))
+
+			Convey("Then the expired record should be ignored", func() {
+				So(err, ShouldBeNil)
+				So(record, ShouldBeNil)
+			})
+		})
+
+		Convey("When another key is used after the sweep interval", func() {
+			store.sweptAt = time.Now().Add(-2 * store.sweepEach)
+			store.Begin("fresh", "hash", time.Now().Add(time.Hour))
+
+			Convey("Then the expired record should be forgotten", func() {
+				So(store.records, ShouldNotContainKey, "expired")
+				So(store.records, ShouldContainKey, "fresh")
+			})
+		})
+
+		Convey("When another key is used within the sweep interval", func() {
+			store.sweptAt = time.Now()
+			store.Begin("fresh", "hash", time.Now().Add(time.Hour))
+
+			Convey("Then the records should not be scanned", func() {
+				So(store.records, ShouldContainKey, "expired")
+			})
+		})
+	})
+}
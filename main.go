@@ -2,38 +2,234 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
 )
 
 type ServiceConfig struct {
-	Port       string
-	MongoDBURL string
+	Port              string
+	MongoDBURL        string
+	ReminderInterval  time.Duration
+	TrashRetention    time.Duration
+	AutoArchiveAfter  time.Duration
+	WebhookURL        string
+	SmtpAddr          string
+	SmtpUsername      string
+	SmtpPassword      string
+	SmtpFrom          string
+	SmtpTo            []string
+	Auth              AuthConfig
+	Tenant            TenantConfig
+	RateLimit         RateLimitConfig
+	TodoQuota         int
+	IdempotencyWindow time.Duration
+	WebhookInterval   time.Duration
+	WebhookHosts      WebhookHosts
+	ChangeStream      string
 }
 
 func main() {
 	fmt.Println("todo-list service started...")
 
 	config := ServiceConfig{
-		Port:       ":8080",
-		MongoDBURL: "mongodb://localhost:27017",
+		Port:             ":8080",
+		MongoDBURL:       "mongodb://localhost:27017",
+		ReminderInterval: 30 * time.Second,
+		TrashRetention:   30 * 24 * time.Hour,
+		AutoArchiveAfter: 7 * 24 * time.Hour,
+		WebhookURL:       os.Getenv("REMINDER_WEBHOOK_URL"),
+		SmtpAddr:         os.Getenv("REMINDER_SMTP_ADDR"),
+		SmtpUsername:     os.Getenv("REMINDER_SMTP_USERNAME"),
+		SmtpPassword:     os.Getenv("REMINDER_SMTP_PASSWORD"),
+		SmtpFrom:         os.Getenv("REMINDER_SMTP_FROM"),
+		SmtpTo:           strings.Fields(os.Getenv("REMINDER_SMTP_TO")),
+		Auth: AuthConfig{
+			APIKeys:   ParseAPIKeys(os.Getenv("AUTH_API_KEYS")),
+			JWTSecret: os.Getenv("AUTH_JWT_SECRET"),
+			JWKSFile:  os.Getenv("AUTH_JWKS_FILE"),
+		},
+		Tenant: TenantConfig{
+			Source: os.Getenv("TENANT_SOURCE"),
+			Header: "X-Tenant-ID",
+			Domain: os.Getenv("TENANT_DOMAIN"),
+			Claim:  "tenant",
+		},
+		RateLimit: RateLimitConfig{
+			Rate:  envFloat("RATE_LIMIT_RATE", 5),
+			Burst: envInt("RATE_LIMIT_BURST", 20),
+		},
+		TodoQuota:         envInt("TODO_QUOTA", 10000),
+		IdempotencyWindow: envDuration("IDEMPOTENCY_WINDOW", 24*time.Hour),
+		WebhookInterval:   envDuration("WEBHOOK_INTERVAL", 10*time.Second),
+		WebhookHosts:      strings.Fields(os.Getenv("WEBHOOK_ALLOWED_HOSTS")),
+		ChangeStream:      os.Getenv("CHANGE_STREAM_NAME"),
 	}
 	repository := NewRepository(config.MongoDBURL)
+	err := repository.ForEachTenantRepository(func(repository *Repository) error {
+		if err := repository.CreateIndexesRepository(); err != nil {
+			return err
+		}
+		migrated, err := repository.MigrateRanksRepository()
+		if migrated > 0 {
+			log.Printf("ranked %d to-dos by their index", migrated)
+		}
+		return err
+	})
+	if err != nil {
+		log.Println("preparing the database:", err)
+	}
 	service := NewService(repository)
+	service.SetTodoQuota(config.TodoQuota)
+	service.SetWebhookHosts(config.WebhookHosts)
+	if config.ChangeStream != "" {
+		stopChangeStream := service.EnableChangeStream(config.ChangeStream)
+		defer stopChangeStream()
+	}
 	api := NewAPI(service)
-	app := ServiceSetup(api)
+
+	handlers := []fiber.Handler{}
+	var limiter *RateLimiter
+	if config.RateLimit.Rate > 0 {
+		limiter = NewRateLimiter(config.RateLimit, NewMemoryRateLimitStore())
+	}
+	if config.Auth.Enabled() {
+		authenticator, err := NewAuthenticator(config.Auth, repository)
+		if err != nil {
+			log.Fatalln("loading authentication:", err)
+		}
+		if limiter != nil {
+			handlers = append(handlers, limiter.LimitFailures(authenticator.Middleware))
+		} else {
+			handlers = append(handlers, authenticator.Middleware)
+		}
+	} else {
+		log.Println("authentication is disabled, set AUTH_API_KEYS, AUTH_JWT_SECRET or AUTH_JWKS_FILE")
+	}
+	if limiter != nil {
+		handlers = append(handlers, limiter.Middleware)
+	}
+	if config.Tenant.Source != "" {
+		handlers = append(handlers, TenantMiddleware(config.Tenant))
+	}
+	handlers = append(handlers, NewIdempotency(NewMemoryIdempotencyStore(), config.IdempotencyWindow).Middleware)
+	app := ServiceSetup(api, handlers...)
+
+	scheduler := NewReminderScheduler(repository, ReminderNotifiers(config)...)
+	stopScheduler := scheduler.Start(config.ReminderInterval)
+	defer stopScheduler()
+
+	dispatcher := NewWebhookDispatcher(repository, config.WebhookHosts)
+	stopDispatcher := dispatcher.Start(config.WebhookInterval)
+	defer stopDispatcher()
+
+	stopTrashPurge := StartWorker("trash purge", time.Hour, func() error {
+		return service.ForEachTenant(func(service *Service) error {
+			return service.PurgeExpiredTrashService(config.TrashRetention)
+		})
+	})
+	defer stopTrashPurge()
+
+	stopAutoArchive := StartWorker("auto archive", time.Hour, func() error {
+		return service.ForEachTenant(func(service *Service) error {
+			return service.AutoArchiveService(config.AutoArchiveAfter)
+		})
+	})
+	defer stopAutoArchive()
 
 	app.Listen(config.Port)
 }
 
-func ServiceSetup(api *Api) *fiber.App {
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func envFloat(name string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func ReminderNotifiers(config ServiceConfig) []Notifier {
+	notifiers := []Notifier{NewLogNotifier(log.Default())}
+	if config.WebhookURL != "" {
+		notifiers = append(notifiers, NewWebhookNotifier(config.WebhookURL))
+	}
+	if config.SmtpAddr != "" {
+		notifiers = append(notifiers, NewSmtpNotifier(config.SmtpAddr, config.SmtpUsername, config.SmtpPassword, config.SmtpFrom, config.SmtpTo))
+	}
+	return notifiers
+}
+
+// ServiceSetup registers the routes behind the given middleware, such as
+// Authenticator.Middleware. Calendar feeds come first: their token is the
+// credential.
+func ServiceSetup(api *Api, handlers ...fiber.Handler) *fiber.App {
 	app := fiber.New()
+	app.Get("/ical/:token", api.GetCalendarFeedApi)
+	for _, handler := range handlers {
+		app.Use(handler)
+	}
 	app.Post("/todo", api.PostTodoApi)
 	app.Get("/todo", api.GetTodoListApi)
+	app.Get("/todo/events", api.GetTodoEventsApi)
+	app.Get("/todo/export", api.GetExportApi)
+	app.Post("/todo/import", api.PostImportApi)
+	app.Get("/ws", api.UpgradeLiveApi, websocket.New(api.LiveApi))
 	app.Get("/todo/:id", api.GetTodoApi)
 	app.Put("/todo/:id", api.PutTodoApi)
 	app.Put("/sort", api.PutSortApi)
 	app.Delete("/todo/:id", api.DeleteTodoApi)
+	app.Post("/todo/:id/restore", api.PostRestoreTodoApi)
+	app.Post("/todo/:id/archive", api.PostArchiveTodoApi)
+	app.Post("/todo/:id/unarchive", api.PostUnarchiveTodoApi)
+	app.Get("/todo/:id/history", api.GetTodoHistoryApi)
+	app.Get("/audit", RequireScope(ScopeAdmin), api.GetAuditApi)
+	app.Post("/undo", api.PostUndoApi)
+	app.Post("/redo", api.PostRedoApi)
+	app.Get("/trash", api.GetTrashApi)
+	app.Delete("/trash", api.DeleteTrashApi)
+	app.Delete("/trash/:id", api.DeleteTrashTodoApi)
+	app.Get("/tags", api.GetTagsApi)
+	app.Put("/tags/:name", api.PutTagApi)
+	app.Post("/tags/merge", api.PostMergeTagsApi)
+	app.Get("/calendar/feeds", api.GetCalendarFeedsApi)
+	app.Post("/calendar/feeds", api.PostCalendarFeedApi)
+	app.Delete("/calendar/feeds/:id", api.DeleteCalendarFeedApi)
+	app.Get("/sync", api.GetSyncApi)
+	app.Post("/sync", api.PostSyncApi)
+	app.Get("/shares", api.GetSharesApi)
+	app.Post("/shares", api.PostShareApi)
+	app.Delete("/shares/:userId", api.DeleteShareApi)
+	app.Get("/shared", api.GetSharedListsApi)
+	app.Get("/webhooks", api.GetWebhooksApi)
+	app.Post("/webhooks", api.PostWebhookApi)
+	app.Get("/webhooks/dead-letters", api.GetDeadLettersApi)
+	app.Post("/webhooks/deliveries/:id/retry", api.PostRetryDeliveryApi)
+	app.Delete("/webhooks/:id", api.DeleteWebhookApi)
+	app.Get("/webhooks/:id/deliveries", api.GetWebhookDeliveriesApi)
+	app.Get("/tenants", RequireScope(ScopeAdmin), api.GetTenantsApi)
+	app.Post("/tenants", RequireScope(ScopeAdmin), api.PostTenantApi)
+	app.Delete("/tenants/:name", RequireScope(ScopeAdmin), api.DeleteTenantApi)
 
 	return app
 }
@@ -2,17 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func Test_TodoPost(t *testing.T) {
@@ -56,7 +62,7 @@ func Test_TodoPost(t *testing.T) {
 					So(returnedData.ID, ShouldNotBeEmpty)
 					So(returnedData.Content, ShouldEqual, todo.Content)
 					So(returnedData.Done, ShouldEqual, todo.Done)
-					repository.DeleteTodoRepository(returnedData.ID)
+					repository.DeleteTodoRepository("", returnedData.ID)
 				})
 			})
 		})
@@ -108,7 +114,7 @@ func Test_TodoGet(t *testing.T) {
 				})
 			})
 		})
-		repository.DeleteTodoRepository(todoID)
+		repository.DeleteTodoRepository("", todoID)
 	})
 }
 
@@ -195,10 +201,262 @@ func Test_TodoListGet(t *testing.T) {
 				})
 			})
 		})
-		repository.DeleteTodoRepository(todoID1)
-		repository.DeleteTodoRepository(todoID2)
-		repository.DeleteTodoRepository(todoID3)
-		repository.DeleteTodoRepository(todoID4)
+		repository.DeleteTodoRepository("", todoID1)
+		repository.DeleteTodoRepository("", todoID2)
+		repository.DeleteTodoRepository("", todoID3)
+		repository.DeleteTodoRepository("", todoID4)
+	})
+}
+
+func Test_TodoListGetOverdue(t *testing.T) {
+	Convey("Given to-do models with due dates in database", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		api := NewAPI(service)
+
+		pastDueAt := time.Now().Add(-48 * time.Hour).Round(time.Minute).UTC()
+		futureDueAt := time.Now().Add(48 * time.Hour).Round(time.Minute).UTC()
+
+		todoID1 := uuid.New().String()
+		todoID2 := uuid.New().String()
+		todoID3 := uuid.New().String()
+
+		todoModel1 := TodoModel{
+			ID:        todoID1,
+			Content:   "To-do overdue request olustur.",
+			Done:      false,
+			DueAt:     &pastDueAt,
+			CratedAt:  time.Now().Round(time.Minute).UTC(),
+			UpdatedAt: time.Now().Round(time.Minute).UTC(),
+		}
+		todoModel2 := TodoModel{
+			ID:        todoID2,
+			Content:   "To-do overdue request olustur. Tamamlandi",
+			Done:      true,
+			DueAt:     &pastDueAt,
+			CratedAt:  time.Now().Round(time.Minute).UTC(),
+			UpdatedAt: time.Now().Round(time.Minute).UTC(),
+		}
+		todoModel3 := TodoModel{
+			ID:        todoID3,
+			Content:   "To-do overdue request olustur. Gelecek",
+			Done:      false,
+			DueAt:     &futureDueAt,
+			CratedAt:  time.Now().Round(time.Minute).UTC(),
+			UpdatedAt: time.Now().Round(time.Minute).UTC(),
+		}
+
+		repository.AddTodoRepository(&todoModel1)
+		repository.AddTodoRepository(&todoModel2)
+		repository.AddTodoRepository(&todoModel3)
+
+		Convey("When I get request with overdue filter", func() {
+			request, _ := http.NewRequest(http.MethodGet, "/todo?due=overdue", nil)
+
+			request.Header.Add("Content-Type", "application/json")
+
+			app := ServiceSetup(api)
+			response, err := app.Test(request, 20000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 200", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
+
+				Convey("Then only overdue to-do Should be returned", func() {
+					responseBody, err := ioutil.ReadAll(response.Body)
+					So(err, ShouldBeNil)
+
+					returnedData := TodoListDTO{}
+
+					err = json.Unmarshal(responseBody, &returnedData)
+					So(err, ShouldBeNil)
+					So(len(returnedData.TodoList), ShouldEqual, 1)
+					So(returnedData.TodoList[0].ID, ShouldEqual, todoID1)
+					So(returnedData.TodoList[0].Overdue, ShouldBeTrue)
+				})
+			})
+		})
+		repository.DeleteTodoRepository("", todoID1)
+		repository.DeleteTodoRepository("", todoID2)
+		repository.DeleteTodoRepository("", todoID3)
+	})
+}
+
+func Test_TodoListGetSmartSort(t *testing.T) {
+	Convey("Given to-do models with priorities in database", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		api := NewAPI(service)
+
+		dueAt := time.Now().Add(24 * time.Hour).Round(time.Minute).UTC()
+
+		todoID1 := uuid.New().String()
+		todoID2 := uuid.New().String()
+		todoID3 := uuid.New().String()
+
+		todoModel1 := TodoModel{
+			ID:        todoID1,
+			Content:   "To-do smart sort request olustur.",
+			Index:     2,
+			Priority:  PriorityLow,
+			CratedAt:  time.Now().Round(time.Minute).UTC(),
+			UpdatedAt: time.Now().Round(time.Minute).UTC(),
+		}
+		todoModel2 := TodoModel{
+			ID:        todoID2,
+			Content:   "To-do smart sort request olustur. Acil",
+			Index:     0,
+			Priority:  PriorityUrgent,
+			CratedAt:  time.Now().Round(time.Minute).UTC(),
+			UpdatedAt: time.Now().Round(time.Minute).UTC(),
+		}
+		todoModel3 := TodoModel{
+			ID:        todoID3,
+			Content:   "To-do smart sort request olustur. Tarihli",
+			Index:     1,
+			Priority:  PriorityLow,
+			DueAt:     &dueAt,
+			CratedAt:  time.Now().Round(time.Minute).UTC(),
+			UpdatedAt: time.Now().Round(time.Minute).UTC(),
+		}
+
+		repository.AddTodoRepository(&todoModel1)
+		repository.AddTodoRepository(&todoModel2)
+		repository.AddTodoRepository(&todoModel3)
+
+		Convey("When I get request with smart sort", func() {
+			request, _ := http.NewRequest(http.MethodGet, "/todo?sort=smart&priority=low,urgent", nil)
+
+			request.Header.Add("Content-Type", "application/json")
+
+			app := ServiceSetup(api)
+			response, err := app.Test(request, 20000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 200", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
+
+				Convey("Then to-do list Should be ordered by priority then due date", func() {
+					responseBody, err := ioutil.ReadAll(response.Body)
+					So(err, ShouldBeNil)
+
+					returnedData := TodoListDTO{}
+
+					err = json.Unmarshal(responseBody, &returnedData)
+					So(err, ShouldBeNil)
+					So(len(returnedData.TodoList), ShouldEqual, 3)
+					So(returnedData.TodoList[0].ID, ShouldEqual, todoID2)
+					So(returnedData.TodoList[0].Priority, ShouldEqual, "urgent")
+					So(returnedData.TodoList[1].ID, ShouldEqual, todoID3)
+					So(returnedData.TodoList[2].ID, ShouldEqual, todoID1)
+				})
+			})
+		})
+
+		Convey("When I get request for to-dos without priority", func() {
+			legacyID := uuid.New().String()
+			_, err := repository.collection("todolist").InsertOne(context.Background(), bson.M{
+				"_id":     legacyID,
+				"content": "To-do smart sort request olustur. Eski",
+			})
+			So(err, ShouldBeNil)
+
+			request, _ := http.NewRequest(http.MethodGet, "/todo?priority=none", nil)
+			app := ServiceSetup(api)
+			response, err := app.Test(request, 20000)
+			So(err, ShouldBeNil)
+
+			Convey("Then to-dos saved before priorities should be listed", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
+				returnedData := TodoListDTO{}
+				So(json.NewDecoder(response.Body).Decode(&returnedData), ShouldBeNil)
+				So(len(returnedData.TodoList), ShouldEqual, 1)
+				So(returnedData.TodoList[0].ID, ShouldEqual, legacyID)
+			})
+			repository.DeleteTodoRepository("", legacyID)
+		})
+		repository.DeleteTodoRepository("", todoID1)
+		repository.DeleteTodoRepository("", todoID2)
+		repository.DeleteTodoRepository("", todoID3)
+	})
+}
+
+func Test_TagMerge(t *testing.T) {
+	Convey("Given tagged to-do models in database", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		api := NewAPI(service)
+
+		oldTag := "bekliyor-" + uuid.New().String()
+		newTag := "waiting-" + uuid.New().String()
+
+		todoID := uuid.New().String()
+		todoModel := TodoModel{
+			ID:        todoID,
+			Content:   "To-do tag merge request olustur.",
+			Tags:      []string{"backend", oldTag, "urgent"},
+			CratedAt:  time.Now().Round(time.Minute).UTC(),
+			UpdatedAt: time.Now().Round(time.Minute).UTC(),
+		}
+		trashedID := uuid.New().String()
+		trashedModel := TodoModel{
+			ID:        trashedID,
+			Content:   "To-do tag merge request olustur. Silindi",
+			Tags:      []string{oldTag},
+			CratedAt:  time.Now().Round(time.Minute).UTC(),
+			UpdatedAt: time.Now().Round(time.Minute).UTC(),
+		}
+
+		repository.AddTodoRepository(&todoModel)
+		repository.AddTodoRepository(&trashedModel)
+		repository.TrashTodoRepository("", trashedID, TodoTime())
+
+		Convey("When I merge tags request", func() {
+			tagMergeByte, _ := json.Marshal(TagMergeDTO{From: []string{oldTag}, Into: newTag})
+			request, _ := http.NewRequest(http.MethodPost, "/tags/merge", bytes.NewReader(tagMergeByte))
+
+			request.Header.Add("Content-Type", "application/json")
+
+			app := ServiceSetup(api)
+			response, err := app.Test(request, 20000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 200", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
+
+				Convey("Then to-do Should be found by the new tag", func() {
+					request, _ := http.NewRequest(http.MethodGet, fmt.Sprint("/todo?tag=", newTag), nil)
+					response, err := app.Test(request, 20000)
+					So(err, ShouldBeNil)
+
+					responseBody, err := ioutil.ReadAll(response.Body)
+					So(err, ShouldBeNil)
+
+					returnedData := TodoListDTO{}
+
+					err = json.Unmarshal(responseBody, &returnedData)
+					So(err, ShouldBeNil)
+					So(len(returnedData.TodoList), ShouldEqual, 1)
+					So(returnedData.TodoList[0].ID, ShouldEqual, todoID)
+					So(returnedData.TodoList[0].Tags, ShouldResemble, []string{"backend", newTag, "urgent"})
+				})
+
+				Convey("Then trashed to-dos should keep the old tag", func() {
+					todoEntity, err := repository.GetTodoRepository("", trashedID)
+					So(err, ShouldBeNil)
+					So(todoEntity.Tags, ShouldResemble, []string{oldTag})
+				})
+
+				Convey("Then the change should be recorded in the history", func() {
+					historyData, err := service.GetTodoHistoryService(&Actor{}, todoID, 0, 20)
+					So(err, ShouldBeNil)
+					So(historyData.History[0].Action, ShouldEqual, ActionUpdate)
+					So(historyData.History[0].Changes[0].Field, ShouldEqual, "tags")
+				})
+			})
+		})
+		repository.DeleteTodoRepository("", todoID)
+		repository.DeleteTodoRepository("", trashedID)
 	})
 }
 
@@ -252,7 +510,83 @@ func Test_TodoUpdate(t *testing.T) {
 				})
 			})
 		})
-		repository.DeleteTodoRepository(todoID)
+		repository.DeleteTodoRepository("", todoID)
+	})
+}
+
+func Test_TodoRecurrence(t *testing.T) {
+	Convey("Given recurring to-do in database", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		api := NewAPI(service)
+		ownerID := uuid.New().String()
+		authenticator, _ := NewAuthenticator(AuthConfig{
+			APIKeys: []APIKeyConfig{{Hash: HashAPIKey("carol-key"), Principal: ownerID}},
+		}, nil)
+		app := ServiceSetup(api, authenticator.Middleware)
+
+		owner := &Actor{ID: ownerID, OwnerID: ownerID}
+		createdData, err := service.PostTodoService(owner, &TodoDTO{Content: "To-do recurrence request olustur.", Recurrence: "FREQ=DAILY"})
+		So(err, ShouldBeNil)
+
+		put := func(done bool) int {
+			todoByte, _ := json.Marshal(TodoDTO{Content: createdData.Content, Recurrence: createdData.Recurrence, Done: done})
+			request, _ := http.NewRequest(http.MethodPut, fmt.Sprint("/todo/", createdData.ID), bytes.NewReader(todoByte))
+
+			request.Header.Add("Content-Type", "application/json")
+			request.Header.Add("X-API-Key", "carol-key")
+
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+			return response.StatusCode
+		}
+		series := func() int {
+			todoListEntity, _, err := repository.GetTodoListRepository(ownerID, &TodoFilterModel{SeriesID: createdData.ID}, 0, 0)
+			So(err, ShouldBeNil)
+			return len(todoListEntity.TodoList)
+		}
+
+		Convey("When I put request completing it", func() {
+			So(put(true), ShouldEqual, fiber.StatusOK)
+
+			Convey("Then the next occurrence Should be created", func() {
+				So(series(), ShouldEqual, 2)
+			})
+
+			Convey("When I reopen and complete it again", func() {
+				So(put(false), ShouldEqual, fiber.StatusOK)
+				So(put(true), ShouldEqual, fiber.StatusOK)
+
+				Convey("Then no other occurrence Should be created", func() {
+					So(series(), ShouldEqual, 2)
+				})
+			})
+		})
+
+		Convey("When a series in a timezone is completed across a daylight saving change", func() {
+			dueAt := time.Date(2021, time.March, 27, 8, 0, 0, 0, time.UTC)
+			zonedData, err := service.PostTodoService(owner, &TodoDTO{Content: "To-do recurrence request timezone.", Recurrence: "FREQ=DAILY", Timezone: "Europe/Berlin", DueAt: &dueAt})
+			So(err, ShouldBeNil)
+			_, err = service.UpdateTodoService(owner, zonedData.ID, &TodoDTO{Content: zonedData.Content, Recurrence: zonedData.Recurrence, Timezone: zonedData.Timezone, DueAt: &dueAt, Done: true})
+			So(err, ShouldBeNil)
+
+			Convey("Then the next occurrence Should keep its local time", func() {
+				todoListEntity, _, err := repository.GetTodoListRepository(ownerID, &TodoFilterModel{SeriesID: zonedData.ID}, 0, 0)
+				So(err, ShouldBeNil)
+				So(len(todoListEntity.TodoList), ShouldEqual, 2)
+				for _, todoEntity := range todoListEntity.TodoList {
+					if todoEntity.Occurrence == 2 {
+						So(todoEntity.Timezone, ShouldEqual, "Europe/Berlin")
+						So(todoEntity.DueAt.UTC(), ShouldEqual, time.Date(2021, time.March, 28, 7, 0, 0, 0, time.UTC))
+					}
+				}
+			})
+		})
+
+		todoListEntity, _, _ := repository.GetTodoListRepository(ownerID, nil, 0, 0)
+		for _, todoEntity := range todoListEntity.TodoList {
+			repository.DeleteTodoRepository(ownerID, todoEntity.ID)
+		}
 	})
 }
 
@@ -307,19 +641,90 @@ func Test_TodoSortUpdate(t *testing.T) {
 			Convey("Then Status Code Should be 200", func() {
 				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
 
-				returnedData, _, _ := repository.GetTodoListRepository(0, 0)
+				returnedData, _, _ := repository.GetTodoListRepository("", nil, 0, 0)
 
 				Convey("Then to-do Should be returned", func() {
 					So(len(returnedData.TodoList), ShouldEqual, 3)
 					So(returnedData.TodoList[0].Index, ShouldEqual, todoModel2.Index)
 					So(returnedData.TodoList[1].Index, ShouldEqual, ((float64(todoModel2.Index) + float64(todoModel1.Index)) / 2))
+					So(returnedData.TodoList[1].ID, ShouldEqual, todoID3)
+					So(returnedData.TodoList[1].Rank, ShouldBeBetween, returnedData.TodoList[2].Rank, returnedData.TodoList[0].Rank)
 					So(returnedData.TodoList[2].Index, ShouldEqual, todoModel1.Index)
 				})
 			})
 		})
-		repository.DeleteTodoRepository(todoID1)
-		repository.DeleteTodoRepository(todoID2)
-		repository.DeleteTodoRepository(todoID3)
+
+		Convey("When I put request with an unknown backId", func() {
+			request, _ := http.NewRequest(http.MethodPut, fmt.Sprint("/sort?backId=", uuid.New().String(), "&currentId=", todoID3, "&frontId=", todoID2), nil)
+
+			app := ServiceSetup(api)
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 404", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusNotFound)
+			})
+		})
+
+		Convey("When I put request with a backId of another user's list", func() {
+			otherID := uuid.New().String()
+			repository.AddTodoRepository(&TodoModel{
+				ID:        otherID,
+				OwnerID:   "alice",
+				Content:   "To-do put request olustur.",
+				CratedAt:  time.Now().Round(time.Minute).UTC(),
+				UpdatedAt: time.Now().Round(time.Minute).UTC(),
+			})
+			request, _ := http.NewRequest(http.MethodPut, fmt.Sprint("/sort?backId=", otherID, "&currentId=", todoID3), nil)
+
+			app := ServiceSetup(api)
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 404", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusNotFound)
+			})
+			repository.DeleteTodoRepository("alice", otherID)
+		})
+
+		Convey("When I put request between two to-dos with the same rank", func() {
+			repository.UpdateTodoSortRepository("", todoID1, todoModel1.Index, "V")
+			repository.UpdateTodoSortRepository("", todoID2, todoModel2.Index, "V")
+			backID, frontID := todoID1, todoID2
+			if backID > frontID {
+				backID, frontID = frontID, backID
+			}
+			request, _ := http.NewRequest(http.MethodPut, fmt.Sprint("/sort?backId=", backID, "&currentId=", todoID3, "&frontId=", frontID), nil)
+
+			app := ServiceSetup(api)
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+
+			Convey("Then the to-do should be listed between them", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
+				returnedData, _, _ := repository.GetTodoListRepository("", nil, 0, 0)
+				So(len(returnedData.TodoList), ShouldEqual, 3)
+				So(returnedData.TodoList[0].ID, ShouldEqual, frontID)
+				So(returnedData.TodoList[1].ID, ShouldEqual, todoID3)
+				So(returnedData.TodoList[2].ID, ShouldEqual, backID)
+				So(returnedData.TodoList[2].Rank, ShouldEqual, "V")
+			})
+		})
+
+		Convey("When I put request without neighbours", func() {
+			request, _ := http.NewRequest(http.MethodPut, fmt.Sprint("/sort?currentId=", todoID3), nil)
+
+			app := ServiceSetup(api)
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 400", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusBadRequest)
+			})
+		})
+		repository.DeleteTodoRepository("", todoID1)
+		repository.DeleteTodoRepository("", todoID2)
+		repository.DeleteTodoRepository("", todoID3)
 	})
 }
 
@@ -352,7 +757,848 @@ func Test_TodoDelete(t *testing.T) {
 			Convey("Then Status Code Should be 200", func() {
 				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
 			})
+
+			Convey("Then the trashing should count as the latest change", func() {
+				todoEntity, err := repository.GetTodoRepository("", todoID)
+				So(err, ShouldBeNil)
+				So(todoEntity.DeletedAt, ShouldNotBeNil)
+				So(todoEntity.UpdatedAt, ShouldEqual, *todoEntity.DeletedAt)
+			})
+		})
+		repository.DeleteTodoRepository("", todoID)
+	})
+}
+
+func Test_TodoRestore(t *testing.T) {
+	Convey("Given to-do model in trash", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		api := NewAPI(service)
+
+		todoID := uuid.New().String()
+		todoModel := TodoModel{
+			ID:        todoID,
+			Content:   "To-do restore request olustur.",
+			Done:      false,
+			Index:     7,
+			CratedAt:  time.Now().Round(time.Minute).UTC(),
+			UpdatedAt: time.Now().Round(time.Minute).UTC(),
+		}
+
+		repository.AddTodoRepository(&todoModel)
+		repository.TrashTodoRepository("", todoID, time.Now().UTC())
+
+		Convey("When I restore request", func() {
+			request, _ := http.NewRequest(http.MethodPost, fmt.Sprint("/todo/", todoID, "/restore"), nil)
+
+			request.Header.Add("Content-Type", "application/json")
+
+			app := ServiceSetup(api)
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 200", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
+
+				Convey("Then to-do Should be returned at its previous index", func() {
+					responseBody, err := ioutil.ReadAll(response.Body)
+					So(err, ShouldBeNil)
+
+					returnedData := TodoDTO{}
+
+					err = json.Unmarshal(responseBody, &returnedData)
+					So(err, ShouldBeNil)
+
+					So(returnedData.ID, ShouldEqual, todoID)
+					So(returnedData.Index, ShouldEqual, todoModel.Index)
+					So(returnedData.DeletedAt, ShouldBeNil)
+				})
+			})
+		})
+		repository.DeleteTodoRepository("", todoID)
+	})
+}
+
+func Test_TodoHistory(t *testing.T) {
+	Convey("Given to-do created through the service", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		api := NewAPI(service)
+
+		actor := &Actor{ID: "murat"}
+		createdData, err := service.PostTodoService(actor, &TodoDTO{Content: "To-do history request olustur."})
+		So(err, ShouldBeNil)
+		_, err = service.UpdateTodoService(actor, createdData.ID, &TodoDTO{Content: createdData.Content, Done: true})
+		So(err, ShouldBeNil)
+
+		Convey("When I get history request", func() {
+			request, _ := http.NewRequest(http.MethodGet, fmt.Sprint("/todo/", createdData.ID, "/history"), nil)
+
+			request.Header.Add("Content-Type", "application/json")
+
+			app := ServiceSetup(api)
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 200", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
+
+				Convey("Then history entries Should be returned newest first", func() {
+					responseBody, err := ioutil.ReadAll(response.Body)
+					So(err, ShouldBeNil)
+
+					returnedData := HistoryListDTO{}
+
+					err = json.Unmarshal(responseBody, &returnedData)
+					So(err, ShouldBeNil)
+					So(len(returnedData.History), ShouldEqual, 2)
+					So(returnedData.History[0].Action, ShouldEqual, ActionUpdate)
+					So(returnedData.History[0].Actor, ShouldEqual, actor.ID)
+					So(returnedData.History[1].Action, ShouldEqual, ActionCreate)
+
+					changedFields := []string{}
+					for _, change := range returnedData.History[0].Changes {
+						changedFields = append(changedFields, change.Field)
+					}
+					So(changedFields, ShouldContain, "done")
+				})
+			})
+		})
+
+		Convey("When only the content is updated", func() {
+			_, err := service.UpdateTodoService(actor, createdData.ID, &TodoDTO{Content: "To-do history request olustur. Update edildi", Done: true})
+			So(err, ShouldBeNil)
+			historyData, err := service.GetTodoHistoryService(actor, createdData.ID, 0, 20)
+			So(err, ShouldBeNil)
+
+			Convey("Then exactly one field change should be recorded", func() {
+				So(len(historyData.History[0].Changes), ShouldEqual, 1)
+				So(historyData.History[0].Changes[0].Field, ShouldEqual, "content")
+			})
+		})
+		repository.DeleteTodoRepository("", createdData.ID)
+	})
+}
+
+func Test_TodoUndo(t *testing.T) {
+	Convey("Given to-do updated through the service", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		api := NewAPI(service)
+
+		actor := &Actor{ID: uuid.New().String()}
+		createdData, err := service.PostTodoService(actor, &TodoDTO{Content: "To-do undo request olustur."})
+		So(err, ShouldBeNil)
+		_, err = service.UpdateTodoService(actor, createdData.ID, &TodoDTO{Content: "To-do undo request olustur. Update edildi"})
+		So(err, ShouldBeNil)
+
+		Convey("When I undo request", func() {
+			request, _ := http.NewRequest(http.MethodPost, "/undo", nil)
+
+			request.Header.Add("Content-Type", "application/json")
+			request.Header.Add("X-Actor", actor.ID)
+
+			app := ServiceSetup(api)
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 200", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
+
+				Convey("Then to-do Should be returned with its previous content", func() {
+					responseBody, err := ioutil.ReadAll(response.Body)
+					So(err, ShouldBeNil)
+
+					returnedData := UndoDTO{}
+
+					err = json.Unmarshal(responseBody, &returnedData)
+					So(err, ShouldBeNil)
+					So(returnedData.Operations, ShouldResemble, []string{ActionUpdate})
+					So(len(returnedData.TodoList), ShouldEqual, 1)
+					So(returnedData.TodoList[0].Content, ShouldEqual, createdData.Content)
+				})
+			})
+		})
+		repository.DeleteTodoRepository("", createdData.ID)
+	})
+
+	Convey("Given to-do updated by an editor whose share was revoked", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+
+		owner := &Actor{ID: uuid.New().String()}
+		owner.OwnerID = owner.ID
+		createdData, err := service.PostTodoService(owner, &TodoDTO{Content: "To-do undo request olustur."})
+		So(err, ShouldBeNil)
+
+		editorID := uuid.New().String()
+		_, err = service.ShareListService(owner, &ShareDTO{UserID: editorID, Role: RoleEditor})
+		So(err, ShouldBeNil)
+		_, err = service.UpdateTodoService(&Actor{ID: editorID, OwnerID: owner.ID}, createdData.ID, &TodoDTO{Content: "To-do undo request olustur. Update edildi"})
+		So(err, ShouldBeNil)
+		So(service.RevokeShareService(owner, editorID), ShouldBeNil)
+
+		Convey("When the editor undoes on their own list", func() {
+			_, err := service.UndoService(&Actor{ID: editorID, OwnerID: editorID}, 1)
+
+			Convey("Then it should be forbidden and the to-do left as it is", func() {
+				So(err, ShouldEqual, fiber.ErrForbidden)
+				todoEntity, err := repository.GetTodoRepository(owner.ID, createdData.ID)
+				So(err, ShouldBeNil)
+				So(todoEntity.Content, ShouldEqual, "To-do undo request olustur. Update edildi")
+			})
+
+			Convey("Then the operation should be dropped", func() {
+				_, err := service.UndoService(&Actor{ID: editorID, OwnerID: editorID}, 1)
+				So(err, ShouldEqual, fiber.ErrNotFound)
+			})
+		})
+		repository.DeleteTodoRepository(owner.ID, createdData.ID)
+	})
+
+	Convey("Given to-do changed again after an update", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+
+		actor := &Actor{ID: uuid.New().String()}
+		actor.OwnerID = actor.ID
+		createdData, err := service.PostTodoService(actor, &TodoDTO{Content: "To-do undo request olustur."})
+		So(err, ShouldBeNil)
+		_, err = service.UpdateTodoService(actor, createdData.ID, &TodoDTO{Content: "To-do undo request olustur. Update edildi"})
+		So(err, ShouldBeNil)
+		_, err = repository.UpdateTodoSortRepository(actor.OwnerID, createdData.ID, 5, "V")
+		So(err, ShouldBeNil)
+
+		Convey("When the update is undone", func() {
+			_, err := service.UndoService(actor, 1)
+
+			Convey("Then it should conflict and the operation should be kept", func() {
+				So(err, ShouldEqual, fiber.ErrConflict)
+				_, err := service.UndoService(actor, 1)
+				So(err, ShouldEqual, fiber.ErrConflict)
+			})
+		})
+		repository.DeleteTodoRepository(actor.OwnerID, createdData.ID)
+	})
+
+	Convey("Given an undo whose second write fails", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+
+		actor := &Actor{ID: uuid.New().String()}
+		actor.OwnerID = actor.ID
+		createdData, err := service.PostTodoService(actor, &TodoDTO{Content: "To-do undo request olustur."})
+		So(err, ShouldBeNil)
+		_, err = service.UpdateTodoService(actor, createdData.ID, &TodoDTO{Content: "To-do undo request olustur. Update edildi"})
+		So(err, ShouldBeNil)
+
+		// The other to-do is restored last and its id is taken in another
+		// list, so the insert fails after the update was reverted.
+		otherID := uuid.New().String()
+		repository.AddTodoRepository(&TodoModel{ID: otherID, OwnerID: "alice", Content: "To-do undo request olustur."})
+		update := service.undoStack.PopUndo(actor.ID)
+		service.undoStack.Push(actor.ID, &Operation{Action: ActionUpdate, Changes: []TodoChange{
+			{Before: &TodoEntity{ID: otherID, OwnerID: actor.OwnerID, Content: "To-do undo request olustur."}},
+			update.Changes[0],
+		}})
+
+		Convey("When it is undone", func() {
+			_, err := service.UndoService(actor, 1)
+
+			Convey("Then the reverted to-do should be rolled back", func() {
+				So(err, ShouldEqual, fiber.ErrConflict)
+				todoEntity, err := repository.GetTodoRepository(actor.OwnerID, createdData.ID)
+				So(err, ShouldBeNil)
+				So(todoEntity.Content, ShouldEqual, "To-do undo request olustur. Update edildi")
+			})
+		})
+		repository.DeleteTodoRepository("alice", otherID)
+		repository.DeleteTodoRepository(actor.OwnerID, createdData.ID)
+	})
+}
+
+func Test_TodoArchive(t *testing.T) {
+	Convey("Given done to-do model in database", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		api := NewAPI(service)
+
+		todoID := uuid.New().String()
+		todoModel := TodoModel{
+			ID:        todoID,
+			Content:   "To-do archive request olustur.",
+			Done:      true,
+			CratedAt:  time.Now().Round(time.Minute).UTC(),
+			UpdatedAt: time.Now().Round(time.Minute).UTC(),
+		}
+
+		repository.AddTodoRepository(&todoModel)
+
+		Convey("When I archive request", func() {
+			request, _ := http.NewRequest(http.MethodPost, fmt.Sprint("/todo/", todoID, "/archive"), nil)
+
+			request.Header.Add("Content-Type", "application/json")
+
+			app := ServiceSetup(api)
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 200", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
+
+				Convey("Then to-do Should only be listed with archived filter", func() {
+					archived, _, err := repository.GetTodoListRepository("", &TodoFilterModel{Archived: &[]bool{true}[0]}, 0, 0)
+					So(err, ShouldBeNil)
+					active, _, err := repository.GetTodoListRepository("", &TodoFilterModel{Archived: &[]bool{false}[0]}, 0, 0)
+					So(err, ShouldBeNil)
+
+					archivedIDs := []string{}
+					for _, todoEntity := range archived.TodoList {
+						archivedIDs = append(archivedIDs, todoEntity.ID)
+					}
+					activeIDs := []string{}
+					for _, todoEntity := range active.TodoList {
+						activeIDs = append(activeIDs, todoEntity.ID)
+					}
+					So(archivedIDs, ShouldContain, todoID)
+					So(activeIDs, ShouldNotContain, todoID)
+				})
+			})
+		})
+		repository.DeleteTodoRepository("", todoID)
+	})
+}
+
+func Test_TodoOwnership(t *testing.T) {
+	Convey("Given to-do model owned by another user in database", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		api := NewAPI(service)
+		authenticator, _ := NewAuthenticator(AuthConfig{
+			APIKeys: []APIKeyConfig{{Hash: HashAPIKey("bob-key"), Principal: "bob"}},
+		}, nil)
+
+		todoID := uuid.New().String()
+		todoModel := TodoModel{
+			ID:        todoID,
+			OwnerID:   "alice",
+			Content:   "To-do ownership request olustur.",
+			CratedAt:  time.Now().Round(time.Minute).UTC(),
+			UpdatedAt: time.Now().Round(time.Minute).UTC(),
+		}
+
+		repository.AddTodoRepository(&todoModel)
+
+		Convey("When I get request as a different user", func() {
+			request, _ := http.NewRequest(http.MethodGet, fmt.Sprint("/todo/", todoID), nil)
+
+			request.Header.Add("Content-Type", "application/json")
+			request.Header.Add("X-API-Key", "bob-key")
+
+			app := ServiceSetup(api, authenticator.Middleware)
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 404", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusNotFound)
+			})
+		})
+
+		Convey("When I work on the reserved owner id", func() {
+			err := service.AuthorizeService(&Actor{ID: "bob", OwnerID: ReservedOwnerID}, RoleViewer)
+
+			Convey("Then it should be forbidden", func() {
+				So(err, ShouldEqual, fiber.ErrForbidden)
+			})
+		})
+
+		Convey("When I list to-dos as a different user", func() {
+			todoListEntity, _, err := repository.GetTodoListRepository("bob", nil, 0, 0)
+			So(err, ShouldBeNil)
+
+			Convey("Then to-do Should not be listed", func() {
+				ids := []string{}
+				for _, todoEntity := range todoListEntity.TodoList {
+					ids = append(ids, todoEntity.ID)
+				}
+				So(ids, ShouldNotContain, todoID)
+			})
+		})
+		repository.DeleteTodoRepository("alice", todoID)
+	})
+}
+
+func Test_TodoShare(t *testing.T) {
+	Convey("Given to-do list shared with a viewer", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		api := NewAPI(service)
+		authenticator, _ := NewAuthenticator(AuthConfig{
+			APIKeys: []APIKeyConfig{{Hash: HashAPIKey("bob-key"), Principal: "bob"}},
+		}, nil)
+
+		owner := &Actor{ID: uuid.New().String()}
+		owner.OwnerID = owner.ID
+		createdData, err := service.PostTodoService(owner, &TodoDTO{Content: "To-do share request olustur."})
+		So(err, ShouldBeNil)
+		_, err = service.ShareListService(owner, &ShareDTO{UserID: "bob", Role: RoleViewer})
+		So(err, ShouldBeNil)
+
+		app := ServiceSetup(api, authenticator.Middleware)
+
+		Convey("When the viewer gets request", func() {
+			request, _ := http.NewRequest(http.MethodGet, fmt.Sprint("/todo/", createdData.ID), nil)
+
+			request.Header.Add("Content-Type", "application/json")
+			request.Header.Add("X-API-Key", "bob-key")
+			request.Header.Add("X-List-Owner", owner.ID)
+
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 200", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
+			})
+		})
+
+		Convey("When the viewer put request", func() {
+			requestByte, _ := json.Marshal(TodoDTO{Content: "To-do share request update."})
+			request, _ := http.NewRequest(http.MethodPut, fmt.Sprint("/todo/", createdData.ID), bytes.NewReader(requestByte))
+
+			request.Header.Add("Content-Type", "application/json")
+			request.Header.Add("X-API-Key", "bob-key")
+			request.Header.Add("X-List-Owner", owner.ID)
+
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+
+			Convey("Then Status Code Should be 403", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusForbidden)
+			})
+		})
+		service.RevokeShareService(owner, "bob")
+		repository.DeleteTodoRepository(owner.ID, createdData.ID)
+	})
+}
+
+func Test_TodoWebhook(t *testing.T) {
+	Convey("Given a webhook subscribed to completed to-dos", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		service.SetWebhookHosts(WebhookHosts{"127.0.0.1"})
+		dispatcher := NewWebhookDispatcher(repository, WebhookHosts{"127.0.0.1"})
+
+		status := make(chan int, 10)
+		received := make(chan *http.Request, 10)
+		payloads := make(chan []byte, 10)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			received <- r
+			payloads <- body
+			w.WriteHeader(<-status)
+		}))
+		defer server.Close()
+
+		actor := &Actor{ID: uuid.New().String()}
+		actor.OwnerID = actor.ID
+		webhookData, err := service.CreateWebhookService(actor, &WebhookDTO{URL: server.URL, Events: []string{EventCompleted}, Secret: "s3cret"})
+		So(err, ShouldBeNil)
+
+		createdData, err := service.PostTodoService(actor, &TodoDTO{Content: "To-do webhook request olustur."})
+		So(err, ShouldBeNil)
+		_, err = service.UpdateTodoService(actor, createdData.ID, &TodoDTO{Content: createdData.Content, Done: true})
+		So(err, ShouldBeNil)
+
+		Convey("When the receiver accepts the delivery", func() {
+			status <- http.StatusNoContent
+			So(dispatcher.RunOnce(), ShouldBeNil)
+
+			Convey("Then it should receive a signed completed event", func() {
+				request := <-received
+				body := <-payloads
+				So(request.Header.Get(WebhookEventHeader), ShouldEqual, EventCompleted)
+				So(request.Header.Get(WebhookSignatureHeader), ShouldEqual, SignWebhookPayload("s3cret", body))
+
+				payload := WebhookPayload{}
+				So(json.Unmarshal(body, &payload), ShouldBeNil)
+				So(payload.Todo.ID, ShouldEqual, createdData.ID)
+				So(payload.Todo.Done, ShouldBeTrue)
+
+				deliveries, err := service.GetWebhookDeliveriesService(actor, webhookData.ID, 0, 20)
+				So(err, ShouldBeNil)
+				So(len(deliveries.Deliveries), ShouldEqual, 1)
+				So(deliveries.Deliveries[0].Status, ShouldEqual, WebhookDeliveryDelivered)
+			})
+		})
+
+		Convey("When the receiver keeps failing", func() {
+			dispatcher.maxAttempts = 1
+			status <- http.StatusInternalServerError
+			So(dispatcher.RunOnce(), ShouldBeNil)
+
+			Convey("Then the delivery should end up in the dead letters", func() {
+				deadLetters, err := service.GetDeadLettersService(actor, 0, 20)
+				So(err, ShouldBeNil)
+				So(len(deadLetters.Deliveries), ShouldEqual, 1)
+				So(deadLetters.Deliveries[0].LastStatusCode, ShouldEqual, http.StatusInternalServerError)
+
+				retried, err := service.RetryWebhookDeliveryService(actor, deadLetters.Deliveries[0].ID)
+				So(err, ShouldBeNil)
+				So(retried.Status, ShouldEqual, WebhookDeliveryPending)
+			})
+		})
+
+		service.DeleteWebhookService(actor, webhookData.ID)
+		dispatcher.maxAttempts = 1
+		dispatcher.RunOnce()
+		repository.DeleteTodoRepository(actor.OwnerID, createdData.ID)
+	})
+}
+
+func Test_TodoSync(t *testing.T) {
+	Convey("Given a synced to-do list", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+
+		actor := &Actor{ID: uuid.New().String()}
+		actor.OwnerID = actor.ID
+		createdData, err := service.PostTodoService(actor, &TodoDTO{Content: "To-do sync request olustur."})
+		So(err, ShouldBeNil)
+
+		initial, err := service.GetSyncService(actor, "", DefaultSyncLimit)
+		So(err, ShouldBeNil)
+		So(len(initial.Todos), ShouldEqual, 1)
+
+		Convey("When the to-do is changed and purged", func() {
+			_, err := service.UpdateTodoService(actor, createdData.ID, &TodoDTO{Content: "To-do sync request update."})
+			So(err, ShouldBeNil)
+			otherData, err := service.PostTodoService(actor, &TodoDTO{Content: "To-do sync request purge."})
+			So(err, ShouldBeNil)
+			So(service.DeleteTodoService(actor, otherData.ID), ShouldBeNil)
+			So(service.PurgeTodoService(actor, otherData.ID), ShouldBeNil)
+
+			delta, err := service.GetSyncService(actor, initial.Token, DefaultSyncLimit)
+			So(err, ShouldBeNil)
+
+			Convey("Then the delta should hold the change and a tombstone", func() {
+				So(len(delta.Todos), ShouldEqual, 1)
+				So(delta.Todos[0].Content, ShouldEqual, "To-do sync request update.")
+				So(len(delta.Deleted), ShouldEqual, 1)
+				So(delta.Deleted[0].ID, ShouldEqual, otherData.ID)
+			})
+		})
+
+		Convey("When a write is still in flight", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+			heldSeq, release, err := repository.nextSeq(ctx)
+			So(err, ShouldBeNil)
+			_, err = service.UpdateTodoService(actor, createdData.ID, &TodoDTO{Content: "To-do sync request update."})
+			So(err, ShouldBeNil)
+
+			delta, err := service.GetSyncService(actor, initial.Token, DefaultSyncLimit)
+			So(err, ShouldBeNil)
+
+			Convey("Then later writes should wait for it", func() {
+				So(len(delta.Todos), ShouldEqual, 0)
+				token, _ := strconv.ParseInt(delta.Token, 10, 64)
+				So(token, ShouldBeLessThan, heldSeq)
+
+				release()
+				delta, err := service.GetSyncService(actor, delta.Token, DefaultSyncLimit)
+				So(err, ShouldBeNil)
+				So(len(delta.Todos), ShouldEqual, 1)
+			})
+			release()
+		})
+
+		Convey("When a client pushes a change made right after the server write", func() {
+			pushed, err := service.PushSyncService(actor, &SyncPushDTO{Changes: []SyncChangeDTO{{
+				ID:        createdData.ID,
+				Todo:      &TodoDTO{Content: "To-do sync request offline."},
+				UpdatedAt: time.Now(),
+			}}})
+			So(err, ShouldBeNil)
+
+			Convey("Then the change should win", func() {
+				So(pushed.Results[0].Status, ShouldEqual, SyncApplied)
+				So(pushed.Results[0].Todo.Content, ShouldEqual, "To-do sync request offline.")
+			})
+		})
+
+		Convey("When a client pushes an older change", func() {
+			pushed, err := service.PushSyncService(actor, &SyncPushDTO{Changes: []SyncChangeDTO{{
+				ID:        createdData.ID,
+				Todo:      &TodoDTO{Content: "To-do sync request offline."},
+				UpdatedAt: time.Now().Add(-time.Hour),
+			}}})
+			So(err, ShouldBeNil)
+
+			Convey("Then the newer server version should win", func() {
+				So(pushed.Results[0].Status, ShouldEqual, SyncConflict)
+				So(pushed.Results[0].Todo.Content, ShouldEqual, "To-do sync request olustur.")
+			})
+		})
+
+		Convey("When a client pushes a new to-do", func() {
+			id := uuid.New().String()
+			pushed, err := service.PushSyncService(actor, &SyncPushDTO{Changes: []SyncChangeDTO{{
+				ID:        id,
+				Todo:      &TodoDTO{Content: "To-do sync request created offline."},
+				UpdatedAt: time.Now(),
+			}}})
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be created with the client id", func() {
+				So(pushed.Results[0].Status, ShouldEqual, SyncApplied)
+				So(pushed.Results[0].Todo.ID, ShouldEqual, id)
+			})
+			repository.DeleteTodoRepository(actor.OwnerID, id)
+		})
+
+		Convey("When a client pushes a new to-do with the id of a to-do in another list", func() {
+			other := &Actor{ID: uuid.New().String()}
+			other.OwnerID = other.ID
+			pushed, err := service.PushSyncService(other, &SyncPushDTO{Changes: []SyncChangeDTO{{
+				ID:        createdData.ID,
+				Todo:      &TodoDTO{Content: "To-do sync request taken id."},
+				UpdatedAt: time.Now(),
+			}}})
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be created with a new id", func() {
+				So(pushed.Results[0].Status, ShouldEqual, SyncApplied)
+				So(pushed.Results[0].ID, ShouldEqual, createdData.ID)
+				So(pushed.Results[0].Todo.ID, ShouldNotEqual, createdData.ID)
+				So(pushed.Results[0].Todo.Content, ShouldEqual, "To-do sync request taken id.")
+
+				todoData, err := service.GetTodoService(actor, createdData.ID)
+				So(err, ShouldBeNil)
+				So(todoData.Content, ShouldEqual, "To-do sync request olustur.")
+			})
+			repository.DeleteTodoRepository(other.OwnerID, pushed.Results[0].Todo.ID)
+		})
+
+		repository.DeleteTodoRepository(actor.OwnerID, createdData.ID)
+	})
+}
+
+func Test_TodoImportExport(t *testing.T) {
+	Convey("Given a CSV file", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		api := NewAPI(service)
+		app := ServiceSetup(api)
+
+		source := "title,done,due\n" +
+			"To-do import request first.,no,2021-08-06\n" +
+			",yes,\n" +
+			"To-do import request second.,yes,\n"
+		importCSV := func(query string) *ImportResultDTO {
+			request, _ := http.NewRequest(http.MethodPost, "/todo/import?format=csv"+query, strings.NewReader(source))
+			request.Header.Add("Content-Type", "text/csv")
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+			So(response.StatusCode, ShouldEqual, fiber.StatusOK)
+
+			result := ImportResultDTO{}
+			So(json.NewDecoder(response.Body).Decode(&result), ShouldBeNil)
+			return &result
+		}
+
+		Convey("When it is imported as a dry run", func() {
+			result := importCSV("&dryRun=true")
+
+			Convey("Then the rows should be checked but not created", func() {
+				So(result.Valid, ShouldEqual, 2)
+				So(result.Created, ShouldEqual, 0)
+				So(result.Rows[1].Status, ShouldEqual, ImportFailed)
+				So(result.Rows[1].Row, ShouldEqual, 3)
+			})
+		})
+
+		Convey("When it is imported and exported", func() {
+			result := importCSV("")
+			So(result.Created, ShouldEqual, 2)
+			So(result.Failed, ShouldEqual, 1)
+			first, second := result.Rows[0].Todo, result.Rows[2].Todo
+
+			request, _ := http.NewRequest(http.MethodGet, "/todo/export?format=csv", nil)
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+			rows, err := DecodeCSVTodos(response.Body, nil)
+			So(err, ShouldBeNil)
+
+			Convey("Then the to-dos should keep the order of the file", func() {
+				So(first.Index, ShouldBeGreaterThan, second.Index)
+				So(first.Rank, ShouldBeGreaterThan, second.Rank)
+				So(second.CompletedAt, ShouldNotBeNil)
+				So(rows[0].Todo.ID, ShouldEqual, first.ID)
+				So(rows[1].Todo.ID, ShouldEqual, second.ID)
+			})
+
+			Convey("When the export is imported again", func() {
+				request, _ := http.NewRequest(http.MethodGet, "/todo/export?format=csv", nil)
+				response, err := app.Test(request, 30000)
+				So(err, ShouldBeNil)
+				request, _ = http.NewRequest(http.MethodPost, "/todo/import?format=csv", response.Body)
+				request.Header.Add("Content-Type", "text/csv")
+				response, err = app.Test(request, 30000)
+				So(err, ShouldBeNil)
+				again := ImportResultDTO{}
+				So(json.NewDecoder(response.Body).Decode(&again), ShouldBeNil)
+
+				Convey("Then the rows should be added under new ids", func() {
+					So(again.Failed, ShouldEqual, 0)
+					So(again.Created, ShouldBeGreaterThanOrEqualTo, 2)
+					for _, row := range again.Rows {
+						So(row.Todo.ID, ShouldNotEqual, first.ID)
+						So(row.Todo.ID, ShouldNotEqual, second.ID)
+						repository.DeleteTodoRepository("", row.Todo.ID)
+					}
+				})
+			})
+
+			repository.DeleteTodoRepository("", first.ID)
+			repository.DeleteTodoRepository("", second.ID)
+		})
+	})
+}
+
+func Test_TodoCalendarFeed(t *testing.T) {
+	Convey("Given a to-do list with a calendar feed", t, func() {
+		repository := GetTestRepository()
+		service := NewService(repository)
+		api := NewAPI(service)
+		app := ServiceSetup(api)
+
+		actor := &Actor{ID: uuid.New().String()}
+		actor.OwnerID = actor.ID
+		createdData, err := service.PostTodoService(actor, &TodoDTO{Content: "To-do calendar request olustur."})
+		So(err, ShouldBeNil)
+		feedData, err := service.CreateCalendarFeedService(actor, "")
+		So(err, ShouldBeNil)
+
+		Convey("When a calendar app reads the feed", func() {
+			request, _ := http.NewRequest(http.MethodGet, "/ical/"+feedData.Token+".ics", nil)
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+			rows, err := DecodeICSTodos(response.Body)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should receive the to-dos as VTODO components", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusOK)
+				So(len(rows), ShouldEqual, 1)
+				So(rows[0].Todo.ID, ShouldEqual, createdData.ID)
+			})
+		})
+
+		Convey("When the feed is deleted", func() {
+			So(service.DeleteCalendarFeedService(actor, "", feedData.ID), ShouldBeNil)
+			request, _ := http.NewRequest(http.MethodGet, "/ical/"+feedData.Token+".ics", nil)
+			response, err := app.Test(request, 30000)
+			So(err, ShouldBeNil)
+
+			Convey("Then the URL should no longer work", func() {
+				So(response.StatusCode, ShouldEqual, fiber.StatusNotFound)
+			})
+		})
+
+		service.DeleteCalendarFeedService(actor, "", feedData.ID)
+		repository.DeleteTodoRepository(actor.OwnerID, createdData.ID)
+	})
+}
+
+// testNotifier records the to-dos it is notified about and fails while
+// err is set.
+type testNotifier struct {
+	err     error
+	todoIDs []string
+}
+
+func (notifier *testNotifier) Notify(notification *ReminderNotification) error {
+	notifier.todoIDs = append(notifier.todoIDs, notification.TodoID)
+	return notifier.err
+}
+
+func (notifier *testNotifier) count(todoID string) int {
+	count := 0
+	for _, id := range notifier.todoIDs {
+		if id == todoID {
+			count++
+		}
+	}
+	return count
+}
+
+func Test_ReminderScheduler(t *testing.T) {
+	Convey("Given a to-do with a due reminder", t, func() {
+		repository := GetTestRepository()
+		notifier := &testNotifier{}
+		scheduler := NewReminderScheduler(repository, notifier)
+		scheduler.lease = 200 * time.Millisecond
+
+		fireAt := time.Now().Add(-time.Minute).Truncate(time.Millisecond).UTC()
+		todoID := uuid.New().String()
+		repository.AddTodoRepository(&TodoModel{
+			ID:        todoID,
+			Content:   "To-do reminder request olustur.",
+			Reminders: []ReminderModel{{ID: uuid.New().String(), At: &fireAt, FireAt: fireAt}},
+			CratedAt:  time.Now().Round(time.Minute).UTC(),
+			UpdatedAt: time.Now().Round(time.Minute).UTC(),
+		})
+
+		Convey("When the notifier fails", func() {
+			notifier.err = errors.New("receiver is down")
+			So(scheduler.RunOnce(), ShouldBeNil)
+			So(notifier.count(todoID), ShouldEqual, 1)
+
+			Convey("Then the reminder should not be sent again before the backoff", func() {
+				notifier.err = nil
+				So(scheduler.RunOnce(), ShouldBeNil)
+				So(notifier.count(todoID), ShouldEqual, 1)
+
+				todoEntity, err := repository.GetTodoRepository("", todoID)
+				So(err, ShouldBeNil)
+				So(todoEntity.Reminders[0].Attempts, ShouldEqual, 1)
+				So(todoEntity.Reminders[0].SentAt, ShouldBeNil)
+			})
+
+			Convey("Then the reminder should be sent again after the backoff", func() {
+				notifier.err = nil
+				time.Sleep(2 * scheduler.backoff(0))
+				So(scheduler.RunOnce(), ShouldBeNil)
+				So(notifier.count(todoID), ShouldEqual, 2)
+
+				todoEntity, err := repository.GetTodoRepository("", todoID)
+				So(err, ShouldBeNil)
+				So(todoEntity.Reminders[0].SentAt, ShouldNotBeNil)
+
+				So(scheduler.RunOnce(), ShouldBeNil)
+				So(notifier.count(todoID), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When a worker claims the reminder and never completes it", func() {
+			now := time.Now().UTC()
+			for {
+				todoEntity, err := repository.ClaimDueRemindersRepository(now, now.Add(scheduler.lease), uuid.New().String())
+				So(err == nil || err == mongo.ErrNoDocuments, ShouldBeTrue)
+				if err != nil || todoEntity.ID == todoID {
+					break
+				}
+			}
+
+			Convey("Then the reminder should only be redelivered once the lease expires", func() {
+				So(scheduler.RunOnce(), ShouldBeNil)
+				So(notifier.count(todoID), ShouldEqual, 0)
+
+				time.Sleep(2 * scheduler.lease)
+				So(scheduler.RunOnce(), ShouldBeNil)
+				So(notifier.count(todoID), ShouldEqual, 1)
+			})
 		})
+		repository.DeleteTodoRepository("", todoID)
 	})
 }
 
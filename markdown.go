@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+const MaxNotesLength = 10000
+
+// markdown uses goldmark's default, safe renderer: raw HTML in the source
+// is omitted and dangerous link destinations such as javascript: are
+// dropped, so the output can be embedded without further sanitising.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+func RenderMarkdown(source string) (string, error) {
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buffer); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+func RenderTodoNotes(todoDTO *TodoDTO) error {
+	if todoDTO.Notes == "" {
+		return nil
+	}
+
+	notesHTML, err := RenderMarkdown(todoDTO.Notes)
+	if err != nil {
+		return err
+	}
+	todoDTO.NotesHTML = notesHTML
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_RenderMarkdown(t *testing.T) {
+	Convey("Given Markdown notes with unsafe content", t, func() {
+		notes := "# Plan\n\n- [ ] **backend**\n\n<script>alert(1)</script>\n\n[click](javascript:alert(1))"
+
+		Convey("When notes are rendered", func() {
+			html, err := RenderMarkdown(notes)
+			So(err, ShouldBeNil)
+
+			Convey("Then Markdown should be converted to HTML", func() {
+				So(html, ShouldContainSubstring, "<h1>Plan</h1>")
+				So(html, ShouldContainSubstring, "<strong>backend</strong>")
+			})
+
+			Convey("Then unsafe HTML and links should be removed", func() {
+				So(html, ShouldNotContainSubstring, "<script>")
+				So(html, ShouldNotContainSubstring, "javascript:")
+			})
+		})
+	})
+}
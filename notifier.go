@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type Notifier interface {
+	Notify(notification *ReminderNotification) error
+}
+
+type LogNotifier struct {
+	logger *log.Logger
+}
+
+func NewLogNotifier(logger *log.Logger) *LogNotifier {
+	return &LogNotifier{
+		logger: logger,
+	}
+}
+
+func (notifier *LogNotifier) Notify(notification *ReminderNotification) error {
+	notifier.logger.Printf("reminder: %q (to-do %s) fired at %s", notification.Content, notification.TodoID, notification.FireAt.Format(time.RFC3339))
+	return nil
+}
+
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (notifier *WebhookNotifier) Notify(notification *ReminderNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	response, err := notifier.client.Post(notifier.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+type SmtpNotifier struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+// NewSmtpNotifier sends reminders as plain-text mail. Authentication is only
+// used when a username is given.
+func NewSmtpNotifier(addr string, username string, password string, from string, to []string) *SmtpNotifier {
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SmtpNotifier{
+		addr: addr,
+		auth: auth,
+		from: from,
+		to:   to,
+	}
+}
+
+func (notifier *SmtpNotifier) Notify(notification *ReminderNotification) error {
+	message := strings.Builder{}
+	message.WriteString("From: " + notifier.from + "\r\n")
+	message.WriteString("To: " + strings.Join(notifier.to, ", ") + "\r\n")
+	message.WriteString("Subject: Reminder: " + strings.NewReplacer("\r", " ", "\n", " ").Replace(notification.Content) + "\r\n")
+	message.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(notification.Content + "\r\n")
+	if notification.DueAt != nil {
+		message.WriteString("Due: " + notification.DueAt.Format(time.RFC3339) + "\r\n")
+	}
+
+	return smtp.SendMail(notifier.addr, notifier.auth, notifier.from, notifier.to, []byte(message.String()))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_WebhookNotifier(t *testing.T) {
+	Convey("Given a local webhook receiver", t, func() {
+		received := make(chan ReminderNotification, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			notification := ReminderNotification{}
+			json.Unmarshal(body, &notification)
+			received <- notification
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		notifier := NewWebhookNotifier(server.URL)
+
+		Convey("When a reminder is sent", func() {
+			err := notifier.Notify(&ReminderNotification{
+				TodoID:     "todo-1",
+				ReminderID: "reminder-1",
+				Content:    "Faturayi ode.",
+				FireAt:     time.Now().UTC(),
+			})
+			So(err, ShouldBeNil)
+
+			Convey("Then the receiver should get the notification", func() {
+				notification := <-received
+				So(notification.TodoID, ShouldEqual, "todo-1")
+				So(notification.Content, ShouldEqual, "Faturayi ode.")
+			})
+		})
+	})
+}
+
+func Test_SmtpNotifier(t *testing.T) {
+	Convey("Given a local SMTP server", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close()
+
+		received := make(chan string, 1)
+		go serveTestSmtp(listener, received)
+
+		notifier := NewSmtpNotifier(listener.Addr().String(), "", "", "todo@example.com", []string{"user@example.com"})
+
+		Convey("When a reminder is sent", func() {
+			err := notifier.Notify(&ReminderNotification{
+				TodoID:  "todo-1",
+				Content: "Faturayi ode.",
+				FireAt:  time.Now().UTC(),
+			})
+			So(err, ShouldBeNil)
+
+			Convey("Then the server should receive the mail", func() {
+				message := <-received
+				So(message, ShouldContainSubstring, "Subject: Reminder: Faturayi ode.")
+				So(message, ShouldContainSubstring, "To: user@example.com")
+			})
+		})
+	})
+}
+
+// serveTestSmtp accepts a single message with the minimal command set used
+// by net/smtp.
+func serveTestSmtp(listener net.Listener, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("220 localhost ESMTP\r\n"))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			conn.Write([]byte("250 localhost\r\n"))
+		case command == "DATA":
+			conn.Write([]byte("354 go ahead\r\n"))
+			message := strings.Builder{}
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil || dataLine == ".\r\n" {
+					break
+				}
+				message.WriteString(dataLine)
+			}
+			received <- message.String()
+			conn.Write([]byte("250 OK\r\n"))
+		case command == "QUIT":
+			conn.Write([]byte("221 bye\r\n"))
+			return
+		default:
+			conn.Write([]byte("250 OK\r\n"))
+		}
+	}
+}
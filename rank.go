@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// RankDigits are the digits of a rank in ascending order. Ranks compare as
+// plain strings, so the list is sorted by rank without a collation.
+const RankDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const rankBase = len(RankDigits)
+
+// rankIndexWidth is the number of digits that hold the 64 bits of an index.
+const rankIndexWidth = 11
+
+// RankBetween returns a rank that sorts after a and before b. An empty a is
+// the start and an empty b the end of the list; bounds given in the wrong
+// order are swapped. Ranks never end with the smallest digit, so there is
+// always room for another one: ranks get longer instead of running out of
+// precision. Clients that move to-dos to the same place get the same rank,
+// and the list breaks the tie by id, so every replica ends in one order.
+// Equal bounds have no rank between them and the bound itself is returned;
+// callers give tied to-dos distinct ranks first.
+func RankBetween(a string, b string) string {
+	if b != "" && a > b {
+		a, b = b, a
+	}
+	if a == b && a != "" {
+		return a
+	}
+	return rankMidpoint(a, b)
+}
+
+func rankMidpoint(a string, b string) string {
+	if b != "" {
+		n := 0
+		for n < len(b) && rankDigit(a, n) == rankDigit(b, n) {
+			n++
+		}
+		if n > 0 {
+			return b[:n] + rankMidpoint(rankTail(a, n), b[n:])
+		}
+	}
+
+	low := rankDigit(a, 0)
+	high := rankBase
+	if b != "" {
+		high = rankDigit(b, 0)
+	}
+	if high-low > 1 {
+		return string(RankDigits[(low+high)/2])
+	}
+	if len(b) > 1 {
+		return b[:1]
+	}
+	return string(RankDigits[low]) + rankMidpoint(rankTail(a, 1), "")
+}
+
+// RanksBetween spreads n ascending ranks between a and b. Placing a batch
+// by halving the gap keeps the ranks short, where adding one to-do after
+// the other would make every rank a little longer.
+func RanksBetween(a string, b string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	mid := RankBetween(a, b)
+	half := n / 2
+	ranks := append(RanksBetween(a, mid, half), mid)
+	return append(ranks, RanksBetween(mid, b, n-half-1)...)
+}
+
+// rankDigit reads a missing digit as the smallest one.
+func rankDigit(rank string, i int) int {
+	if i >= len(rank) {
+		return 0
+	}
+	return strings.IndexByte(RankDigits, rank[i])
+}
+
+func rankTail(rank string, i int) string {
+	if i >= len(rank) {
+		return ""
+	}
+	return rank[i:]
+}
+
+// RankFromIndex migrates a float index to a rank in the same order. The
+// bits of the index are flipped so they compare as an unsigned number and
+// written with a fixed width; the last digit keeps the rank from ending
+// with the smallest digit.
+func RankFromIndex(index float64) string {
+	bits := math.Float64bits(index)
+	if bits>>63 == 0 {
+		bits |= 1 << 63
+	} else {
+		bits = ^bits
+	}
+
+	rank := make([]byte, rankIndexWidth+1)
+	for i := rankIndexWidth - 1; i >= 0; i-- {
+		rank[i] = RankDigits[bits%uint64(rankBase)]
+		bits /= uint64(rankBase)
+	}
+	rank[rankIndexWidth] = RankDigits[rankBase/2]
+	return string(rank)
+}
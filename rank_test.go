@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_RankBetween(t *testing.T) {
+	Convey("Given two ranks", t, func() {
+		Convey("When the list is empty", func() {
+			rank := RankBetween("", "")
+
+			Convey("Then there should be room on both sides", func() {
+				So(RankBetween("", rank), ShouldBeLessThan, rank)
+				So(RankBetween(rank, ""), ShouldBeGreaterThan, rank)
+			})
+		})
+
+		Convey("When to-dos are moved between the same neighbours again and again", func() {
+			low, high := RankBetween("", ""), RankBetween(RankBetween("", ""), "")
+			for i := 0; i < 200; i++ {
+				rank := RankBetween(low, high)
+				So(rank, ShouldBeGreaterThan, low)
+				So(rank, ShouldBeLessThan, high)
+				So(strings.HasSuffix(rank, "0"), ShouldBeFalse)
+				if i%2 == 0 {
+					low = rank
+				} else {
+					high = rank
+				}
+			}
+
+			Convey("Then the ranks should stay short", func() {
+				So(len(low), ShouldBeLessThan, 200)
+			})
+		})
+
+		Convey("When the bounds are given in the wrong order", func() {
+			Convey("Then the rank should still be between them", func() {
+				rank := RankBetween("b", "a")
+				So(rank, ShouldBeGreaterThan, "a")
+				So(rank, ShouldBeLessThan, "b")
+			})
+		})
+
+		Convey("When the bounds are equal", func() {
+			Convey("Then there should be no rank between them", func() {
+				So(RankBetween("V", "V"), ShouldEqual, "V")
+			})
+		})
+
+		Convey("When two clients move to-dos to the same place", func() {
+			Convey("Then both should get the same rank", func() {
+				So(RankBetween("A", "B5"), ShouldEqual, RankBetween("A", "B5"))
+			})
+		})
+	})
+}
+
+func Test_RanksBetween(t *testing.T) {
+	Convey("Given a batch of ranks", t, func() {
+		ranks := RanksBetween("V", "", 100)
+
+		Convey("Then they should be ascending, above the bound and short", func() {
+			So(len(ranks), ShouldEqual, 100)
+			So(ranks[0], ShouldBeGreaterThan, "V")
+			for i := 1; i < len(ranks); i++ {
+				So(ranks[i], ShouldBeGreaterThan, ranks[i-1])
+				So(len(ranks[i]), ShouldBeLessThan, 10)
+			}
+		})
+	})
+}
+
+func Test_RankFromIndex(t *testing.T) {
+	Convey("Given float indexes", t, func() {
+		indexes := []float64{-1e300, -10, -0.5, 0, 0.25, 0.5, 1, 10, 10.000001, 1e300}
+		rand.New(rand.NewSource(1)).Shuffle(len(indexes), func(i, j int) {
+			indexes[i], indexes[j] = indexes[j], indexes[i]
+		})
+
+		Convey("When they are migrated to ranks", func() {
+			ranks := []string{}
+			for _, index := range indexes {
+				ranks = append(ranks, RankFromIndex(index))
+			}
+
+			Convey("Then the ranks should sort like the indexes", func() {
+				sort.Float64s(indexes)
+				sort.Strings(ranks)
+				for i, index := range indexes {
+					So(ranks[i], ShouldEqual, RankFromIndex(index))
+				}
+			})
+
+			Convey("Then there should be room between neighbours", func() {
+				rank := RankBetween(RankFromIndex(0), RankFromIndex(0.5))
+				So(rank, ShouldBeGreaterThan, RankFromIndex(0))
+				So(rank, ShouldBeLessThan, RankFromIndex(0.5))
+			})
+		})
+	})
+}
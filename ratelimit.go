@@ -0,0 +1,196 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateLimitConfig allows Burst requests at once, refilled at Rate requests
+// per second.
+type RateLimitConfig struct {
+	Rate  float64
+	Burst int
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// RateLimitStore keeps the token buckets. The in-memory store limits a
+// single instance; a shared implementation lets several instances enforce
+// one limit.
+type RateLimitStore interface {
+	Take(key string, config RateLimitConfig, now time.Time) (RateLimitResult, error)
+	Peek(key string, config RateLimitConfig, now time.Time) (RateLimitResult, error)
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type MemoryRateLimitStore struct {
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	sweptAt   time.Time
+	sweepEach time.Duration
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   map[string]*tokenBucket{},
+		sweepEach: time.Minute,
+	}
+}
+
+func (store *MemoryRateLimitStore) Take(key string, config RateLimitConfig, now time.Time) (RateLimitResult, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.sweep(config, now)
+
+	bucket, ok := store.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(config.Burst), updatedAt: now}
+		store.buckets[key] = bucket
+	}
+	bucket.tokens = refill(bucket, config, now)
+	bucket.updatedAt = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	return bucketResult(bucket.tokens, allowed, config), nil
+}
+
+// Peek reports whether Take would allow a request, without taking a token.
+func (store *MemoryRateLimitStore) Peek(key string, config RateLimitConfig, now time.Time) (RateLimitResult, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	tokens := float64(config.Burst)
+	if bucket, ok := store.buckets[key]; ok {
+		tokens = refill(bucket, config, now)
+	}
+	return bucketResult(tokens, tokens >= 1, config), nil
+}
+
+func bucketResult(tokens float64, allowed bool, config RateLimitConfig) RateLimitResult {
+	result := RateLimitResult{Allowed: allowed}
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / config.Rate)
+	}
+	result.Remaining = int(tokens)
+	result.Reset = secondsToDuration((float64(config.Burst) - tokens) / config.Rate)
+	return result
+}
+
+// sweep forgets buckets that have refilled completely, as they behave
+// exactly like new ones.
+func (store *MemoryRateLimitStore) sweep(config RateLimitConfig, now time.Time) {
+	if now.Sub(store.sweptAt) < store.sweepEach {
+		return
+	}
+	store.sweptAt = now
+
+	for key, bucket := range store.buckets {
+		if refill(bucket, config, now) >= float64(config.Burst) {
+			delete(store.buckets, key)
+		}
+	}
+}
+
+func refill(bucket *tokenBucket, config RateLimitConfig, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.updatedAt).Seconds()*config.Rate
+	return math.Min(tokens, float64(config.Burst))
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+type RateLimiter struct {
+	config RateLimitConfig
+	store  RateLimitStore
+}
+
+func NewRateLimiter(config RateLimitConfig, store RateLimitStore) *RateLimiter {
+	return &RateLimiter{
+		config: config,
+		store:  store,
+	}
+}
+
+// Middleware limits every caller to its own token bucket and reports the
+// state of the bucket in X-RateLimit-* headers. It runs after
+// authentication, so only verified callers get a bucket of their own. A
+// failing store lets requests through rather than taking the service down.
+func (limiter *RateLimiter) Middleware(ctx *fiber.Ctx) error {
+	result, err := limiter.store.Take(RateLimitKey(ctx), limiter.config, time.Now())
+	if err != nil {
+		log.Println("rate limit:", err)
+		return ctx.Next()
+	}
+	if !limiter.report(ctx, result) {
+		return fiber.ErrTooManyRequests
+	}
+	return ctx.Next()
+}
+
+// LimitFailures wraps the authentication middleware so that refused
+// credentials are limited by client IP: every refusal takes a token, and
+// once the bucket is empty requests are refused before their credentials
+// are checked. Made up credentials therefore never get a fresh bucket.
+func (limiter *RateLimiter) LimitFailures(authenticate fiber.Handler) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		key := "ip:" + ctx.IP()
+		result, err := limiter.store.Peek(key, limiter.config, time.Now())
+		if err != nil {
+			log.Println("rate limit:", err)
+		} else if !result.Allowed {
+			limiter.report(ctx, result)
+			return fiber.ErrTooManyRequests
+		}
+
+		err = authenticate(ctx)
+		if _, ok := ctx.Locals(PrincipalKey).(*Principal); !ok && err != nil {
+			if _, err := limiter.store.Take(key, limiter.config, time.Now()); err != nil {
+				log.Println("rate limit:", err)
+			}
+		}
+		return err
+	}
+}
+
+// report sets the X-RateLimit-* headers, and the status and Retry-After
+// header of a refused request. It returns whether the request is allowed.
+func (limiter *RateLimiter) report(ctx *fiber.Ctx, result RateLimitResult) bool {
+	ctx.Set("X-RateLimit-Limit", strconv.Itoa(limiter.config.Burst))
+	ctx.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	ctx.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+
+	if !result.Allowed {
+		ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+		ctx.Status(fiber.StatusTooManyRequests)
+	}
+	return result.Allowed
+}
+
+// RateLimitKey identifies the caller by its authenticated principal and
+// falls back to the client IP. Credentials themselves are never a key, as
+// anyone can send new ones with every request.
+func RateLimitKey(ctx *fiber.Ctx) string {
+	if principal, ok := ctx.Locals(PrincipalKey).(*Principal); ok {
+		return "principal:" + principal.ID
+	}
+	return "ip:" + ctx.IP()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_MemoryRateLimitStore(t *testing.T) {
+	Convey("Given an empty bucket of two tokens refilled once a second", t, func() {
+		store := NewMemoryRateLimitStore()
+		config := RateLimitConfig{Rate: 1, Burst: 2}
+		now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+		store.Take("murat", config, now)
+		store.Take("murat", config, now)
+
+		Convey("When another request is made at once", func() {
+			result, err := store.Take("murat", config, now)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be refused until a token is refilled", func() {
+				So(result.Allowed, ShouldBeFalse)
+				So(result.RetryAfter, ShouldEqual, time.Second)
+			})
+		})
+
+		Convey("When another request is made a second later", func() {
+			result, _ := store.Take("murat", config, now.Add(time.Second))
+
+			Convey("Then it should be allowed", func() {
+				So(result.Allowed, ShouldBeTrue)
+				So(result.Remaining, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When another caller makes a request", func() {
+			result, _ := store.Take("ayse", config, now)
+
+			Convey("Then it should use its own bucket", func() {
+				So(result.Allowed, ShouldBeTrue)
+				So(result.Remaining, ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func Test_RateLimiter(t *testing.T) {
+	Convey("Given an app limited to one request per API key", t, func() {
+		limiter := NewRateLimiter(RateLimitConfig{Rate: 0.1, Burst: 1}, NewMemoryRateLimitStore())
+		authenticator, _ := NewAuthenticator(AuthConfig{
+			APIKeys: []APIKeyConfig{
+				{Hash: HashAPIKey("first-key"), Principal: "first"},
+				{Hash: HashAPIKey("second-key"), Principal: "second"},
+			},
+		}, nil)
+		app := fiber.New()
+		app.Use(limiter.LimitFailures(authenticator.Middleware))
+		app.Use(limiter.Middleware)
+		app.Post("/todo", func(ctx *fiber.Ctx) error {
+			return ctx.SendStatus(fiber.StatusCreated)
+		})
+
+		send := func(apiKey string) (int, string, string) {
+			req := httptest.NewRequest("POST", "/todo", nil)
+			req.Header.Set("X-API-Key", apiKey)
+			resp, err := app.Test(req)
+			So(err, ShouldBeNil)
+			return resp.StatusCode, resp.Header.Get("X-RateLimit-Remaining"), resp.Header.Get(fiber.HeaderRetryAfter)
+		}
+
+		Convey("When the same key sends two requests", func() {
+			firstStatus, remaining, _ := send("first-key")
+			secondStatus, _, retryAfter := send("first-key")
+
+			Convey("Then the second should be limited with a Retry-After header", func() {
+				So(firstStatus, ShouldEqual, fiber.StatusCreated)
+				So(remaining, ShouldEqual, "0")
+				So(secondStatus, ShouldEqual, fiber.StatusTooManyRequests)
+				So(retryAfter, ShouldEqual, "10")
+			})
+		})
+
+		Convey("When another key sends a request", func() {
+			send("first-key")
+			status, _, _ := send("second-key")
+
+			Convey("Then it should not be limited", func() {
+				So(status, ShouldEqual, fiber.StatusCreated)
+			})
+		})
+
+		Convey("When made up keys are sent from one address", func() {
+			firstStatus, _, _ := send("made-up-key-1")
+			secondStatus, _, retryAfter := send("made-up-key-2")
+
+			Convey("Then they should share the bucket of the address", func() {
+				So(firstStatus, ShouldEqual, fiber.StatusUnauthorized)
+				So(secondStatus, ShouldEqual, fiber.StatusTooManyRequests)
+				So(retryAfter, ShouldEqual, "10")
+			})
+		})
+	})
+}
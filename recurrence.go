@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecurrenceRule is the subset of RFC 5545 RRULE supported for to-dos:
+// FREQ (DAILY, WEEKLY, MONTHLY, YEARLY), INTERVAL, BYDAY for weekly rules,
+// BYMONTHDAY for monthly rules, and COUNT or UNTIL to end the series.
+type RecurrenceRule struct {
+	Frequency  string
+	Interval   int
+	ByDay      []time.Weekday
+	ByMonthDay []int
+	Count      int
+	Until      *time.Time
+}
+
+const (
+	FrequencyDaily   = "DAILY"
+	FrequencyWeekly  = "WEEKLY"
+	FrequencyMonthly = "MONTHLY"
+	FrequencyYearly  = "YEARLY"
+)
+
+var ErrInvalidRecurrenceRule = errors.New("invalid recurrence rule")
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+func ParseRecurrenceRule(rule string) (*RecurrenceRule, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	recurrenceRule := RecurrenceRule{Interval: 1}
+
+	for _, part := range strings.Split(rule, ";") {
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, ErrInvalidRecurrenceRule
+		}
+		key, value := strings.ToUpper(keyValue[0]), strings.ToUpper(keyValue[1])
+
+		switch key {
+		case "FREQ":
+			switch value {
+			case FrequencyDaily, FrequencyWeekly, FrequencyMonthly, FrequencyYearly:
+				recurrenceRule.Frequency = value
+			default:
+				return nil, ErrInvalidRecurrenceRule
+			}
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return nil, ErrInvalidRecurrenceRule
+			}
+			recurrenceRule.Interval = interval
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				weekday, ok := weekdayCodes[code]
+				if !ok {
+					return nil, ErrInvalidRecurrenceRule
+				}
+				recurrenceRule.ByDay = append(recurrenceRule.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, dayStr := range strings.Split(value, ",") {
+				day, err := strconv.Atoi(dayStr)
+				if err != nil || day == 0 || day < -31 || day > 31 {
+					return nil, ErrInvalidRecurrenceRule
+				}
+				recurrenceRule.ByMonthDay = append(recurrenceRule.ByMonthDay, day)
+			}
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return nil, ErrInvalidRecurrenceRule
+			}
+			recurrenceRule.Count = count
+		case "UNTIL":
+			until, err := parseRecurrenceUntil(value)
+			if err != nil {
+				return nil, ErrInvalidRecurrenceRule
+			}
+			recurrenceRule.Until = &until
+		default:
+			return nil, ErrInvalidRecurrenceRule
+		}
+	}
+
+	if recurrenceRule.Frequency == "" {
+		return nil, ErrInvalidRecurrenceRule
+	}
+	if recurrenceRule.Count != 0 && recurrenceRule.Until != nil {
+		return nil, ErrInvalidRecurrenceRule
+	}
+	if len(recurrenceRule.ByDay) > 0 && recurrenceRule.Frequency != FrequencyWeekly {
+		return nil, ErrInvalidRecurrenceRule
+	}
+	if len(recurrenceRule.ByMonthDay) > 0 && recurrenceRule.Frequency != FrequencyMonthly {
+		return nil, ErrInvalidRecurrenceRule
+	}
+
+	return &recurrenceRule, nil
+}
+
+func parseRecurrenceUntil(value string) (time.Time, error) {
+	if until, err := time.Parse("20060102T150405Z", value); err == nil {
+		return until, nil
+	}
+	until, err := time.Parse("20060102", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// A date-only UNTIL includes the whole day.
+	return until.Add(24*time.Hour - time.Second), nil
+}
+
+// Next returns the occurrence following the given one, in its location, so
+// a series keeps its wall-clock time across daylight saving changes. The
+// second return value is false when the series has ended by COUNT or UNTIL.
+func (rule *RecurrenceRule) Next(current time.Time, occurrence int) (time.Time, bool) {
+	if rule.Count != 0 && occurrence >= rule.Count {
+		return time.Time{}, false
+	}
+
+	var next time.Time
+	switch rule.Frequency {
+	case FrequencyDaily:
+		next = current.AddDate(0, 0, rule.Interval)
+	case FrequencyWeekly:
+		next = rule.nextWeekly(current)
+	case FrequencyMonthly:
+		next = rule.nextMonthly(current)
+	case FrequencyYearly:
+		next = addMonthsKeepDay(current, 12*rule.Interval, current.Day())
+	}
+
+	if rule.Until != nil && next.After(*rule.Until) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+func (rule *RecurrenceRule) nextWeekly(current time.Time) time.Time {
+	if len(rule.ByDay) == 0 {
+		return current.AddDate(0, 0, 7*rule.Interval)
+	}
+
+	offsets := []int{}
+	for _, weekday := range rule.ByDay {
+		offsets = append(offsets, (int(weekday)+6)%7)
+	}
+	sort.Ints(offsets)
+
+	currentOffset := (int(current.Weekday()) + 6) % 7
+	for _, offset := range offsets {
+		if offset > currentOffset {
+			return current.AddDate(0, 0, offset-currentOffset)
+		}
+	}
+
+	startOfWeek := current.AddDate(0, 0, -currentOffset)
+	return startOfWeek.AddDate(0, 0, 7*rule.Interval+offsets[0])
+}
+
+func (rule *RecurrenceRule) nextMonthly(current time.Time) time.Time {
+	if len(rule.ByMonthDay) == 0 {
+		return addMonthsKeepDay(current, rule.Interval, current.Day())
+	}
+
+	// Four years of candidate months always contain every valid day.
+	for months := 0; months <= 48*rule.Interval; months += rule.Interval {
+		monthStart := time.Date(current.Year(), current.Month()+time.Month(months), 1,
+			current.Hour(), current.Minute(), current.Second(), current.Nanosecond(), current.Location())
+		lastDay := monthStart.AddDate(0, 1, -1).Day()
+
+		days := []int{}
+		for _, day := range rule.ByMonthDay {
+			if day < 0 {
+				day = lastDay + day + 1
+			}
+			if day >= 1 && day <= lastDay {
+				days = append(days, day)
+			}
+		}
+		sort.Ints(days)
+
+		for _, day := range days {
+			candidate := monthStart.AddDate(0, 0, day-1)
+			if candidate.After(current) {
+				return candidate
+			}
+		}
+	}
+	return addMonthsKeepDay(current, rule.Interval, current.Day())
+}
+
+// addMonthsKeepDay skips months that do not contain the given day, so a
+// rule anchored on the 31st or on February 29th never drifts.
+func addMonthsKeepDay(current time.Time, months int, day int) time.Time {
+	for step := months; ; step += months {
+		candidate := time.Date(current.Year(), current.Month()+time.Month(step), day,
+			current.Hour(), current.Minute(), current.Second(), current.Nanosecond(), current.Location())
+		if candidate.Day() == day {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_RecurrenceRule(t *testing.T) {
+	Convey("Given a Wednesday occurrence", t, func() {
+		current := time.Date(2021, time.August, 4, 9, 0, 0, 0, time.UTC)
+
+		Convey("When the rule is daily every two days", func() {
+			rule, err := ParseRecurrenceRule("FREQ=DAILY;INTERVAL=2")
+			So(err, ShouldBeNil)
+
+			Convey("Then the next occurrence should be two days later", func() {
+				next, ok := rule.Next(current, 1)
+				So(ok, ShouldBeTrue)
+				So(next, ShouldEqual, time.Date(2021, time.August, 6, 9, 0, 0, 0, time.UTC))
+			})
+		})
+
+		Convey("When the rule is weekly on Monday and Friday", func() {
+			rule, err := ParseRecurrenceRule("FREQ=WEEKLY;BYDAY=MO,FR")
+			So(err, ShouldBeNil)
+
+			Convey("Then the next occurrences should be Friday and the following Monday", func() {
+				next, ok := rule.Next(current, 1)
+				So(ok, ShouldBeTrue)
+				So(next, ShouldEqual, time.Date(2021, time.August, 6, 9, 0, 0, 0, time.UTC))
+
+				next, ok = rule.Next(next, 2)
+				So(ok, ShouldBeTrue)
+				So(next, ShouldEqual, time.Date(2021, time.August, 9, 9, 0, 0, 0, time.UTC))
+			})
+		})
+
+		Convey("When the rule is monthly on the last day", func() {
+			rule, err := ParseRecurrenceRule("FREQ=MONTHLY;BYMONTHDAY=-1")
+			So(err, ShouldBeNil)
+
+			Convey("Then the next occurrence should be the end of the month", func() {
+				next, ok := rule.Next(current, 1)
+				So(ok, ShouldBeTrue)
+				So(next, ShouldEqual, time.Date(2021, time.August, 31, 9, 0, 0, 0, time.UTC))
+
+				next, ok = rule.Next(next, 2)
+				So(ok, ShouldBeTrue)
+				So(next, ShouldEqual, time.Date(2021, time.September, 30, 9, 0, 0, 0, time.UTC))
+			})
+		})
+
+		Convey("When the rule is yearly from a leap day", func() {
+			rule, err := ParseRecurrenceRule("FREQ=YEARLY")
+			So(err, ShouldBeNil)
+
+			Convey("Then the next occurrence should be the next leap day", func() {
+				next, ok := rule.Next(time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC), 1)
+				So(ok, ShouldBeTrue)
+				So(next, ShouldEqual, time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC))
+			})
+		})
+
+		Convey("When the rule has a count", func() {
+			rule, err := ParseRecurrenceRule("FREQ=DAILY;COUNT=2")
+			So(err, ShouldBeNil)
+
+			Convey("Then the series should end after the last occurrence", func() {
+				_, ok := rule.Next(current, 1)
+				So(ok, ShouldBeTrue)
+				_, ok = rule.Next(current, 2)
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the rule has an until date", func() {
+			rule, err := ParseRecurrenceRule("FREQ=WEEKLY;UNTIL=20210810")
+			So(err, ShouldBeNil)
+
+			Convey("Then the series should end after the until date", func() {
+				_, ok := rule.Next(current, 1)
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When a daily rule crosses a daylight saving change", func() {
+			rule, err := ParseRecurrenceRule("FREQ=DAILY")
+			So(err, ShouldBeNil)
+			location, err := time.LoadLocation("Europe/Berlin")
+			So(err, ShouldBeNil)
+
+			Convey("Then the next occurrence should keep its wall-clock time", func() {
+				next, ok := rule.Next(time.Date(2021, time.March, 27, 8, 0, 0, 0, time.UTC).In(location), 1)
+				So(ok, ShouldBeTrue)
+				So(next.UTC(), ShouldEqual, time.Date(2021, time.March, 28, 7, 0, 0, 0, time.UTC))
+			})
+		})
+
+		Convey("When the rule is invalid", func() {
+			_, err := ParseRecurrenceRule("FREQ=HOURLY")
+
+			Convey("Then an error should be returned", func() {
+				So(err, ShouldEqual, ErrInvalidRecurrenceRule)
+			})
+		})
+	})
+}
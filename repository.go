@@ -2,56 +2,263 @@ package main
 
 import (
 	"context"
+	"log"
+	"reflect"
+	"regexp"
+	"sort"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type TodoEntity struct {
+	ID          string           `bson:"_id"`
+	OwnerID     string           `bson:"ownerid"`
+	Content     string           `bson:"content"`
+	Done        bool             `bson:"done"`
+	Index       float64          `bson:"index"`
+	Rank        string           `bson:"rank"`
+	DueAt       *time.Time       `bson:"dueat"`
+	StartAt     *time.Time       `bson:"startat"`
+	Recurrence  string           `bson:"recurrence,omitempty"`
+	Timezone    string           `bson:"timezone,omitempty"`
+	SeriesID    string           `bson:"seriesid,omitempty"`
+	Occurrence  int              `bson:"occurrence,omitempty"`
+	NextID      string           `bson:"nextid,omitempty"`
+	Priority    int              `bson:"priority"`
+	Tags        []string         `bson:"tags"`
+	Notes       string           `bson:"notes,omitempty"`
+	DeletedAt   *time.Time       `bson:"deletedat"`
+	ArchivedAt  *time.Time       `bson:"archivedat"`
+	Version     int64            `bson:"version"`
+	CompletedAt *time.Time       `bson:"completedat"`
+	Reminders   []ReminderEntity `bson:"reminders,omitempty"`
+	CratedAt    time.Time        `bson:"createdat"`
+	UpdatedAt   time.Time        `bson:"updatedat"`
+	Seq         int64            `bson:"seq"`
+}
+
+type ReminderEntity struct {
+	ID          string     `bson:"id"`
+	At          *time.Time `bson:"at"`
+	BeforeDue   int64      `bson:"beforedue"`
+	FireAt      time.Time  `bson:"fireat"`
+	SentAt      *time.Time `bson:"sentat"`
+	Attempts    int        `bson:"attempts"`
+	LockedUntil *time.Time `bson:"lockeduntil"`
+	ClaimID     string     `bson:"claimid,omitempty"`
+}
+
+type HistoryEntity struct {
+	ID      string              `bson:"_id"`
+	OwnerID string              `bson:"ownerid"`
+	TodoID  string              `bson:"todoid"`
+	Actor   string              `bson:"actor"`
+	Action  string              `bson:"action"`
+	At      time.Time           `bson:"at"`
+	Changes []FieldChangeEntity `bson:"changes"`
+}
+
+type FieldChangeEntity struct {
+	Field  string      `bson:"field"`
+	Before interface{} `bson:"before"`
+	After  interface{} `bson:"after"`
+}
+
+type TagEntity struct {
+	Name  string `bson:"_id"`
+	Count int    `bson:"count"`
+}
+
+// APIKeyEntity stores the SHA-256 hex digest of an API key as its id.
+type APIKeyEntity struct {
+	Hash      string   `bson:"_id"`
+	Principal string   `bson:"principal"`
+	Scopes    []string `bson:"scopes"`
+	Tenants   []string `bson:"tenants"`
+}
+
+type ShareEntity struct {
 	ID        string    `bson:"_id"`
-	Content   string    `bson:"content"`
-	Done      bool      `bson:"done"`
-	Index     float64   `bson:"index"`
-	CratedAt  time.Time `bson:"createdat"`
+	OwnerID   string    `bson:"ownerid"`
+	UserID    string    `bson:"userid"`
+	Role      string    `bson:"role"`
+	InvitedBy string    `bson:"invitedby"`
+	CreatedAt time.Time `bson:"createdat"`
+}
+
+type WebhookEntity struct {
+	ID        string    `bson:"_id"`
+	OwnerID   string    `bson:"ownerid"`
+	URL       string    `bson:"url"`
+	Events    []string  `bson:"events"`
+	Secret    string    `bson:"secret"`
+	CreatedAt time.Time `bson:"createdat"`
+}
+
+// WebhookDeliveryEntity is one event sent to one webhook. The payload is
+// stored as sent, so retries carry the same body and signature.
+type WebhookDeliveryEntity struct {
+	ID             string     `bson:"_id"`
+	OwnerID        string     `bson:"ownerid"`
+	WebhookID      string     `bson:"webhookid"`
+	Event          string     `bson:"event"`
+	Payload        string     `bson:"payload"`
+	Status         string     `bson:"status"`
+	Attempts       int        `bson:"attempts"`
+	NextAttemptAt  time.Time  `bson:"nextattemptat"`
+	LastAttemptAt  *time.Time `bson:"lastattemptat"`
+	LastStatusCode int        `bson:"laststatuscode,omitempty"`
+	LastError      string     `bson:"lasterror,omitempty"`
+	CreatedAt      time.Time  `bson:"createdat"`
+}
+
+// ChangeStreamEntity stores the resume token of a change stream watcher,
+// so it continues after the last change it published.
+type ChangeStreamEntity struct {
+	Name      string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
 	UpdatedAt time.Time `bson:"updatedat"`
 }
 
+// TodoChangeEntity is a change event of the to-do collection. FullDocument
+// is the current state of the to-do; it is missing for deletes and for
+// to-dos deleted after the change.
+type TodoChangeEntity struct {
+	OperationType string      `bson:"operationType"`
+	FullDocument  *TodoEntity `bson:"fullDocument"`
+	DocumentKey   struct {
+		ID string `bson:"_id"`
+	} `bson:"documentKey"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+type TenantEntity struct {
+	Name      string    `bson:"_id"`
+	Isolation string    `bson:"isolation"`
+	CreatedAt time.Time `bson:"createdat"`
+}
+
+// TombstoneEntity records a to-do that was deleted for good, so syncing
+// clients learn about the deletion. FromTrash is set when the to-do was in
+// the trash, where lists no longer showed it.
+type TombstoneEntity struct {
+	ID        string    `bson:"_id"`
+	OwnerID   string    `bson:"ownerid"`
+	Seq       int64     `bson:"seq"`
+	DeletedAt time.Time `bson:"deletedat"`
+	FromTrash bool      `bson:"fromtrash"`
+}
+
+// TombstoneChangeEntity is a change event of the tombstone collection.
+type TombstoneChangeEntity struct {
+	OperationType string           `bson:"operationType"`
+	FullDocument  *TombstoneEntity `bson:"fullDocument"`
+}
+
+// CalendarFeedEntity lets calendar apps read a list with a secret URL
+// instead of credentials. Only the SHA-256 hex digest of the token is
+// stored.
+type CalendarFeedEntity struct {
+	ID        string    `bson:"_id"`
+	Hash      string    `bson:"hash"`
+	Tenant    string    `bson:"tenant"`
+	OwnerID   string    `bson:"ownerid"`
+	ActorID   string    `bson:"actorid"`
+	CreatedAt time.Time `bson:"createdat"`
+}
+
 type TodoListEntity struct {
 	TodoList []TodoEntity `bson:"todolist"`
 }
 
+const DefaultDatabase = "todo"
+
+const (
+	TenantIsolationDatabase   = "database"
+	TenantIsolationCollection = "collection"
+)
+
+// Repository reads and writes the collections of one database. Tenants
+// get their own database or, with collection isolation, their own prefixed
+// collections in the default database.
 type Repository struct {
-	client *mongo.Client
+	client   *mongo.Client
+	database string
+	prefix   string
 }
 
 func NewRepository(dbUrl string) *Repository {
-	ctx, _ := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 	clientOptions := options.Client().ApplyURI(dbUrl)
 	client, _ := mongo.Connect(ctx, clientOptions)
-	return &Repository{client}
+	return &Repository{client: client, database: DefaultDatabase}
+}
+
+func (repository *Repository) ForTenant(tenantEntity *TenantEntity) *Repository {
+	tenantRepository := Repository{client: repository.client, database: DefaultDatabase}
+	if tenantEntity.Isolation == TenantIsolationCollection {
+		tenantRepository.prefix = tenantEntity.Name + "_"
+	} else {
+		tenantRepository.database = DefaultDatabase + "_" + tenantEntity.Name
+	}
+	return &tenantRepository
+}
+
+func (repository *Repository) collection(name string) *mongo.Collection {
+	return repository.client.Database(repository.database).Collection(repository.prefix + name)
+}
+
+// tenants is the registry of tenants, which always lives in the default
+// database.
+func (repository *Repository) tenants() *mongo.Collection {
+	return repository.client.Database(DefaultDatabase).Collection("tenants")
+}
+
+// calendarFeeds also lives in the default database, so a feed token finds
+// its tenant.
+func (repository *Repository) calendarFeeds() *mongo.Collection {
+	return repository.client.Database(DefaultDatabase).Collection("calendarfeeds")
 }
 
 func (repository *Repository) AddTodoRepository(todoModel *TodoModel) (*TodoEntity, error) {
-	collection := repository.client.Database("todo").Collection("todolist")
-	ctx, _ := context.WithTimeout(context.Background(), 15*time.Second)
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	seq, release, err := repository.nextSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	todoEntity := ConvertTodoModeltoEntity(todoModel)
-	_, err := collection.InsertOne(ctx, todoEntity)
+	todoEntity.Version = 1
+	todoEntity.Seq = seq
+	_, err = collection.InsertOne(ctx, todoEntity)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return repository.GetTodoRepository(todoEntity.ID)
+	return repository.GetTodoRepository(todoEntity.OwnerID, todoEntity.ID)
 }
 
-func (repository *Repository) GetTodoRepository(id string) (*TodoEntity, error) {
-	collection := repository.client.Database("todo").Collection("todolist")
-	ctx, _ := context.WithTimeout(context.Background(), 15*time.Second)
+func (repository *Repository) GetTodoRepository(ownerId string, id string) (*TodoEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 	todoEntity := TodoEntity{}
-	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&todoEntity)
+	filter := OwnerFilter(ownerId)
+	filter["_id"] = id
+	err := collection.FindOne(ctx, filter).Decode(&todoEntity)
 
 	if err != nil {
 		return nil, err
@@ -59,22 +266,33 @@ func (repository *Repository) GetTodoRepository(id string) (*TodoEntity, error)
 	return &todoEntity, nil
 }
 
-func (repository *Repository) GetTodoListRepository(page int, size int) (*TodoListEntity, int, error) {
-	collection := repository.client.Database("todo").Collection("todolist")
-	ctx, _ := context.WithTimeout(context.Background(), 15*time.Second)
+func (repository *Repository) GetTodoListRepository(ownerId string, filterModel *TodoFilterModel, page int, size int) (*TodoListEntity, int, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 
-	findOptions := options.Find()
-	if size != 0 {
-		findOptions.SetSkip(int64(page * size))
-		findOptions.SetLimit(int64(size))
+	filter := BuildTodoFilter(filterModel)
+	for key, value := range OwnerFilter(ownerId) {
+		filter[key] = value
 	}
 
-	findOptions.SetSort(bson.D{{"index", -1}})
-	cursor, err := collection.Find(ctx, bson.M{}, findOptions)
+	var cursor *mongo.Cursor
+	var err error
+	if filterModel != nil && filterModel.Sort == SortSmart {
+		cursor, err = collection.Aggregate(ctx, BuildSmartSortPipeline(filter, page, size))
+	} else {
+		findOptions := options.Find()
+		if size != 0 {
+			findOptions.SetSkip(int64(page * size))
+			findOptions.SetLimit(int64(size))
+		}
+		findOptions.SetSort(TodoOrder)
+		cursor, err = collection.Find(ctx, filter, findOptions)
+	}
 	if err != nil {
 		return nil, 0, err
 	}
-	totalElements, err := collection.CountDocuments(ctx, bson.M{})
+	totalElements, err := collection.CountDocuments(ctx, filter)
 
 	defer cursor.Close(ctx)
 	todoListEntity := TodoListEntity{}
@@ -94,68 +312,1553 @@ func (repository *Repository) GetTodoListRepository(page int, size int) (*TodoLi
 	return &todoListEntity, int(totalElements), nil
 }
 
-func (repository *Repository) UpdateTodoRepository(id string, todoModel *TodoModel) (*TodoEntity, error) {
-	collection := repository.client.Database("todo").Collection("todolist")
-	ctx, _ := context.WithTimeout(context.Background(), 15*time.Second)
+// GetEveryOwnerTodosRepository returns the matching to-dos of every owner.
+// It is only for background workers; requests always read one list.
+func (repository *Repository) GetEveryOwnerTodosRepository(filterModel *TodoFilterModel) ([]TodoEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, BuildTodoFilter(filterModel))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	todoEntities := []TodoEntity{}
+	if err := cursor.All(ctx, &todoEntities); err != nil {
+		return nil, err
+	}
+	return todoEntities, nil
+}
+
+// ForEachTodoRepository streams the to-dos of a list in the manual order,
+// so large lists can be exported without loading them at once.
+func (repository *Repository) ForEachTodoRepository(ownerId string, filterModel *TodoFilterModel, job func(*TodoEntity) error) error {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	filter := BuildTodoFilter(filterModel)
+	for key, value := range OwnerFilter(ownerId) {
+		filter[key] = value
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(TodoOrder))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		todoEntity := TodoEntity{}
+		if err := cursor.Decode(&todoEntity); err != nil {
+			return err
+		}
+		if err := job(&todoEntity); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (repository *Repository) CountTodosRepository(ownerId string) (int, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	count, err := collection.CountDocuments(ctx, OwnerFilter(ownerId))
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+func (repository *Repository) UpdateTodoRepository(ownerId string, id string, todoModel *TodoModel) (*TodoEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 	todoEntity := ConvertTodoModeltoEntity(todoModel)
+	seq, release, err := repository.nextSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	opts := options.Update().SetUpsert(true)
-	filter := bson.D{{"_id", id}}
+	filter := OwnerFilter(ownerId)
+	filter["_id"] = id
 	update := bson.M{
 		"$set": bson.M{
-			"content":   todoEntity.Content,
-			"done":      todoEntity.Done,
-			"updatedat": todoEntity.UpdatedAt,
+			"content":     todoEntity.Content,
+			"done":        todoEntity.Done,
+			"dueat":       todoEntity.DueAt,
+			"startat":     todoEntity.StartAt,
+			"recurrence":  todoEntity.Recurrence,
+			"timezone":    todoEntity.Timezone,
+			"seriesid":    todoEntity.SeriesID,
+			"occurrence":  todoEntity.Occurrence,
+			"nextid":      todoEntity.NextID,
+			"priority":    todoEntity.Priority,
+			"tags":        todoEntity.Tags,
+			"notes":       todoEntity.Notes,
+			"completedat": todoEntity.CompletedAt,
+			"reminders":   todoEntity.Reminders,
+			"updatedat":   todoEntity.UpdatedAt,
+			"seq":         seq,
 		},
+		"$inc": bson.M{"version": 1},
 	}
 
-	_, err := collection.UpdateOne(ctx, filter, update, opts)
+	_, err = collection.UpdateOne(ctx, filter, update)
 
 	if err != nil {
 		return nil, err
 	}
-	return repository.GetTodoRepository(id)
+	return repository.GetTodoRepository(ownerId, id)
+}
+
+// GetRankTieRepository returns the to-dos of a list that share a rank, in
+// list order, and the next higher rank of the list, which is empty when
+// the tie is at the top.
+func (repository *Repository) GetRankTieRepository(ownerId string, rank string) ([]TodoEntity, string, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := OwnerFilter(ownerId)
+	filter["rank"] = rank
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(TodoOrder))
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	todoEntities := []TodoEntity{}
+	if err := cursor.All(ctx, &todoEntities); err != nil {
+		return nil, "", err
+	}
+
+	filter = OwnerFilter(ownerId)
+	filter["rank"] = bson.M{"$gt": rank}
+	above := TodoEntity{}
+	err = collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.M{"rank": 1})).Decode(&above)
+	if err == mongo.ErrNoDocuments {
+		err = nil
+	}
+	return todoEntities, above.Rank, err
 }
 
-func (repository *Repository) UpdateTodoSortRepository(currentId string, newIndex float64) (*TodoEntity, error) {
-	collection := repository.client.Database("todo").Collection("todolist")
-	ctx, _ := context.WithTimeout(context.Background(), 15*time.Second)
+func (repository *Repository) UpdateTodoSortRepository(ownerId string, currentId string, newIndex float64, newRank string) (*TodoEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	seq, release, err := repository.nextSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	opts := options.Update().SetUpsert(true)
-	filter := bson.D{{"_id", currentId}}
+	filter := OwnerFilter(ownerId)
+	filter["_id"] = currentId
 	update := bson.M{
 		"$set": bson.M{
 			"index": newIndex,
+			"rank":  newRank,
+			"seq":   seq,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	_, err = collection.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		return nil, err
+	}
+	return repository.GetTodoRepository(ownerId, currentId)
+}
+
+// ClaimDueRemindersRepository leases every due, unsent reminder of one
+// to-do to the caller. Reminders whose lease expires without being marked
+// as sent are claimed again, which gives at-least-once delivery.
+func (repository *Repository) ClaimDueRemindersRepository(now time.Time, lockedUntil time.Time, claimID string) (*TodoEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"done":      false,
+		"deletedat": nil,
+		"reminders": bson.M{
+			"$elemMatch": bson.M{
+				"fireat": bson.M{"$lte": now},
+				"sentat": nil,
+				"$or":    bson.A{bson.M{"lockeduntil": nil}, bson.M{"lockeduntil": bson.M{"$lte": now}}},
+			},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"reminders.$[r].lockeduntil": lockedUntil,
+			"reminders.$[r].claimid":     claimID,
+		},
+	}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetArrayFilters(options.ArrayFilters{Filters: []interface{}{
+			bson.M{
+				"r.fireat": bson.M{"$lte": now},
+				"r.sentat": nil,
+				"$or":      bson.A{bson.M{"r.lockeduntil": nil}, bson.M{"r.lockeduntil": bson.M{"$lte": now}}},
+			},
+		}})
+
+	todoEntity := TodoEntity{}
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&todoEntity)
+	if err != nil {
+		return nil, err
+	}
+	return &todoEntity, nil
+}
+
+func (repository *Repository) CompleteReminderRepository(todoId string, reminderId string, sentAt time.Time) error {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": todoId, "reminders.id": reminderId}
+	update := bson.M{
+		"$set": bson.M{
+			"reminders.$.sentat":      sentAt,
+			"reminders.$.lockeduntil": nil,
+			"reminders.$.claimid":     "",
+		},
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (repository *Repository) ReleaseReminderRepository(todoId string, reminderId string, retryAt time.Time) error {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": todoId, "reminders.id": reminderId}
+	update := bson.M{
+		"$set": bson.M{
+			"reminders.$.lockeduntil": retryAt,
+			"reminders.$.claimid":     "",
 		},
+		"$inc": bson.M{"reminders.$.attempts": 1},
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (repository *Repository) GetTagsRepository(ownerId string) ([]TagEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: OwnerFilter(ownerId)}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tagEntities := []TagEntity{}
+	if err := cursor.All(ctx, &tagEntities); err != nil {
+		return nil, err
+	}
+	return tagEntities, nil
+}
+
+// MergeTagsRepository replaces the tags in from with into on one active
+// to-do in a pipeline update, so the document is changed atomically. The
+// merged tag takes the place of the first tag it replaces and the order of
+// the other tags is kept. It returns the to-do as it was before, or
+// mongo.ErrNoDocuments when the to-do no longer has any of the tags.
+func (repository *Repository) MergeTagsRepository(ownerId string, id string, from []string, into string, updatedAt time.Time) (*TodoEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	seq, release, err := repository.nextSeq(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 
-	_, err := collection.UpdateOne(ctx, filter, update, opts)
+	renamed := bson.M{"$map": bson.M{
+		"input": "$tags",
+		"in":    bson.M{"$cond": bson.A{bson.M{"$in": bson.A{"$$this", from}}, into, "$$this"}},
+	}}
+	filter := OwnerFilter(ownerId)
+	filter["_id"] = id
+	filter["deletedat"] = nil
+	filter["tags"] = bson.M{"$in": from}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"tags": bson.M{"$reduce": bson.M{
+				"input":        renamed,
+				"initialValue": bson.A{},
+				"in": bson.M{"$cond": bson.A{
+					bson.M{"$in": bson.A{"$$this", "$$value"}},
+					"$$value",
+					bson.M{"$concatArrays": bson.A{"$$value", bson.A{"$$this"}}},
+				}},
+			}},
+			"updatedat": updatedAt,
+			"version":   bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$version", 0}}, 1}},
+			"seq":       seq,
+		}}},
+	}
 
+	todoEntity := TodoEntity{}
+	err = collection.FindOneAndUpdate(ctx, filter, update).Decode(&todoEntity)
 	if err != nil {
 		return nil, err
 	}
-	return repository.GetTodoRepository(currentId)
+	return &todoEntity, nil
 }
 
-func (repository *Repository) DeleteTodoRepository(id string) error {
-	collection := repository.client.Database("todo").Collection("todolist")
-	ctx, _ := context.WithTimeout(context.Background(), 15*time.Second)
-	_, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+func (repository *Repository) CreateIndexesRepository() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 
+	_, err := repository.collection("history").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "todoid", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "actor", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "at", Value: -1}}},
+	})
 	if err != nil {
 		return err
 	}
-	return nil
+
+	_, err = repository.collection("shares").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "userid", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userid", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = repository.collection("webhooks").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "events", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = repository.collection("webhookdeliveries").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextattemptat", Value: 1}}},
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "webhookid", Value: 1}, {Key: "createdat", Value: -1}}},
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "status", Value: 1}, {Key: "createdat", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = repository.calendarFeeds().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "ownerid", Value: 1}, {Key: "actorid", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = repository.collection("tombstones").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "seq", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = repository.collection("todolist").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "seq", Value: 1}}},
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "rank", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "reminders.fireat", Value: 1}}},
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "deletedat", Value: 1}}},
+		{Keys: bson.D{{Key: "done", Value: 1}, {Key: "completedat", Value: 1}}},
+		{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "priority", Value: -1}, {Key: "dueat", Value: 1}, {Key: "rank", Value: -1}}},
+	})
+	return err
 }
 
-func ConvertTodoModeltoEntity(todoModel *TodoModel) *TodoEntity {
-	todoEntity := TodoEntity{
-		ID:        todoModel.ID,
-		Content:   todoModel.Content,
-		Done:      todoModel.Done,
-		Index:     todoModel.Index,
-		CratedAt:  todoModel.CratedAt,
-		UpdatedAt: todoModel.UpdatedAt,
+// MigrateRanksRepository gives the to-dos stored before ranks were
+// introduced a rank in the order of their float index. The order does not
+// change, so the sequence is left alone.
+func (repository *Repository) MigrateRanksRepository() (int, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	filter := bson.M{"rank": bson.M{"$in": bson.A{nil, ""}}}
+	findOptions := options.Find().SetProjection(bson.M{"index": 1})
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return 0, err
 	}
-	return &todoEntity
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	models := []mongo.WriteModel{}
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		result, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return err
+		}
+		migrated += int(result.ModifiedCount)
+		models = models[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		todoEntity := TodoEntity{}
+		if err := cursor.Decode(&todoEntity); err != nil {
+			return migrated, err
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": todoEntity.ID, "rank": bson.M{"$in": bson.A{nil, ""}}}).
+			SetUpdate(bson.M{"$set": bson.M{"rank": RankFromIndex(todoEntity.Index)}}))
+		if len(models) == 500 {
+			if err := flush(); err != nil {
+				return migrated, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return migrated, err
+	}
+	return migrated, flush()
+}
+
+// ArchiveTodoRepository sets or, with a nil archivedAt, clears the archived
+// state of a to-do.
+func (repository *Repository) ArchiveTodoRepository(ownerId string, id string, archivedAt *time.Time, updatedAt time.Time) (*TodoEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	seq, release, err := repository.nextSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	filter := OwnerFilter(ownerId)
+	filter["_id"] = id
+	filter["deletedat"] = nil
+	update := bson.M{
+		"$set": bson.M{
+			"archivedat": archivedAt,
+			"updatedat":  updatedAt,
+			"seq":        seq,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return repository.GetTodoRepository(ownerId, id)
+}
+
+// TrashTodoRepository moves a to-do to the trash. Trashing is a change like
+// any other, so it also moves updatedat and wins over older edits.
+func (repository *Repository) TrashTodoRepository(ownerId string, id string, deletedAt time.Time) error {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	seq, release, err := repository.nextSeq(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	filter := OwnerFilter(ownerId)
+	filter["_id"] = id
+	filter["deletedat"] = nil
+	update := bson.M{
+		"$set": bson.M{
+			"deletedat": deletedAt,
+			"updatedat": deletedAt,
+			"seq":       seq,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	_, err = collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (repository *Repository) RestoreTodoRepository(ownerId string, id string, updatedAt time.Time) (*TodoEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	seq, release, err := repository.nextSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	filter := OwnerFilter(ownerId)
+	filter["_id"] = id
+	filter["deletedat"] = bson.M{"$ne": nil}
+	update := bson.M{
+		"$set": bson.M{
+			"deletedat": nil,
+			"updatedat": updatedAt,
+			"seq":       seq,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return repository.GetTodoRepository(ownerId, id)
+}
+
+// PurgeTrashRepository permanently removes trashed to-dos deleted at or
+// before the given time and returns them. An empty id purges every such
+// to-do of the owner.
+func (repository *Repository) PurgeTrashRepository(ownerId string, id string, deletedBefore time.Time) ([]TodoEntity, error) {
+	filter := OwnerFilter(ownerId)
+	if id != "" {
+		filter["_id"] = id
+	}
+	return repository.purgeTrash(filter, deletedBefore)
+}
+
+// PurgeExpiredTrashRepository empties the trash of every owner up to the
+// given time. It is only for background workers.
+func (repository *Repository) PurgeExpiredTrashRepository(deletedBefore time.Time) ([]TodoEntity, error) {
+	return repository.purgeTrash(bson.M{}, deletedBefore)
+}
+
+func (repository *Repository) purgeTrash(filter bson.M, deletedBefore time.Time) ([]TodoEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter["deletedat"] = bson.M{"$lte": deletedBefore}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	todoEntities := []TodoEntity{}
+	if err := cursor.All(ctx, &todoEntities); err != nil {
+		return nil, err
+	}
+	if len(todoEntities) == 0 {
+		return todoEntities, nil
+	}
+
+	ids := []string{}
+	for _, todoEntity := range todoEntities {
+		ids = append(ids, todoEntity.ID)
+	}
+	if err := repository.addTombstones(ctx, todoEntities); err != nil {
+		return nil, err
+	}
+	_, err = collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}, "deletedat": bson.M{"$lte": deletedBefore}})
+	if err != nil {
+		return nil, err
+	}
+	return todoEntities, nil
+}
+
+// SeqHoldTimeout bounds how long a taken sequence value holds back the sync
+// watermark, so a writer that died before releasing it cannot stall sync.
+const SeqHoldTimeout = time.Minute
+
+type seqCounter struct {
+	Seq     int64     `bson:"seq"`
+	Pending []seqHold `bson:"pending"`
+}
+
+type seqHold struct {
+	Seq int64     `bson:"seq"`
+	At  time.Time `bson:"at"`
+}
+
+// nextSeq takes the next value of the change sequence. Every write to a
+// to-do stores one, so syncing clients can ask for the changes after the
+// last value they saw. The value is taken and held as pending in one
+// update; release ends the hold once the write is done.
+func (repository *Repository) nextSeq(ctx context.Context) (int64, func(), error) {
+	now := time.Now().UTC()
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"seq": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$seq", 0}}, 1}}}}},
+		{{Key: "$set", Value: bson.M{"pending": bson.M{"$concatArrays": bson.A{
+			bson.M{"$filter": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$pending", bson.A{}}},
+				"cond":  bson.M{"$gte": bson.A{"$$this.at", now.Add(-SeqHoldTimeout)}},
+			}},
+			bson.A{bson.M{"seq": "$seq", "at": now}},
+		}}}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After).SetProjection(bson.M{"seq": 1})
+	counter := seqCounter{}
+	err := repository.collection("counters").FindOneAndUpdate(ctx, bson.M{"_id": "todolist"}, update, opts).Decode(&counter)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	release := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		update := bson.M{"$pull": bson.M{"pending": bson.M{"seq": counter.Seq}}}
+		if _, err := repository.collection("counters").UpdateOne(ctx, bson.M{"_id": "todolist"}, update); err != nil {
+			log.Printf("releasing change sequence %d: %v", counter.Seq, err)
+		}
+	}
+	return counter.Seq, release, nil
+}
+
+// SyncWatermarkRepository returns the highest value of the change sequence
+// up to which every write is done. Values are taken before the write, so a
+// later value can be visible while an earlier one is still being written;
+// a sync token past such a gap would skip the earlier write for good.
+func (repository *Repository) SyncWatermarkRepository() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	counter := seqCounter{}
+	err := repository.collection("counters").FindOne(ctx, bson.M{"_id": "todolist"}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	watermark := counter.Seq
+	expired := time.Now().UTC().Add(-SeqHoldTimeout)
+	for _, hold := range counter.Pending {
+		if hold.At.After(expired) && hold.Seq <= watermark {
+			watermark = hold.Seq - 1
+		}
+	}
+	return watermark, nil
+}
+
+func (repository *Repository) addTombstones(ctx context.Context, todoEntities []TodoEntity) error {
+	seq, release, err := repository.nextSeq(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	deletedAt := time.Now().UTC()
+	for _, todoEntity := range todoEntities {
+		update := bson.M{"$set": bson.M{"ownerid": todoEntity.OwnerID, "seq": seq, "deletedat": deletedAt, "fromtrash": todoEntity.DeletedAt != nil}}
+		_, err := repository.collection("tombstones").UpdateOne(ctx, bson.M{"_id": todoEntity.ID}, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTodoChangesRepository returns the to-dos of an owner, trashed ones
+// included, written after the given sequence value, in sequence order.
+// A zero limit returns all of them.
+func (repository *Repository) GetTodoChangesRepository(ownerId string, since int64, limit int) ([]TodoEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := OwnerFilter(ownerId)
+	if since > 0 {
+		filter["seq"] = bson.M{"$gt": since}
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	if limit != 0 {
+		findOptions.SetLimit(int64(limit))
+	}
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	todoEntities := []TodoEntity{}
+	if err := cursor.All(ctx, &todoEntities); err != nil {
+		return nil, err
+	}
+	return todoEntities, nil
+}
+
+func (repository *Repository) GetTombstonesRepository(ownerId string, since int64, limit int) ([]TombstoneEntity, error) {
+	collection := repository.collection("tombstones")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := OwnerFilter(ownerId)
+	filter["seq"] = bson.M{"$gt": since}
+	findOptions := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	if limit != 0 {
+		findOptions.SetLimit(int64(limit))
+	}
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tombstoneEntities := []TombstoneEntity{}
+	if err := cursor.All(ctx, &tombstoneEntities); err != nil {
+		return nil, err
+	}
+	return tombstoneEntities, nil
+}
+
+func (repository *Repository) AddHistoryRepository(historyEntity *HistoryEntity) error {
+	collection := repository.collection("history")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, historyEntity)
+	return err
+}
+
+func (repository *Repository) GetHistoryRepository(auditFilterModel *AuditFilterModel, page int, size int) ([]HistoryEntity, int, error) {
+	collection := repository.collection("history")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := OwnerFilter(auditFilterModel.OwnerID)
+	if auditFilterModel.TodoID != "" {
+		filter["todoid"] = auditFilterModel.TodoID
+	}
+	if auditFilterModel.Actor != "" {
+		filter["actor"] = auditFilterModel.Actor
+	}
+	if auditFilterModel.Action != "" {
+		filter["action"] = auditFilterModel.Action
+	}
+	at := bson.M{}
+	if auditFilterModel.From != nil {
+		at["$gte"] = *auditFilterModel.From
+	}
+	if auditFilterModel.To != nil {
+		at["$lt"] = *auditFilterModel.To
+	}
+	if len(at) > 0 {
+		filter["at"] = at
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "at", Value: -1}})
+	if size != 0 {
+		findOptions.SetSkip(int64(page * size))
+		findOptions.SetLimit(int64(size))
+	}
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	historyEntities := []HistoryEntity{}
+	if err := cursor.All(ctx, &historyEntities); err != nil {
+		return nil, 0, err
+	}
+
+	totalElements, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	return historyEntities, int(totalElements), nil
+}
+
+// ReplaceTodoRepository overwrites a to-do with a snapshot, provided it is
+// still at the given version. mongo.ErrNoDocuments reports a concurrent
+// change.
+func (repository *Repository) ReplaceTodoRepository(todoEntity *TodoEntity, version int64) (*TodoEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	seq, release, err := repository.nextSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	replacement := *todoEntity
+	replacement.Version = version + 1
+	replacement.Seq = seq
+
+	filter := OwnerFilter(todoEntity.OwnerID)
+	filter["_id"] = todoEntity.ID
+	filter["version"] = version
+
+	result, err := collection.ReplaceOne(ctx, filter, replacement)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return repository.GetTodoRepository(todoEntity.OwnerID, todoEntity.ID)
+}
+
+// InsertTodoEntityRepository brings back a removed to-do from a snapshot.
+func (repository *Repository) InsertTodoEntityRepository(todoEntity *TodoEntity) (*TodoEntity, error) {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	seq, release, err := repository.nextSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	insertion := *todoEntity
+	insertion.Seq = seq
+	_, err = collection.InsertOne(ctx, insertion)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, mongo.ErrNoDocuments
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The to-do is back, so it no longer needs a tombstone.
+	if _, err := repository.collection("tombstones").DeleteOne(ctx, bson.M{"_id": todoEntity.ID}); err != nil {
+		return nil, err
+	}
+	return repository.GetTodoRepository(todoEntity.OwnerID, todoEntity.ID)
+}
+
+func (repository *Repository) DeleteTodoVersionRepository(ownerId string, id string, version int64) error {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := OwnerFilter(ownerId)
+	filter["_id"] = id
+	filter["version"] = version
+	todoEntity := TodoEntity{}
+	err := collection.FindOneAndDelete(ctx, filter).Decode(&todoEntity)
+	if err != nil {
+		return err
+	}
+	return repository.addTombstones(ctx, []TodoEntity{todoEntity})
+}
+
+func (repository *Repository) DeleteTodoRepository(ownerId string, id string) error {
+	collection := repository.collection("todolist")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	filter := OwnerFilter(ownerId)
+	filter["_id"] = id
+	todoEntity := TodoEntity{}
+	err := collection.FindOneAndDelete(ctx, filter).Decode(&todoEntity)
+
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return repository.addTombstones(ctx, []TodoEntity{todoEntity})
+}
+
+func (repository *Repository) GetAPIKeyRepository(hash string) (*APIKeyEntity, error) {
+	collection := repository.collection("apikeys")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	apiKeyEntity := APIKeyEntity{}
+	err := collection.FindOne(ctx, bson.M{"_id": hash}).Decode(&apiKeyEntity)
+
+	if err != nil {
+		return nil, err
+	}
+	return &apiKeyEntity, nil
+}
+
+func (repository *Repository) AddCalendarFeedRepository(calendarFeedEntity *CalendarFeedEntity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	_, err := repository.calendarFeeds().InsertOne(ctx, calendarFeedEntity)
+	return err
+}
+
+func (repository *Repository) GetCalendarFeedByHashRepository(hash string) (*CalendarFeedEntity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	calendarFeedEntity := CalendarFeedEntity{}
+	err := repository.calendarFeeds().FindOne(ctx, bson.M{"hash": hash}).Decode(&calendarFeedEntity)
+
+	if err != nil {
+		return nil, err
+	}
+	return &calendarFeedEntity, nil
+}
+
+func (repository *Repository) GetCalendarFeedsRepository(tenant string, ownerId string, actorId string) ([]CalendarFeedEntity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{"tenant": tenant, "ownerid": ownerId, "actorid": actorId}
+	cursor, err := repository.calendarFeeds().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	calendarFeedEntities := []CalendarFeedEntity{}
+	if err := cursor.All(ctx, &calendarFeedEntities); err != nil {
+		return nil, err
+	}
+	return calendarFeedEntities, nil
+}
+
+func (repository *Repository) DeleteCalendarFeedRepository(tenant string, ownerId string, actorId string, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	result, err := repository.calendarFeeds().DeleteOne(ctx, bson.M{"_id": id, "tenant": tenant, "ownerid": ownerId, "actorid": actorId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// PutShareRepository grants a user a role on a list. Sharing the same list
+// with the same user again only changes the role.
+func (repository *Repository) PutShareRepository(shareEntity *ShareEntity) (*ShareEntity, error) {
+	collection := repository.collection("shares")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{"ownerid": shareEntity.OwnerID, "userid": shareEntity.UserID}
+	update := bson.M{
+		"$set": bson.M{
+			"role":      shareEntity.Role,
+			"invitedby": shareEntity.InvitedBy,
+		},
+		"$setOnInsert": bson.M{
+			"_id":       shareEntity.ID,
+			"createdat": shareEntity.CreatedAt,
+		},
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return nil, err
+	}
+	return repository.GetShareRepository(shareEntity.OwnerID, shareEntity.UserID)
+}
+
+func (repository *Repository) GetShareRepository(ownerId string, userId string) (*ShareEntity, error) {
+	collection := repository.collection("shares")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	shareEntity := ShareEntity{}
+	err := collection.FindOne(ctx, bson.M{"ownerid": ownerId, "userid": userId}).Decode(&shareEntity)
+
+	if err != nil {
+		return nil, err
+	}
+	return &shareEntity, nil
+}
+
+func (repository *Repository) GetSharesRepository(ownerId string) ([]ShareEntity, error) {
+	return repository.findShares(bson.M{"ownerid": ownerId})
+}
+
+func (repository *Repository) GetSharedWithRepository(userId string) ([]ShareEntity, error) {
+	return repository.findShares(bson.M{"userid": userId})
+}
+
+func (repository *Repository) findShares(filter bson.M) ([]ShareEntity, error) {
+	collection := repository.collection("shares")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	shareEntities := []ShareEntity{}
+	if err := cursor.All(ctx, &shareEntities); err != nil {
+		return nil, err
+	}
+	return shareEntities, nil
+}
+
+func (repository *Repository) DeleteShareRepository(ownerId string, userId string) error {
+	collection := repository.collection("shares")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"ownerid": ownerId, "userid": userId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (repository *Repository) AddWebhookRepository(webhookEntity *WebhookEntity) error {
+	collection := repository.collection("webhooks")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, webhookEntity)
+	return err
+}
+
+func (repository *Repository) GetWebhookRepository(ownerId string, id string) (*WebhookEntity, error) {
+	collection := repository.collection("webhooks")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	webhookEntity := WebhookEntity{}
+	err := collection.FindOne(ctx, bson.M{"_id": id, "ownerid": ownerId}).Decode(&webhookEntity)
+
+	if err != nil {
+		return nil, err
+	}
+	return &webhookEntity, nil
+}
+
+// GetWebhooksRepository returns the webhooks of an owner, or only those
+// subscribed to one of the given events.
+func (repository *Repository) GetWebhooksRepository(ownerId string, events ...string) ([]WebhookEntity, error) {
+	collection := repository.collection("webhooks")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{"ownerid": ownerId}
+	if len(events) > 0 {
+		filter["events"] = bson.M{"$in": events}
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	webhookEntities := []WebhookEntity{}
+	if err := cursor.All(ctx, &webhookEntities); err != nil {
+		return nil, err
+	}
+	return webhookEntities, nil
+}
+
+func (repository *Repository) DeleteWebhookRepository(ownerId string, id string) error {
+	collection := repository.collection("webhooks")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": id, "ownerid": ownerId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (repository *Repository) AddWebhookDeliveriesRepository(deliveryEntities []WebhookDeliveryEntity) error {
+	if len(deliveryEntities) == 0 {
+		return nil
+	}
+	collection := repository.collection("webhookdeliveries")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	documents := []interface{}{}
+	for _, deliveryEntity := range deliveryEntities {
+		documents = append(documents, deliveryEntity)
+	}
+	_, err := collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+
+	// Instances tailing the same change stream queue the same deliveries;
+	// the ones already queued are skipped.
+	if bulkErr, ok := err.(mongo.BulkWriteException); ok && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if writeErr.Code != 11000 {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// ClaimWebhookDeliveryRepository leases the next pending delivery that is
+// due by pushing its next attempt to lockedUntil, so other instances skip
+// it while it is being sent.
+func (repository *Repository) ClaimWebhookDeliveryRepository(now time.Time, lockedUntil time.Time) (*WebhookDeliveryEntity, error) {
+	collection := repository.collection("webhookdeliveries")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{"status": WebhookDeliveryPending, "nextattemptat": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"nextattemptat": lockedUntil}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "nextattemptat", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	deliveryEntity := WebhookDeliveryEntity{}
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&deliveryEntity)
+	if err != nil {
+		return nil, err
+	}
+	return &deliveryEntity, nil
+}
+
+func (repository *Repository) UpdateWebhookDeliveryRepository(deliveryEntity *WebhookDeliveryEntity) error {
+	collection := repository.collection("webhookdeliveries")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": deliveryEntity.ID}, deliveryEntity)
+	return err
+}
+
+// GetWebhookDeliveriesRepository returns the newest deliveries of an owner,
+// filtered by webhook and status when they are not empty.
+func (repository *Repository) GetWebhookDeliveriesRepository(ownerId string, webhookId string, status string, page int, size int) ([]WebhookDeliveryEntity, int, error) {
+	collection := repository.collection("webhookdeliveries")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{"ownerid": ownerId}
+	if webhookId != "" {
+		filter["webhookid"] = webhookId
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "createdat", Value: -1}})
+	if size != 0 {
+		findOptions.SetSkip(int64(page * size))
+		findOptions.SetLimit(int64(size))
+	}
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveryEntities := []WebhookDeliveryEntity{}
+	if err := cursor.All(ctx, &deliveryEntities); err != nil {
+		return nil, 0, err
+	}
+
+	totalElements, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	return deliveryEntities, int(totalElements), nil
+}
+
+// RetryWebhookDeliveryRepository moves a dead delivery back to the queue
+// with a fresh set of attempts.
+func (repository *Repository) RetryWebhookDeliveryRepository(ownerId string, id string, now time.Time) (*WebhookDeliveryEntity, error) {
+	collection := repository.collection("webhookdeliveries")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "ownerid": ownerId, "status": WebhookDeliveryDead}
+	update := bson.M{"$set": bson.M{"status": WebhookDeliveryPending, "attempts": 0, "nextattemptat": now}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	deliveryEntity := WebhookDeliveryEntity{}
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&deliveryEntity)
+	if err != nil {
+		return nil, err
+	}
+	return &deliveryEntity, nil
+}
+
+// WatchTodoChangesRepository watches the to-do and the tombstone
+// collections, after the given resume token when it is not nil. Deletes of
+// to-dos only carry the id, so they are published from the tombstone,
+// which knows the owner.
+func (repository *Repository) WatchTodoChangesRepository(ctx context.Context, resumeToken bson.Raw) (*mongo.ChangeStream, error) {
+	database := repository.client.Database(repository.database)
+	collections := bson.A{repository.collection("todolist").Name(), repository.collection("tombstones").Name()}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"ns.coll": bson.M{"$in": collections}}}}}
+
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if resumeToken != nil {
+		opts.SetResumeAfter(resumeToken)
+	}
+	return database.Watch(ctx, pipeline, opts)
+}
+
+func (repository *Repository) GetResumeTokenRepository(name string) (bson.Raw, error) {
+	collection := repository.collection("changestreams")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	changeStreamEntity := ChangeStreamEntity{}
+	err := collection.FindOne(ctx, bson.M{"_id": name}).Decode(&changeStreamEntity)
+
+	if err != nil {
+		return nil, err
+	}
+	return changeStreamEntity.Token, nil
+}
+
+func (repository *Repository) SaveResumeTokenRepository(name string, token bson.Raw) error {
+	collection := repository.collection("changestreams")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"token": token, "updatedat": time.Now().UTC()}}
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": name}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (repository *Repository) DeleteResumeTokenRepository(name string) error {
+	collection := repository.collection("changestreams")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": name})
+	return err
+}
+
+func (repository *Repository) AddTenantRepository(tenantEntity *TenantEntity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	_, err := repository.tenants().InsertOne(ctx, tenantEntity)
+	return err
+}
+
+func (repository *Repository) GetTenantRepository(name string) (*TenantEntity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tenantEntity := TenantEntity{}
+	err := repository.tenants().FindOne(ctx, bson.M{"_id": name}).Decode(&tenantEntity)
+
+	if err != nil {
+		return nil, err
+	}
+	return &tenantEntity, nil
+}
+
+func (repository *Repository) GetTenantsRepository() ([]TenantEntity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	cursor, err := repository.tenants().Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tenantEntities := []TenantEntity{}
+	if err := cursor.All(ctx, &tenantEntities); err != nil {
+		return nil, err
+	}
+	return tenantEntities, nil
+}
+
+func (repository *Repository) DeleteTenantRepository(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	result, err := repository.tenants().DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DropTenantDataRepository removes every collection of a tenant repository.
+func (repository *Repository) DropTenantDataRepository() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	database := repository.client.Database(repository.database)
+	if repository.prefix == "" {
+		return database.Drop(ctx)
+	}
+
+	names, err := database.ListCollectionNames(ctx, bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(repository.prefix)}})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := database.Collection(name).Drop(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachTenantRepository runs job on the default database and then on
+// every tenant. A failing tenant does not stop the others; the first error
+// is returned.
+func (repository *Repository) ForEachTenantRepository(job func(*Repository) error) error {
+	firstErr := job(repository)
+
+	tenantEntities, err := repository.GetTenantsRepository()
+	if err != nil {
+		return err
+	}
+	for i := range tenantEntities {
+		if err := job(repository.ForTenant(&tenantEntities[i])); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func ConvertTodoModeltoEntity(todoModel *TodoModel) *TodoEntity {
+	todoEntity := TodoEntity{
+		ID:          todoModel.ID,
+		OwnerID:     todoModel.OwnerID,
+		Content:     todoModel.Content,
+		Done:        todoModel.Done,
+		Index:       todoModel.Index,
+		Rank:        todoModel.Rank,
+		DueAt:       todoModel.DueAt,
+		StartAt:     todoModel.StartAt,
+		Recurrence:  todoModel.Recurrence,
+		Timezone:    todoModel.Timezone,
+		SeriesID:    todoModel.SeriesID,
+		Occurrence:  todoModel.Occurrence,
+		NextID:      todoModel.NextID,
+		Priority:    todoModel.Priority,
+		Tags:        todoModel.Tags,
+		Notes:       todoModel.Notes,
+		CompletedAt: todoModel.CompletedAt,
+		Reminders:   ConvertReminderModelstoEntities(todoModel.Reminders),
+		CratedAt:    todoModel.CratedAt,
+		UpdatedAt:   todoModel.UpdatedAt,
+	}
+	if todoEntity.Rank == "" {
+		todoEntity.Rank = RankFromIndex(todoEntity.Index)
+	}
+	return &todoEntity
+}
+
+// OwnerFilter scopes a query to the to-dos of one owner. To-dos stored
+// before ownership was introduced belong to the anonymous owner.
+func OwnerFilter(ownerId string) bson.M {
+	switch ownerId {
+	case "":
+		return bson.M{"ownerid": bson.M{"$in": bson.A{"", nil}}}
+	default:
+		return bson.M{"ownerid": ownerId}
+	}
+}
+
+func BuildTodoFilter(filterModel *TodoFilterModel) bson.M {
+	filter := bson.M{}
+	if filterModel == nil {
+		return filter
+	}
+
+	if filterModel.Trash {
+		filter["deletedat"] = bson.M{"$ne": nil}
+	} else {
+		filter["deletedat"] = nil
+	}
+	if filterModel.Archived != nil {
+		if *filterModel.Archived {
+			filter["archivedat"] = bson.M{"$ne": nil}
+		} else {
+			filter["archivedat"] = nil
+		}
+	}
+	if filterModel.CompletedBefore != nil {
+		filter["done"] = true
+		filter["completedat"] = bson.M{"$lte": *filterModel.CompletedBefore}
+	}
+
+	dueAt := bson.M{}
+	if filterModel.DueFrom != nil {
+		dueAt["$gte"] = *filterModel.DueFrom
+	}
+	if filterModel.DueTo != nil {
+		dueAt["$lt"] = *filterModel.DueTo
+	}
+	if len(dueAt) > 0 {
+		filter["dueat"] = dueAt
+	}
+	if filterModel.NoDue {
+		filter["dueat"] = nil
+	}
+	if filterModel.NotDone {
+		filter["done"] = false
+	}
+	if filterModel.SeriesID != "" {
+		filter["seriesid"] = filterModel.SeriesID
+	}
+	tags := bson.M{}
+	if len(filterModel.AllTags) > 0 {
+		tags["$all"] = filterModel.AllTags
+	}
+	if len(filterModel.AnyTags) > 0 {
+		tags["$in"] = filterModel.AnyTags
+	}
+	if len(filterModel.NoTags) > 0 {
+		tags["$nin"] = filterModel.NoTags
+	}
+	if len(tags) > 0 {
+		filter["tags"] = tags
+	}
+	if filterModel.Search != "" {
+		search := primitive.Regex{Pattern: regexp.QuoteMeta(filterModel.Search), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"content": search}, bson.M{"notes": search}}
+	}
+	if len(filterModel.Priorities) > 0 {
+		// To-dos from before priorities have no priority field, which
+		// reads as no priority.
+		priorities := bson.A{}
+		for _, priority := range filterModel.Priorities {
+			priorities = append(priorities, priority)
+			if priority == PriorityNone {
+				priorities = append(priorities, nil)
+			}
+		}
+		filter["priority"] = bson.M{"$in": priorities}
+	}
+
+	return filter
+}
+
+func ConvertReminderModelstoEntities(reminderModels []ReminderModel) []ReminderEntity {
+	reminderEntities := []ReminderEntity{}
+	for _, reminderModel := range reminderModels {
+		reminderEntities = append(reminderEntities, ReminderEntity{
+			ID:        reminderModel.ID,
+			At:        reminderModel.At,
+			BeforeDue: int64(reminderModel.BeforeDue / time.Second),
+			FireAt:    reminderModel.FireAt,
+			SentAt:    reminderModel.SentAt,
+		})
+	}
+	return reminderEntities
+}
+
+// TodoOrder is the manual order of a list. To-dos that were moved to the
+// same place concurrently share a rank and are ordered by id.
+var TodoOrder = bson.D{{Key: "rank", Value: -1}, {Key: "_id", Value: -1}}
+
+// BuildSmartSortPipeline orders by priority, then due date with undated
+// to-dos last, then by the manual order.
+func BuildSmartSortPipeline(filter bson.M, page int, size int) mongo.Pipeline {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{
+			"nodue": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$dueat", nil}}, nil}}, 1, 0}},
+		}}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "priority", Value: -1},
+			{Key: "nodue", Value: 1},
+			{Key: "dueat", Value: 1},
+			{Key: "rank", Value: -1},
+			{Key: "_id", Value: -1},
+		}}},
+	}
+	if size != 0 {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$skip", Value: int64(page * size)}},
+			bson.D{{Key: "$limit", Value: int64(size)}},
+		)
+	}
+	return pipeline
+}
+
+// DiffTodoEntities lists the stored fields that differ between two versions
+// of a to-do. Bookkeeping fields are left out.
+func DiffTodoEntities(before *TodoEntity, after *TodoEntity) []FieldChangeEntity {
+	beforeFields := TodoEntityFields(before)
+	afterFields := TodoEntityFields(after)
+
+	fields := []string{}
+	for field := range beforeFields {
+		fields = append(fields, field)
+	}
+	for field := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := []FieldChangeEntity{}
+	for _, field := range fields {
+		switch field {
+		case "_id", "updatedat", "reminders", "seq", "version", "nextid":
+			continue
+		}
+		if reflect.DeepEqual(beforeFields[field], afterFields[field]) {
+			continue
+		}
+		changes = append(changes, FieldChangeEntity{
+			Field:  field,
+			Before: beforeFields[field],
+			After:  afterFields[field],
+		})
+	}
+	return changes
+}
+
+func TodoEntityFields(todoEntity *TodoEntity) bson.M {
+	fields := bson.M{}
+	if todoEntity == nil {
+		return fields
+	}
+
+	data, err := bson.Marshal(todoEntity)
+	if err != nil {
+		return fields
+	}
+	bson.Unmarshal(data, &fields)
+	return fields
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ReminderNotification struct {
+	TodoID     string     `json:"todoId"`
+	ReminderID string     `json:"reminderId"`
+	Content    string     `json:"content"`
+	DueAt      *time.Time `json:"dueAt,omitempty"`
+	FireAt     time.Time  `json:"fireAt"`
+}
+
+type ReminderScheduler struct {
+	repository *Repository
+	notifiers  []Notifier
+	lease      time.Duration
+}
+
+func NewReminderScheduler(repository *Repository, notifiers ...Notifier) *ReminderScheduler {
+	return &ReminderScheduler{
+		repository: repository,
+		notifiers:  notifiers,
+		lease:      time.Minute,
+	}
+}
+
+func (scheduler *ReminderScheduler) Start(interval time.Duration) func() {
+	return StartWorker("reminder scheduler", interval, scheduler.RunOnce)
+}
+
+// RunOnce delivers every reminder that is due, in the default database and
+// in every tenant. A reminder is only marked as sent after all notifiers
+// succeeded, so a crash or a failing notifier leads to another delivery
+// once the lease expires.
+func (scheduler *ReminderScheduler) RunOnce() error {
+	return scheduler.repository.ForEachTenantRepository(scheduler.deliver)
+}
+
+func (scheduler *ReminderScheduler) deliver(repository *Repository) error {
+	for {
+		now := time.Now().UTC()
+		claimID := uuid.New().String()
+
+		todoEntity, err := repository.ClaimDueRemindersRepository(now, now.Add(scheduler.lease), claimID)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, reminderEntity := range todoEntity.Reminders {
+			if reminderEntity.ClaimID != claimID {
+				continue
+			}
+
+			notification := ReminderNotification{
+				TodoID:     todoEntity.ID,
+				ReminderID: reminderEntity.ID,
+				Content:    todoEntity.Content,
+				DueAt:      todoEntity.DueAt,
+				FireAt:     reminderEntity.FireAt,
+			}
+
+			if err := scheduler.notify(&notification); err != nil {
+				log.Printf("reminder %s of to-do %s: %v", reminderEntity.ID, todoEntity.ID, err)
+				retryAt := now.Add(scheduler.backoff(reminderEntity.Attempts))
+				if err := repository.ReleaseReminderRepository(todoEntity.ID, reminderEntity.ID, retryAt); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := repository.CompleteReminderRepository(todoEntity.ID, reminderEntity.ID, now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (scheduler *ReminderScheduler) notify(notification *ReminderNotification) error {
+	for _, notifier := range scheduler.notifiers {
+		if err := notifier.Notify(notification); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (scheduler *ReminderScheduler) backoff(attempts int) time.Duration {
+	backoff := time.Duration(float64(scheduler.lease) * math.Pow(2, float64(attempts)))
+	if backoff > time.Hour {
+		return time.Hour
+	}
+	return backoff
+}
@@ -1,23 +1,151 @@
 package main
 
 import (
+	"encoding/json"
+	"log"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 //SERVICE
 type TodoModel struct {
-	ID        string    `Json:"id"`
-	Content   string    `json:"content"`
-	Done      bool      `json:"done"`
-	Index     float64   `json:"index"`
-	CratedAt  time.Time `json:"createdat"`
-	UpdatedAt time.Time `json:"updatedat"`
+	ID          string          `Json:"id"`
+	OwnerID     string          `json:"ownerId,omitempty"`
+	Content     string          `json:"content"`
+	Done        bool            `json:"done"`
+	Index       float64         `json:"index"`
+	Rank        string          `json:"rank"`
+	DueAt       *time.Time      `json:"dueAt,omitempty"`
+	StartAt     *time.Time      `json:"startAt,omitempty"`
+	Recurrence  string          `json:"recurrence,omitempty"`
+	Timezone    string          `json:"timezone,omitempty"`
+	SeriesID    string          `json:"seriesId,omitempty"`
+	Occurrence  int             `json:"occurrence,omitempty"`
+	NextID      string          `json:"nextId,omitempty"`
+	Priority    int             `json:"priority"`
+	Tags        []string        `json:"tags,omitempty"`
+	Notes       string          `json:"notes,omitempty"`
+	DeletedAt   *time.Time      `json:"deletedAt,omitempty"`
+	CompletedAt *time.Time      `json:"completedAt,omitempty"`
+	Reminders   []ReminderModel `json:"reminders,omitempty"`
+	CratedAt    time.Time       `json:"createdat"`
+	UpdatedAt   time.Time       `json:"updatedat"`
 }
 
+type ReminderModel struct {
+	ID        string        `json:"id"`
+	At        *time.Time    `json:"at,omitempty"`
+	BeforeDue time.Duration `json:"beforeDue,omitempty"`
+	FireAt    time.Time     `json:"fireAt"`
+	SentAt    *time.Time    `json:"sentAt,omitempty"`
+}
+
+const (
+	DueOverdue = "overdue"
+	DueToday   = "today"
+	DueWeek    = "week"
+	DueNone    = "none"
+)
+
+const (
+	PriorityNone = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
+var PriorityNames = []string{"none", "low", "medium", "high", "urgent"}
+
+const SortSmart = "smart"
+
+type TodoFilterModel struct {
+	DueFrom         *time.Time
+	DueTo           *time.Time
+	NoDue           bool
+	NotDone         bool
+	SeriesID        string
+	Priorities      []int
+	AllTags         []string
+	AnyTags         []string
+	NoTags          []string
+	Search          string
+	Sort            string
+	Trash           bool
+	Archived        *bool
+	CompletedBefore *time.Time
+}
+
+// Actor is whoever performs an operation through the Service. An empty ID
+// stands for an anonymous caller. OwnerID is the owner of the to-dos the
+// actor works on; the empty owner is the list shared by anonymous callers.
+type Actor struct {
+	ID      string
+	OwnerID string
+}
+
+// ReservedOwnerID is refused as a principal and as a list owner, so no
+// caller can pass for a wildcard. Background workers reach the to-dos of
+// every owner through their own repository methods instead.
+const ReservedOwnerID = "*"
+
+var SystemActor = &Actor{ID: "system"}
+
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionReorder   = "reorder"
+	ActionDelete    = "delete"
+	ActionRestore   = "restore"
+	ActionPurge     = "purge"
+	ActionUndo      = "undo"
+	ActionRedo      = "redo"
+	ActionArchive   = "archive"
+	ActionUnarchive = "unarchive"
+)
+
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// RoleRanks orders the roles of a shared list; every role can do what the
+// lower ones can.
+var RoleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+type AuditFilterModel struct {
+	OwnerID string
+	TodoID  string
+	Actor   string
+	Action  string
+	From    *time.Time
+	To      *time.Time
+}
+
+// ImportRowModel is one to-do read from an imported file. Row is its
+// position in the file and Error is set when the row could not be read.
+type ImportRowModel struct {
+	Row   int
+	Todo  *TodoDTO
+	Error string
+}
+
+const (
+	ImportValid   = "valid"
+	ImportCreated = "created"
+	ImportFailed  = "failed"
+)
+
 type Page struct {
 	Number        int `json:"number"`
 	Size          int `json:"size,omitempty"`
@@ -30,44 +158,221 @@ type TodoListModel struct {
 	Page     Page      `json:"page"`
 }
 
+// ErrQuotaExceeded is returned when an owner already has as many to-dos as
+// the quota allows.
+var ErrQuotaExceeded = fiber.NewError(fiber.StatusForbidden, "To-do quota exceeded")
+
 type Service struct {
 	repository *Repository
+	undoStack  *UndoStack
+	tenants    *sync.Map
+	todoQuota  int
+	events     *EventBus
+
+	// webhookHosts are the hosts webhooks may use although they are not
+	// public.
+	webhookHosts WebhookHosts
+
+	// changeStream names the change stream watchers; when it is set the
+	// events come from the change stream instead of publish.
+	changeStream string
 }
 
 func NewService(repository *Repository) *Service {
 	return &Service{
 		repository: repository,
+		undoStack:  NewUndoStack(DefaultUndoDepth),
+		tenants:    &sync.Map{},
+		events:     NewEventBus(DefaultEventBufferSize),
 	}
 }
 
-func (service *Service) PostTodoService(todoDTO *TodoDTO) (*TodoDTO, error) {
-	if len(todoDTO.Content) < 1 {
+// SetTodoQuota limits the number of to-dos, trashed ones included, each
+// owner can keep. Zero means no limit.
+func (service *Service) SetTodoQuota(quota int) {
+	service.todoQuota = quota
+}
+
+// SetWebhookHosts allows webhooks to local hosts, for testing.
+func (service *Service) SetWebhookHosts(hosts WebhookHosts) {
+	service.webhookHosts = hosts
+}
+
+// EnableChangeStream publishes the changes of the to-do collections, made
+// by this or any other service, from Mongo change streams. The watcher of
+// a tenant starts with its service, so the tenants are loaded here. The
+// name keeps the resume tokens of the instances apart.
+func (service *Service) EnableChangeStream(name string) func() {
+	service.changeStream = name
+	stop := NewChangeStreamWatcher(service, name).Start()
+
+	err := service.ForEachTenant(func(*Service) error {
+		return nil
+	})
+	if err != nil {
+		log.Println("loading tenants for change streams:", err)
+	}
+	return stop
+}
+
+// ForTenant returns the Service working on the data of a provisioned
+// tenant. The empty tenant is the default database.
+func (service *Service) ForTenant(tenant string) (*Service, error) {
+	if tenant == "" {
+		return service, nil
+	}
+	if tenantService, ok := service.tenants.Load(tenant); ok {
+		return tenantService.(*Service), nil
+	}
+
+	tenantEntity, err := service.repository.GetTenantRepository(tenant)
+	if err == mongo.ErrNoDocuments {
+		return nil, fiber.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tenantService, loaded := service.tenants.LoadOrStore(tenant, &Service{
+		repository:   service.repository.ForTenant(tenantEntity),
+		undoStack:    NewUndoStack(DefaultUndoDepth),
+		tenants:      service.tenants,
+		todoQuota:    service.todoQuota,
+		events:       NewEventBus(DefaultEventBufferSize),
+		changeStream: service.changeStream,
+		webhookHosts: service.webhookHosts,
+	})
+	if !loaded && service.changeStream != "" {
+		NewChangeStreamWatcher(tenantService.(*Service), service.changeStream).Start()
+	}
+	return tenantService.(*Service), nil
+}
+
+// ForEachTenant runs a background job on the default database and on every
+// tenant.
+func (service *Service) ForEachTenant(job func(*Service) error) error {
+	firstErr := job(service)
+
+	tenantEntities, err := service.repository.GetTenantsRepository()
+	if err != nil {
+		return err
+	}
+	for _, tenantEntity := range tenantEntities {
+		tenantService, err := service.ForTenant(tenantEntity.Name)
+		if err == nil {
+			err = job(tenantService)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// CreateTenantService registers a tenant and creates the indexes of its
+// collections.
+func (service *Service) CreateTenantService(tenantDTO *TenantDTO) (*TenantDTO, error) {
+	if !ValidTenantName(tenantDTO.Name) {
 		return nil, fiber.ErrBadRequest
 	}
+	if tenantDTO.Isolation == "" {
+		tenantDTO.Isolation = TenantIsolationDatabase
+	}
+	if tenantDTO.Isolation != TenantIsolationDatabase && tenantDTO.Isolation != TenantIsolationCollection {
+		return nil, fiber.ErrBadRequest
+	}
+
+	tenantEntity := TenantEntity{
+		Name:      tenantDTO.Name,
+		Isolation: tenantDTO.Isolation,
+		CreatedAt: time.Now().Round(time.Minute).UTC(),
+	}
+	err := service.repository.AddTenantRepository(&tenantEntity)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, fiber.ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	todoListEntitiy, _, _ := service.repository.GetTodoListRepository(0, 0)
+	if err := service.repository.ForTenant(&tenantEntity).CreateIndexesRepository(); err != nil {
+		return nil, err
+	}
 
-	index := float64(0)
-	if len(todoListEntitiy.TodoList) > 0 {
-		index = todoListEntitiy.TodoList[0].Index + float64(10)
+	return ConvertTenantEntitytoDTO(&tenantEntity), nil
+}
+
+func (service *Service) GetTenantsService() (*TenantListDTO, error) {
+	tenantEntities, err := service.repository.GetTenantsRepository()
+	if err != nil {
+		return nil, err
 	}
 
-	todoModel := ConvertTodoDTOtoModel(todoDTO)
-	todoModel.ID = uuid.New().String()
-	todoModel.Index = index
-	todoModel.CratedAt = time.Now().Round(time.Minute).UTC()
-	todoModel.UpdatedAt = time.Now().Round(time.Minute).UTC()
+	tenantListDTO := TenantListDTO{Tenants: []TenantDTO{}}
+	for i := range tenantEntities {
+		tenantListDTO.Tenants = append(tenantListDTO.Tenants, *ConvertTenantEntitytoDTO(&tenantEntities[i]))
+	}
+	return &tenantListDTO, nil
+}
+
+// DeleteTenantService permanently removes a tenant together with all of its
+// data.
+func (service *Service) DeleteTenantService(name string) error {
+	tenantEntity, err := service.repository.GetTenantRepository(name)
+	if err == mongo.ErrNoDocuments {
+		return fiber.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := service.repository.ForTenant(tenantEntity).DropTenantDataRepository(); err != nil {
+		return err
+	}
+	if err := service.repository.DeleteTenantRepository(name); err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	service.tenants.Delete(name)
+
+	return nil
+}
+
+func (service *Service) PostTodoService(actor *Actor, todoDTO *TodoDTO) (*TodoDTO, error) {
+	return service.createTodo(actor, uuid.New().String(), todoDTO)
+}
+
+func (service *Service) createTodo(actor *Actor, id string, todoDTO *TodoDTO) (*TodoDTO, error) {
+	if len(todoDTO.Content) < 1 {
+		return nil, fiber.ErrBadRequest
+	}
+	if !ValidateTodoDTO(todoDTO) {
+		return nil, fiber.ErrBadRequest
+	}
+	if service.todoQuota > 0 {
+		count, err := service.repository.CountTodosRepository(actor.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+		if count >= service.todoQuota {
+			return nil, ErrQuotaExceeded
+		}
+	}
+
+	todoModel := NewTodoModel(actor, id, todoDTO)
+	todoModel.Index, todoModel.Rank = service.nextPosition(actor.OwnerID)
 
 	todoEntity, err := service.repository.AddTodoRepository(todoModel)
 	if err != nil {
 		return nil, err
 	}
+	change := service.record(actor, ActionCreate, nil, todoEntity)
+	service.undoStack.Push(actor.ID, &Operation{Action: ActionCreate, Changes: []TodoChange{change}})
 
 	return ConvertTodoEntitytoDTO(todoEntity), nil
 }
 
-func (service *Service) GetTodoService(id string) (*TodoDTO, error) {
-	todoEntity, err := service.repository.GetTodoRepository(id)
+func (service *Service) GetTodoService(actor *Actor, id string) (*TodoDTO, error) {
+	todoEntity, err := service.getActiveTodo(actor, id)
 	if err != nil {
 		return nil, err
 	}
@@ -75,8 +380,30 @@ func (service *Service) GetTodoService(id string) (*TodoDTO, error) {
 	return ConvertTodoEntitytoDTO(todoEntity), nil
 }
 
-func (service *Service) GetTodoListService(page int, size int) (*TodoListDTO, error) {
-	todoListEntity, totalElements, err := service.repository.GetTodoListRepository(page, size)
+// getActiveTodo hides to-dos in the trash from everything but the trash
+// endpoints.
+func (service *Service) getActiveTodo(actor *Actor, id string) (*TodoEntity, error) {
+	todoEntity, err := service.repository.GetTodoRepository(actor.OwnerID, id)
+	if err == mongo.ErrNoDocuments {
+		return nil, fiber.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if todoEntity.DeletedAt != nil {
+		return nil, fiber.ErrNotFound
+	}
+
+	return todoEntity, nil
+}
+
+func (service *Service) GetTodoListService(actor *Actor, filterDTO *TodoFilterDTO, page int, size int) (*TodoListDTO, error) {
+	filterModel, err := ConvertTodoFilterDTOtoModel(filterDTO, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	todoListEntity, totalElements, err := service.repository.GetTodoListRepository(actor.OwnerID, filterModel, page, size)
 	if err != nil {
 		return nil, err
 	}
@@ -93,83 +420,1767 @@ func (service *Service) GetTodoListService(page int, size int) (*TodoListDTO, er
 	return todoListDTO, nil
 }
 
-func (service *Service) UpdateTodoService(id string, todoDTO *TodoDTO) (*TodoDTO, error) {
+func (service *Service) UpdateTodoService(actor *Actor, id string, todoDTO *TodoDTO) (*TodoDTO, error) {
+	if !ValidateTodoDTO(todoDTO) {
+		return nil, fiber.ErrBadRequest
+	}
+
+	currentEntity, err := service.getActiveTodo(actor, id)
+	if err != nil {
+		return nil, err
+	}
+
 	todoModel := ConvertTodoDTOtoModel(todoDTO)
-	todoModel.UpdatedAt = time.Now().Round(time.Minute).UTC()
-	todoEntity, err := service.repository.UpdateTodoRepository(id, todoModel)
+	todoModel.UpdatedAt = TodoTime()
+	todoModel.SeriesID = currentEntity.SeriesID
+	todoModel.Occurrence = currentEntity.Occurrence
+	todoModel.NextID = currentEntity.NextID
+	todoModel.CompletedAt = currentEntity.CompletedAt
+	if todoModel.Recurrence != "" && todoModel.SeriesID == "" {
+		todoModel.SeriesID = id
+		todoModel.Occurrence = 1
+	}
+
+	completed := todoModel.Done && !currentEntity.Done
+	if completed {
+		completedAt := time.Now().UTC()
+		todoModel.CompletedAt = &completedAt
+	}
+	if !todoModel.Done {
+		todoModel.CompletedAt = nil
+	}
+	todoModel.Reminders = ScheduleReminders(todoModel.Reminders, todoModel.DueAt, currentEntity.Reminders)
+
+	// The next occurrence is only spawned once, however often the to-do is
+	// completed again, unless it has been deleted for good since.
+	spawn := completed && todoModel.Recurrence != ""
+	if spawn && currentEntity.NextID != "" {
+		_, err := service.repository.GetTodoRepository(currentEntity.OwnerID, currentEntity.NextID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		spawn = err == mongo.ErrNoDocuments
+	}
+	if spawn {
+		todoModel.NextID = uuid.New().String()
+	}
+
+	todoEntity, err := service.repository.UpdateTodoRepository(actor.OwnerID, id, todoModel)
 	if err != nil {
 		return nil, err
 	}
+	change := service.record(actor, ActionUpdate, currentEntity, todoEntity)
+	operation := Operation{Action: ActionUpdate, Changes: []TodoChange{change}}
+
+	// The to-do is already completed, so a failed spawn is only logged; it
+	// is retried the next time the to-do is completed.
+	if spawn {
+		nextEntity, err := service.spawnNextOccurrence(actor, todoEntity)
+		if err != nil {
+			log.Printf("spawning the next occurrence of to-do %s: %v", todoEntity.ID, err)
+		}
+		if nextEntity != nil {
+			operation.Changes = append(operation.Changes, TodoChange{After: nextEntity})
+		}
+	}
+	service.undoStack.Push(actor.ID, &operation)
 
 	return ConvertTodoEntitytoDTO(todoEntity), nil
 }
 
-func (service *Service) UpdateTodoSortService(currentId string, backId string, frontId string) (*TodoDTO, error) {
+// spawnNextOccurrence creates the next instance of a recurring to-do with
+// the id recorded in its NextID, so spawning it again creates nothing. The
+// rule is evaluated in the timezone of the series and the due time stored
+// in UTC. The completed instance is left untouched so the series keeps its
+// history.
+func (service *Service) spawnNextOccurrence(actor *Actor, todoEntity *TodoEntity) (*TodoEntity, error) {
+	rule, err := ParseRecurrenceRule(todoEntity.Recurrence)
+	if err != nil {
+		return nil, err
+	}
+	location := time.UTC
+	if todoEntity.Timezone != "" {
+		location, err = time.LoadLocation(todoEntity.Timezone)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	var backIndex float64
-	var frontIndex float64
+	current := time.Now()
+	if todoEntity.DueAt != nil {
+		current = *todoEntity.DueAt
+	}
 
-	if backId != "" {
-		todoEntityBack, _ := service.repository.GetTodoRepository(backId)
-		backIndex = todoEntityBack.Index
-	} else {
-		todoEntityFront, _ := service.repository.GetTodoRepository(frontId)
-		frontIndex = todoEntityFront.Index
-		backIndex = frontIndex + float64(1)
+	nextDueAt, ok := rule.Next(current.In(location), todoEntity.Occurrence)
+	if !ok {
+		return nil, nil
+	}
+	nextDueAt = nextDueAt.UTC()
+
+	now := TodoTime()
+	todoModel := TodoModel{
+		ID:         todoEntity.NextID,
+		OwnerID:    todoEntity.OwnerID,
+		Content:    todoEntity.Content,
+		DueAt:      &nextDueAt,
+		Recurrence: todoEntity.Recurrence,
+		Timezone:   todoEntity.Timezone,
+		SeriesID:   todoEntity.SeriesID,
+		Occurrence: todoEntity.Occurrence + 1,
+		Priority:   todoEntity.Priority,
+		Tags:       todoEntity.Tags,
+		Notes:      todoEntity.Notes,
+		CratedAt:   now,
+		UpdatedAt:  now,
+	}
+	todoModel.Index, todoModel.Rank = service.nextPosition(todoEntity.OwnerID)
+	if todoEntity.StartAt != nil && todoEntity.DueAt != nil {
+		nextStartAt := nextDueAt.Add(todoEntity.StartAt.Sub(*todoEntity.DueAt))
+		todoModel.StartAt = &nextStartAt
+	}
+	for _, reminderEntity := range todoEntity.Reminders {
+		if reminderEntity.At == nil {
+			todoModel.Reminders = append(todoModel.Reminders, ReminderModel{
+				ID:        uuid.New().String(),
+				BeforeDue: time.Duration(reminderEntity.BeforeDue) * time.Second,
+			})
+		}
+	}
+	todoModel.Reminders = ScheduleReminders(todoModel.Reminders, todoModel.DueAt, nil)
+
+	nextEntity, err := service.repository.AddTodoRepository(&todoModel)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
+	service.record(actor, ActionCreate, nil, nextEntity)
 
+	return nextEntity, nil
+}
+
+// nextPosition puts a new to-do on top of the list. The index is still
+// kept for clients that sort by it; the list is ordered by rank.
+func (service *Service) nextPosition(ownerId string) (float64, string) {
+	todoListEntitiy, _, _ := service.repository.GetTodoListRepository(ownerId, nil, 0, 1)
+
+	index := float64(0)
+	rank := RankBetween("", "")
+	if todoListEntitiy != nil && len(todoListEntitiy.TodoList) > 0 {
+		index = todoListEntitiy.TodoList[0].Index + float64(10)
+		rank = RankBetween(todoListEntitiy.TodoList[0].Rank, "")
+	}
+	return index, rank
+}
+
+func (service *Service) UpdateTodoSortService(actor *Actor, currentId string, backId string, frontId string) (*TodoDTO, error) {
+	currentEntity, err := service.getActiveTodo(actor, currentId)
+	if err != nil {
+		return nil, err
+	}
+
+	if backId == "" && frontId == "" {
+		return nil, fiber.ErrBadRequest
+	}
+
+	// Neighbours are looked up like the moved to-do, so ids of other lists
+	// and of the trash are not found.
+	var todoEntityBack *TodoEntity
+	var todoEntityFront *TodoEntity
+	if backId != "" {
+		todoEntityBack, err = service.getActiveTodo(actor, backId)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if frontId != "" {
-		todoEntityFront, _ := service.repository.GetTodoRepository(frontId)
-		frontIndex = todoEntityFront.Index
-	} else {
-		todoEntityBack, _ := service.repository.GetTodoRepository(backId)
-		backIndex = todoEntityBack.Index
-		frontIndex = backIndex - float64(1)
+		todoEntityFront, err = service.getActiveTodo(actor, frontId)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	newIndex := (frontIndex + backIndex) / float64(2)
+	// The rank orders the list. The float midpoint is only kept for
+	// clients that still read the index and can run out of precision.
+	var newIndex float64
+	var newRank string
+	switch {
+	case todoEntityBack == nil:
+		newIndex = todoEntityFront.Index + 0.5
+		newRank = RankBetween(todoEntityFront.Rank, "")
+	case todoEntityFront == nil:
+		newIndex = todoEntityBack.Index - 0.5
+		newRank = RankBetween("", todoEntityBack.Rank)
+	default:
+		if todoEntityBack.Rank == todoEntityFront.Rank {
+			if todoEntityBack, todoEntityFront, err = service.breakRankTie(actor, todoEntityBack, todoEntityFront); err != nil {
+				return nil, err
+			}
+		}
+		newIndex = (todoEntityFront.Index + todoEntityBack.Index) / float64(2)
+		newRank = RankBetween(todoEntityBack.Rank, todoEntityFront.Rank)
+	}
 
-	TodoEntity, err := service.repository.UpdateTodoSortRepository(currentId, newIndex)
+	TodoEntity, err := service.repository.UpdateTodoSortRepository(actor.OwnerID, currentId, newIndex, newRank)
 	if err != nil {
 		return nil, err
 	}
+	change := service.record(actor, ActionReorder, currentEntity, TodoEntity)
+	service.undoStack.Push(actor.ID, &Operation{Action: ActionReorder, Changes: []TodoChange{change}})
 
 	return ConvertTodoEntitytoDTO(TodoEntity), nil
 }
 
-func (service *Service) DeleteTodoService(id string) error {
-	err := service.repository.DeleteTodoRepository(id)
+// breakRankTie gives the to-dos that share the rank of both neighbours
+// distinct ranks in the order the id tie-break shows them, so there is
+// room between the neighbours. The lowest keeps the rank.
+func (service *Service) breakRankTie(actor *Actor, back *TodoEntity, front *TodoEntity) (*TodoEntity, *TodoEntity, error) {
+	tied, above, err := service.repository.GetRankTieRepository(actor.OwnerID, back.Rank)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ranks := RanksBetween(back.Rank, above, len(tied)-1)
+	for i := 0; i < len(tied)-1; i++ {
+		todoEntity, err := service.repository.UpdateTodoSortRepository(actor.OwnerID, tied[i].ID, tied[i].Index, ranks[len(ranks)-1-i])
+		if err != nil {
+			return nil, nil, err
+		}
+		switch todoEntity.ID {
+		case back.ID:
+			back = todoEntity
+		case front.ID:
+			front = todoEntity
+		}
+	}
+	return back, front, nil
+}
+
+func (service *Service) GetTagsService(actor *Actor) (*TagListDTO, error) {
+	tagEntities, err := service.repository.GetTagsRepository(actor.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+
+	tagListDTO := TagListDTO{Tags: []TagDTO{}}
+	for _, tagEntity := range tagEntities {
+		tagListDTO.Tags = append(tagListDTO.Tags, TagDTO{Name: tagEntity.Name, Count: tagEntity.Count})
+	}
+	return &tagListDTO, nil
+}
+
+func (service *Service) RenameTagService(actor *Actor, name string, tagDTO *TagDTO) (*TagDTO, error) {
+	return service.MergeTagsService(actor, &TagMergeDTO{From: []string{name}, Into: tagDTO.Name})
+}
+
+// MergeTagsService replaces every tag in From with Into on all affected
+// to-dos. Renaming a tag is a merge with a single source. Each to-do is
+// changed, recorded and undone like an update of its own; trashed to-dos
+// keep their tags.
+func (service *Service) MergeTagsService(actor *Actor, tagMergeDTO *TagMergeDTO) (*TagDTO, error) {
+	from := NormalizeTags(tagMergeDTO.From)
+	into := NormalizeTags([]string{tagMergeDTO.Into})
+	if len(from) == 0 || len(into) == 0 {
+		return nil, fiber.ErrBadRequest
+	}
+
+	affected, _, err := service.repository.GetTodoListRepository(actor.OwnerID, &TodoFilterModel{AnyTags: from}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	operation := Operation{Action: ActionUpdate}
+	for i := range affected.TodoList {
+		before, err := service.repository.MergeTagsRepository(actor.OwnerID, affected.TodoList[i].ID, from, into[0], TodoTime())
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		after, err := service.repository.GetTodoRepository(actor.OwnerID, before.ID)
+		if err != nil {
+			return nil, err
+		}
+		change := service.record(actor, ActionUpdate, before, after)
+		operation.Changes = append(operation.Changes, change)
+	}
+	if len(operation.Changes) > 0 {
+		service.undoStack.Push(actor.ID, &operation)
+	}
+
+	return &TagDTO{Name: into[0], Count: len(operation.Changes)}, nil
+}
+
+func (service *Service) DeleteTodoService(actor *Actor, id string) error {
+	currentEntity, err := service.getActiveTodo(actor, id)
+	if err != nil {
+		return err
+	}
+
+	err = service.repository.TrashTodoRepository(actor.OwnerID, id, TodoTime())
+	if err != nil {
+		return err
+	}
+
+	todoEntity, err := service.repository.GetTodoRepository(actor.OwnerID, id)
 	if err != nil {
 		return err
 	}
+	change := service.record(actor, ActionDelete, currentEntity, todoEntity)
+	service.undoStack.Push(actor.ID, &Operation{Action: ActionDelete, Changes: []TodoChange{change}})
 
 	return nil
 }
 
-func ConvertTodoDTOtoModel(todoDTO *TodoDTO) *TodoModel {
-	todoModel := TodoModel{
-		ID:      todoDTO.ID,
-		Content: todoDTO.Content,
-		Done:    todoDTO.Done,
-		Index:   todoDTO.Index,
+func (service *Service) GetTrashService(actor *Actor, page int, size int) (*TodoListDTO, error) {
+	todoListEntity, totalElements, err := service.repository.GetTodoListRepository(actor.OwnerID, &TodoFilterModel{Trash: true}, page, size)
+	if err != nil {
+		return nil, err
 	}
-	return &todoModel
+
+	pageModel := Page{
+		Number:        page,
+		Size:          size,
+		TotalElements: totalElements,
+		TotalPages:    int(math.Ceil(float64(totalElements) / float64(size))),
+	}
+
+	todoListDTO := ConvertTodoListEntitytoDTO(todoListEntity)
+	todoListDTO.Page = pageModel
+	return todoListDTO, nil
 }
 
-func ConvertTodoEntitytoDTO(todoEntity *TodoEntity) *TodoDTO {
-	todoDTO := TodoDTO{
-		ID:      todoEntity.ID,
-		Content: todoEntity.Content,
-		Done:    todoEntity.Done,
-		Index:   todoEntity.Index,
+func (service *Service) ArchiveTodoService(actor *Actor, id string) (*TodoDTO, error) {
+	archivedAt := time.Now().UTC()
+	return service.setArchived(actor, ActionArchive, id, &archivedAt)
+}
+
+func (service *Service) UnarchiveTodoService(actor *Actor, id string) (*TodoDTO, error) {
+	return service.setArchived(actor, ActionUnarchive, id, nil)
+}
+
+func (service *Service) setArchived(actor *Actor, action string, id string, archivedAt *time.Time) (*TodoDTO, error) {
+	currentEntity, err := service.getActiveTodo(actor, id)
+	if err != nil {
+		return nil, err
 	}
-	return &todoDTO
+
+	todoEntity, err := service.repository.ArchiveTodoRepository(actor.OwnerID, id, archivedAt, TodoTime())
+	if err == mongo.ErrNoDocuments {
+		return nil, fiber.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	change := service.record(actor, action, currentEntity, todoEntity)
+	service.undoStack.Push(actor.ID, &Operation{Action: action, Changes: []TodoChange{change}})
+
+	return ConvertTodoEntitytoDTO(todoEntity), nil
 }
 
-func ConvertTodoListEntitytoDTO(todoListEntity *TodoListEntity) *TodoListDTO {
-	todoListDTO := TodoListDTO{}
-	for _, v := range todoListEntity.TodoList {
-		todoListDTO.TodoList = append(todoListDTO.TodoList, *ConvertTodoEntitytoDTO(&v))
+// AutoArchiveService is run by a background worker to archive to-dos that
+// have been done for longer than the given duration.
+func (service *Service) AutoArchiveService(after time.Duration) error {
+	archived := false
+	completedBefore := time.Now().UTC().Add(-after)
+	filterModel := TodoFilterModel{Archived: &archived, CompletedBefore: &completedBefore}
+
+	todoEntities, err := service.repository.GetEveryOwnerTodosRepository(&filterModel)
+	if err != nil {
+		return err
 	}
-	return &todoListDTO
+
+	for i := range todoEntities {
+		currentEntity := &todoEntities[i]
+		archivedAt := time.Now().UTC()
+		todoEntity, err := service.repository.ArchiveTodoRepository(currentEntity.OwnerID, currentEntity.ID, &archivedAt, TodoTime())
+		if err != nil {
+			return err
+		}
+		service.record(SystemActor, ActionArchive, currentEntity, todoEntity)
+	}
+
+	return nil
+}
+
+// RestoreTodoService takes a to-do out of the trash. Its index is kept
+// while it is trashed, so it returns to its previous position.
+func (service *Service) RestoreTodoService(actor *Actor, id string) (*TodoDTO, error) {
+	currentEntity, err := service.repository.GetTodoRepository(actor.OwnerID, id)
+	if err == mongo.ErrNoDocuments {
+		return nil, fiber.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	todoEntity, err := service.repository.RestoreTodoRepository(actor.OwnerID, id, TodoTime())
+	if err == mongo.ErrNoDocuments {
+		return nil, fiber.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	change := service.record(actor, ActionRestore, currentEntity, todoEntity)
+	service.undoStack.Push(actor.ID, &Operation{Action: ActionRestore, Changes: []TodoChange{change}})
+
+	return ConvertTodoEntitytoDTO(todoEntity), nil
+}
+
+func (service *Service) PurgeTodoService(actor *Actor, id string) error {
+	purged, err := service.repository.PurgeTrashRepository(actor.OwnerID, id, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if len(purged) == 0 {
+		return fiber.ErrNotFound
+	}
+	service.recordPurge(actor, purged)
+
+	return nil
+}
+
+// PurgeExpiredTrashService is run by a background worker to permanently
+// remove to-dos that stayed in the trash longer than the retention.
+func (service *Service) PurgeExpiredTrashService(retention time.Duration) error {
+	purged, err := service.repository.PurgeExpiredTrashRepository(time.Now().UTC().Add(-retention))
+	if err != nil {
+		return err
+	}
+	service.recordPurge(SystemActor, purged)
+
+	return nil
+}
+
+func (service *Service) EmptyTrashService(actor *Actor) error {
+	purged, err := service.repository.PurgeTrashRepository(actor.OwnerID, "", time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	service.recordPurge(actor, purged)
+
+	return nil
+}
+
+func (service *Service) recordPurge(actor *Actor, purged []TodoEntity) {
+	for i := range purged {
+		service.record(actor, ActionPurge, &purged[i], nil)
+	}
+}
+
+// record appends an immutable history entry describing the change from
+// before to after. A nil before is a creation and a nil after a purge.
+// The change is already committed when it is recorded, so a failure to add
+// the entry is logged and the change is still published and returned.
+func (service *Service) record(actor *Actor, action string, before *TodoEntity, after *TodoEntity) TodoChange {
+	todoEntity := after
+	if todoEntity == nil {
+		todoEntity = before
+	}
+
+	historyEntity := HistoryEntity{
+		ID:      uuid.New().String(),
+		OwnerID: todoEntity.OwnerID,
+		TodoID:  todoEntity.ID,
+		Actor:   actor.ID,
+		Action:  action,
+		At:      time.Now().UTC(),
+		Changes: DiffTodoEntities(before, after),
+	}
+
+	if err := service.repository.AddHistoryRepository(&historyEntity); err != nil {
+		log.Printf("recording %s of to-do %s: %v", action, todoEntity.ID, err)
+	}
+	service.publish(action, before, after)
+
+	return TodoChange{Before: before, After: after}
+}
+
+// publish turns a change into an event for the to-dos that are visible in
+// a list. Changes inside the trash are not published.
+func (service *Service) publish(action string, before *TodoEntity, after *TodoEntity) {
+	if service.changeStream != "" {
+		return
+	}
+
+	visibleBefore := before != nil && before.DeletedAt == nil
+	visibleAfter := after != nil && after.DeletedAt == nil
+
+	event := TodoEvent{At: time.Now().UTC()}
+	switch {
+	case !visibleBefore && !visibleAfter:
+		return
+	case !visibleAfter:
+		event.Type = EventDeleted
+		event.OwnerID = before.OwnerID
+		event.Todo = ConvertTodoEntitytoDTO(before)
+	case !visibleBefore:
+		event.Type = EventCreated
+	case action == ActionReorder:
+		event.Type = EventReordered
+	default:
+		event.Type = EventUpdated
+		event.Completed = !before.Done && after.Done
+	}
+	if visibleAfter {
+		event.OwnerID = after.OwnerID
+		event.Todo = ConvertTodoEntitytoDTO(after)
+	}
+
+	service.publishEvent(event, "")
+}
+
+// publishEvent sends an event to the subscribers and the webhooks. A
+// non-empty key identifies the change, so the deliveries of a change
+// published by several instances are only queued once.
+func (service *Service) publishEvent(event TodoEvent, key string) {
+	event = service.events.Publish(event)
+	if err := service.enqueueWebhooks(&event, key); err != nil {
+		log.Printf("queueing webhooks for %s event %d: %v", event.Type, event.ID, err)
+	}
+}
+
+// enqueueWebhooks queues a delivery of the event for every webhook of the
+// owner subscribed to it. WebhookDispatcher sends them.
+func (service *Service) enqueueWebhooks(event *TodoEvent, key string) error {
+	types := []string{event.Type}
+	if event.Completed {
+		types = append(types, EventCompleted)
+	}
+
+	webhookEntities, err := service.repository.GetWebhooksRepository(event.OwnerID, types...)
+	if err != nil {
+		return err
+	}
+
+	deliveryEntities := []WebhookDeliveryEntity{}
+	for _, webhookEntity := range webhookEntities {
+		for _, eventType := range types {
+			if !webhookSubscribed(&webhookEntity, eventType) {
+				continue
+			}
+
+			deliveryId := uuid.New().String()
+			if key != "" {
+				deliveryId = uuid.NewSHA1(uuid.NameSpaceURL, []byte(key+" "+webhookEntity.ID+" "+eventType)).String()
+			}
+			payload, err := json.Marshal(WebhookPayload{
+				DeliveryID: deliveryId,
+				Event:      eventType,
+				OwnerID:    event.OwnerID,
+				Todo:       event.Todo,
+				At:         event.At,
+			})
+			if err != nil {
+				return err
+			}
+
+			deliveryEntities = append(deliveryEntities, WebhookDeliveryEntity{
+				ID:            deliveryId,
+				OwnerID:       event.OwnerID,
+				WebhookID:     webhookEntity.ID,
+				Event:         eventType,
+				Payload:       string(payload),
+				Status:        WebhookDeliveryPending,
+				NextAttemptAt: event.At,
+				CreatedAt:     event.At,
+			})
+		}
+	}
+
+	return service.repository.AddWebhookDeliveriesRepository(deliveryEntities)
+}
+
+// SubscribeTodoEventsService streams the changes of the list the actor
+// works on, starting after lastEventID.
+func (service *Service) SubscribeTodoEventsService(actor *Actor, lastEventID uint64) *EventSubscription {
+	return service.events.Subscribe(lastEventID, func(event *TodoEvent) bool {
+		return event.OwnerID == actor.OwnerID
+	})
+}
+
+// UndoService reverts the most recent operations of the actor, newest
+// first, and makes them available to RedoService.
+func (service *Service) UndoService(actor *Actor, steps int) (*UndoDTO, error) {
+	return service.replay(actor, steps, ActionUndo, service.undoStack.PopUndo, service.undoStack.PushUndo, service.undoStack.PushRedo)
+}
+
+func (service *Service) RedoService(actor *Actor, steps int) (*UndoDTO, error) {
+	return service.replay(actor, steps, ActionRedo, service.undoStack.PopRedo, service.undoStack.PushRedo, service.undoStack.PushUndo)
+}
+
+// replay reverts the operations popped from one stack and pushes them onto
+// the other. An operation that cannot be reverted is put back, so it can
+// be tried again once the conflict is resolved, unless the actor may no
+// longer edit its list.
+func (service *Service) replay(actor *Actor, steps int, action string, pop func(string) *Operation, putBack func(string, *Operation), push func(string, *Operation)) (*UndoDTO, error) {
+	if steps < 1 {
+		return nil, fiber.ErrBadRequest
+	}
+
+	undoDTO := UndoDTO{TodoList: []TodoDTO{}}
+	for i := 0; i < steps; i++ {
+		operation := pop(actor.ID)
+		if operation == nil {
+			if i == 0 {
+				return nil, fiber.ErrNotFound
+			}
+			break
+		}
+
+		reverted, err := service.revert(actor, action, operation)
+		if err != nil {
+			if err != fiber.ErrForbidden {
+				putBack(actor.ID, operation)
+			}
+			return nil, err
+		}
+		push(actor.ID, reverted)
+
+		undoDTO.Operations = append(undoDTO.Operations, operation.Action)
+		for _, change := range reverted.Changes {
+			if change.After != nil && change.After.DeletedAt == nil {
+				undoDTO.TodoList = append(undoDTO.TodoList, *ConvertTodoEntitytoDTO(change.After))
+			}
+		}
+	}
+
+	return &undoDTO, nil
+}
+
+// revert puts every to-do of the operation back into its Before state and
+// returns the operation that reverts it again. Nothing is written when any
+// to-do has been changed since the operation, which is reported as a
+// conflict, or when the actor is no longer an editor of its list. When a
+// write fails part way, the to-dos already written are rolled back.
+func (service *Service) revert(actor *Actor, action string, operation *Operation) (*Operation, error) {
+	currentEntities := []*TodoEntity{}
+	for _, change := range operation.Changes {
+		id := change.Before
+		if id == nil {
+			id = change.After
+		}
+
+		// The operation may come from a list shared with the actor, whose
+		// share can have been revoked since.
+		if err := service.AuthorizeService(&Actor{ID: actor.ID, OwnerID: id.OwnerID}, RoleEditor); err != nil {
+			return nil, err
+		}
+
+		currentEntity, err := service.repository.GetTodoRepository(id.OwnerID, id.ID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		if err == mongo.ErrNoDocuments {
+			currentEntity = nil
+		}
+		if !SameTodoVersion(currentEntity, change.After) {
+			return nil, fiber.ErrConflict
+		}
+		currentEntities = append(currentEntities, currentEntity)
+	}
+
+	reverted := Operation{Action: operation.Action}
+	for i := len(operation.Changes) - 1; i >= 0; i-- {
+		change := operation.Changes[i]
+		currentEntity := currentEntities[i]
+
+		var todoEntity *TodoEntity
+		var err error
+		switch {
+		case change.Before == nil:
+			err = service.repository.DeleteTodoVersionRepository(currentEntity.OwnerID, currentEntity.ID, currentEntity.Version)
+		case currentEntity == nil:
+			todoEntity, err = service.repository.InsertTodoEntityRepository(change.Before)
+		default:
+			todoEntity, err = service.repository.ReplaceTodoRepository(change.Before, currentEntity.Version)
+		}
+		if err == mongo.ErrNoDocuments {
+			err = fiber.ErrConflict
+		}
+		if err != nil {
+			if rollbackErr := service.rollback(actor, action, operation, &reverted); rollbackErr != nil {
+				return nil, rollbackErr
+			}
+			return nil, err
+		}
+		reverted.Changes = append(reverted.Changes, service.record(actor, action, currentEntity, todoEntity))
+	}
+
+	return &reverted, nil
+}
+
+// rollback writes back the to-dos a failed revert already wrote, newest
+// first, and points the operation at the restored versions so it can be
+// reverted again.
+func (service *Service) rollback(actor *Actor, action string, operation *Operation, reverted *Operation) error {
+	for k := len(reverted.Changes) - 1; k >= 0; k-- {
+		written := reverted.Changes[k]
+
+		var restored *TodoEntity
+		var err error
+		switch {
+		case written.After == nil:
+			restored, err = service.repository.InsertTodoEntityRepository(written.Before)
+		case written.Before == nil:
+			err = service.repository.DeleteTodoVersionRepository(written.After.OwnerID, written.After.ID, written.After.Version)
+		default:
+			restored, err = service.repository.ReplaceTodoRepository(written.Before, written.After.Version)
+		}
+		if err == mongo.ErrNoDocuments {
+			err = fiber.ErrConflict
+		}
+		if err != nil {
+			return err
+		}
+
+		operation.Changes[len(operation.Changes)-1-k].After = restored
+		service.record(actor, action, written.After, restored)
+	}
+	return nil
+}
+
+func SameTodoVersion(todoEntity *TodoEntity, expected *TodoEntity) bool {
+	if todoEntity == nil || expected == nil {
+		return todoEntity == nil && expected == nil
+	}
+	return todoEntity.Version == expected.Version
+}
+
+// AuthorizeService checks that the actor holds at least the given role on
+// the list it works on. The owner of a list, and anyone on the anonymous
+// list, holds every role.
+func (service *Service) AuthorizeService(actor *Actor, role string) error {
+	if actor.ID == ReservedOwnerID || actor.OwnerID == ReservedOwnerID {
+		return fiber.ErrForbidden
+	}
+	if actor.OwnerID == "" || actor.OwnerID == actor.ID {
+		return nil
+	}
+
+	shareEntity, err := service.repository.GetShareRepository(actor.OwnerID, actor.ID)
+	if err == mongo.ErrNoDocuments {
+		return fiber.ErrForbidden
+	}
+	if err != nil {
+		return err
+	}
+	if RoleRanks[shareEntity.Role] < RoleRanks[role] {
+		return fiber.ErrForbidden
+	}
+
+	return nil
+}
+
+// ShareListService invites a user to the list of the actor, or changes the
+// role of an existing collaborator. The anonymous list cannot be shared.
+func (service *Service) ShareListService(actor *Actor, shareDTO *ShareDTO) (*ShareDTO, error) {
+	if actor.OwnerID == "" || shareDTO.UserID == "" || shareDTO.UserID == actor.OwnerID {
+		return nil, fiber.ErrBadRequest
+	}
+	if _, ok := RoleRanks[shareDTO.Role]; !ok {
+		return nil, fiber.ErrBadRequest
+	}
+
+	shareEntity, err := service.repository.PutShareRepository(&ShareEntity{
+		ID:        uuid.New().String(),
+		OwnerID:   actor.OwnerID,
+		UserID:    shareDTO.UserID,
+		Role:      shareDTO.Role,
+		InvitedBy: actor.ID,
+		CreatedAt: time.Now().Round(time.Minute).UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ConvertShareEntitytoDTO(shareEntity), nil
+}
+
+func (service *Service) GetSharesService(actor *Actor) (*ShareListDTO, error) {
+	shareEntities, err := service.repository.GetSharesRepository(actor.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertShareEntitiestoDTO(shareEntities), nil
+}
+
+// GetSharedListsService returns the lists other users shared with the
+// actor.
+func (service *Service) GetSharedListsService(actor *Actor) (*ShareListDTO, error) {
+	shareEntities, err := service.repository.GetSharedWithRepository(actor.ID)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertShareEntitiestoDTO(shareEntities), nil
+}
+
+// RevokeShareService removes a collaborator from the list of the actor.
+// Owners can remove anyone; other collaborators can only leave the list.
+func (service *Service) RevokeShareService(actor *Actor, userId string) error {
+	if userId != actor.ID {
+		if err := service.AuthorizeService(actor, RoleOwner); err != nil {
+			return err
+		}
+	}
+
+	err := service.repository.DeleteShareRepository(actor.OwnerID, userId)
+	if err == mongo.ErrNoDocuments {
+		return fiber.ErrNotFound
+	}
+	return err
+}
+
+// CreateWebhookService subscribes a URL to events of the list of the
+// actor. The secret is only returned here; a random one is generated when
+// none is given.
+func (service *Service) CreateWebhookService(actor *Actor, webhookDTO *WebhookDTO) (*WebhookDTO, error) {
+	if !ValidWebhookURL(webhookDTO.URL, service.webhookHosts) || len(webhookDTO.Events) == 0 {
+		return nil, fiber.ErrBadRequest
+	}
+	for _, eventType := range webhookDTO.Events {
+		if !WebhookEventTypes[eventType] {
+			return nil, fiber.ErrBadRequest
+		}
+	}
+	if webhookDTO.Secret == "" {
+		webhookDTO.Secret = NewWebhookSecret()
+	}
+
+	webhookEntity := WebhookEntity{
+		ID:        uuid.New().String(),
+		OwnerID:   actor.OwnerID,
+		URL:       webhookDTO.URL,
+		Events:    webhookDTO.Events,
+		Secret:    webhookDTO.Secret,
+		CreatedAt: time.Now().Round(time.Minute).UTC(),
+	}
+	if err := service.repository.AddWebhookRepository(&webhookEntity); err != nil {
+		return nil, err
+	}
+
+	returnedData := ConvertWebhookEntitytoDTO(&webhookEntity)
+	returnedData.Secret = webhookEntity.Secret
+	return returnedData, nil
+}
+
+func (service *Service) GetWebhooksService(actor *Actor) (*WebhookListDTO, error) {
+	webhookEntities, err := service.repository.GetWebhooksRepository(actor.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+
+	webhookListDTO := WebhookListDTO{Webhooks: []WebhookDTO{}}
+	for i := range webhookEntities {
+		webhookListDTO.Webhooks = append(webhookListDTO.Webhooks, *ConvertWebhookEntitytoDTO(&webhookEntities[i]))
+	}
+	return &webhookListDTO, nil
+}
+
+// DeleteWebhookService removes a webhook. Its delivery log is kept and
+// deliveries that are still pending end up in the dead letters.
+func (service *Service) DeleteWebhookService(actor *Actor, id string) error {
+	err := service.repository.DeleteWebhookRepository(actor.OwnerID, id)
+	if err == mongo.ErrNoDocuments {
+		return fiber.ErrNotFound
+	}
+	return err
+}
+
+func (service *Service) GetWebhookDeliveriesService(actor *Actor, webhookId string, page int, size int) (*WebhookDeliveryListDTO, error) {
+	if _, err := service.repository.GetWebhookRepository(actor.OwnerID, webhookId); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fiber.ErrNotFound
+		}
+		return nil, err
+	}
+	return service.getWebhookDeliveries(actor, webhookId, "", page, size)
+}
+
+// GetDeadLettersService returns the deliveries that failed every attempt.
+func (service *Service) GetDeadLettersService(actor *Actor, page int, size int) (*WebhookDeliveryListDTO, error) {
+	return service.getWebhookDeliveries(actor, "", WebhookDeliveryDead, page, size)
+}
+
+func (service *Service) getWebhookDeliveries(actor *Actor, webhookId string, status string, page int, size int) (*WebhookDeliveryListDTO, error) {
+	deliveryEntities, totalElements, err := service.repository.GetWebhookDeliveriesRepository(actor.OwnerID, webhookId, status, page, size)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveryListDTO := WebhookDeliveryListDTO{
+		Deliveries: []WebhookDeliveryDTO{},
+		Page: Page{
+			Number:        page,
+			Size:          size,
+			TotalElements: totalElements,
+			TotalPages:    int(math.Ceil(float64(totalElements) / float64(size))),
+		},
+	}
+	for i := range deliveryEntities {
+		deliveryListDTO.Deliveries = append(deliveryListDTO.Deliveries, *ConvertWebhookDeliveryEntitytoDTO(&deliveryEntities[i]))
+	}
+	return &deliveryListDTO, nil
+}
+
+// RetryWebhookDeliveryService queues a dead letter again.
+func (service *Service) RetryWebhookDeliveryService(actor *Actor, id string) (*WebhookDeliveryDTO, error) {
+	deliveryEntity, err := service.repository.RetryWebhookDeliveryRepository(actor.OwnerID, id, time.Now().UTC())
+	if err == mongo.ErrNoDocuments {
+		return nil, fiber.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ConvertWebhookDeliveryEntitytoDTO(deliveryEntity), nil
+}
+
+// GetSyncService returns the to-dos and tombstones written after the since
+// token in change sequence order, at most limit of them. Without a token
+// it returns the whole list, trash included.
+func (service *Service) GetSyncService(actor *Actor, since string, limit int) (*SyncDTO, error) {
+	sinceSeq := int64(0)
+	if since != "" {
+		var err error
+		sinceSeq, err = strconv.ParseInt(since, 10, 64)
+		if err != nil || sinceSeq < 0 {
+			return nil, fiber.ErrBadRequest
+		}
+	}
+	if since == "" {
+		limit = 0
+	}
+
+	// The watermark is read first, so changes written during the sync are
+	// returned again by the next one rather than missed. Changes past it
+	// wait for the writes before them.
+	watermark, err := service.repository.SyncWatermarkRepository()
+	if err != nil {
+		return nil, err
+	}
+
+	fetch := func(limit int) ([]syncItem, error) {
+		todoEntities, err := service.repository.GetTodoChangesRepository(actor.OwnerID, sinceSeq, limit)
+		if err != nil {
+			return nil, err
+		}
+		tombstoneEntities := []TombstoneEntity{}
+		if sinceSeq > 0 {
+			tombstoneEntities, err = service.repository.GetTombstonesRepository(actor.OwnerID, sinceSeq, limit)
+			if err != nil {
+				return nil, err
+			}
+		}
+		items := syncItems(todoEntities, tombstoneEntities)
+		for i, item := range items {
+			if item.seq > watermark {
+				return items[:i], nil
+			}
+		}
+		return items, nil
+	}
+
+	fetchLimit := 0
+	if limit != 0 {
+		fetchLimit = limit + 1
+	}
+	items, err := fetch(fetchLimit)
+	if err != nil {
+		return nil, err
+	}
+	page, complete := cutSyncItems(items, limit)
+	if !complete && len(page) == len(items) {
+		// A single write changed more to-dos than fit on a page.
+		if items, err = fetch(0); err != nil {
+			return nil, err
+		}
+		page, complete = cutSyncItems(items, len(page))
+	}
+
+	syncDTO := SyncDTO{Todos: []TodoDTO{}, Deleted: []TombstoneDTO{}, HasMore: !complete}
+	token := watermark
+	if sinceSeq > token {
+		token = sinceSeq
+	}
+	for _, item := range page {
+		if item.todo != nil {
+			syncDTO.Todos = append(syncDTO.Todos, *ConvertTodoEntitytoDTO(item.todo))
+		} else {
+			syncDTO.Deleted = append(syncDTO.Deleted, TombstoneDTO{ID: item.tombstone.ID, DeletedAt: item.tombstone.DeletedAt})
+		}
+		if !complete || item.seq > token {
+			token = item.seq
+		}
+	}
+	syncDTO.Token = strconv.FormatInt(token, 10)
+	return &syncDTO, nil
+}
+
+// PushSyncService applies the offline changes of a client in order. With
+// last-writer-wins a change older than the stored to-do is refused; with
+// the field merge only the fields changed on the server since the change
+// are kept. Every change gets a result; invalid ones are rejected without
+// failing the batch. A new to-do whose id is already taken is created with
+// a new id, returned in the todo of its result.
+func (service *Service) PushSyncService(actor *Actor, syncPushDTO *SyncPushDTO) (*SyncPushResultDTO, error) {
+	if syncPushDTO.Strategy == "" {
+		syncPushDTO.Strategy = SyncLastWriterWins
+	}
+	if syncPushDTO.Strategy != SyncLastWriterWins && syncPushDTO.Strategy != SyncFieldMerge {
+		return nil, fiber.ErrBadRequest
+	}
+
+	syncPushResultDTO := SyncPushResultDTO{Results: []SyncResultDTO{}}
+	for i := range syncPushDTO.Changes {
+		result, err := service.applySyncChange(actor, syncPushDTO.Strategy, &syncPushDTO.Changes[i])
+		if fiberError, ok := err.(*fiber.Error); ok {
+			result = &SyncResultDTO{ID: syncPushDTO.Changes[i].ID, Status: SyncRejected, Error: fiberError.Message}
+		} else if err != nil {
+			return nil, err
+		}
+		syncPushResultDTO.Results = append(syncPushResultDTO.Results, *result)
+	}
+	return &syncPushResultDTO, nil
+}
+
+func (service *Service) applySyncChange(actor *Actor, strategy string, changeDTO *SyncChangeDTO) (*SyncResultDTO, error) {
+	if _, err := uuid.Parse(changeDTO.ID); err != nil {
+		return nil, fiber.ErrBadRequest
+	}
+	if !changeDTO.Deleted && changeDTO.Todo == nil {
+		return nil, fiber.ErrBadRequest
+	}
+
+	currentEntity, err := service.repository.GetTodoRepository(actor.OwnerID, changeDTO.ID)
+	if err == mongo.ErrNoDocuments {
+		currentEntity = nil
+	} else if err != nil {
+		return nil, err
+	}
+	result := SyncResultDTO{ID: changeDTO.ID, Status: SyncApplied}
+
+	switch {
+	case currentEntity == nil && changeDTO.Deleted:
+		return &result, nil
+
+	case currentEntity == nil:
+		result.Todo, err = service.createTodo(actor, changeDTO.ID, changeDTO.Todo)
+		if mongo.IsDuplicateKeyError(err) {
+			// The id is taken outside the list; the to-do gets a new one and
+			// the client maps its id to the one in result.Todo.
+			result.Todo, err = service.createTodo(actor, uuid.New().String(), changeDTO.Todo)
+		}
+		return &result, err
+
+	case currentEntity.DeletedAt != nil:
+		if !changeDTO.Deleted {
+			result.Status = SyncConflict
+			result.Todo = ConvertTodoEntitytoDTO(currentEntity)
+		}
+		return &result, nil
+
+	case currentEntity.UpdatedAt.After(changeDTO.UpdatedAt) && (changeDTO.Deleted || strategy == SyncLastWriterWins):
+		result.Status = SyncConflict
+		result.Todo = ConvertTodoEntitytoDTO(currentEntity)
+		return &result, nil
+
+	case changeDTO.Deleted:
+		return &result, service.DeleteTodoService(actor, changeDTO.ID)
+
+	case strategy == SyncLastWriterWins:
+		result.Todo, err = service.UpdateTodoService(actor, changeDTO.ID, changeDTO.Todo)
+		return &result, err
+	}
+
+	fields := changeDTO.Fields
+	if len(fields) == 0 {
+		for field := range SyncFields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+	}
+	serverFields, err := service.changedFieldsSince(actor, changeDTO.ID, changeDTO.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	mergedDTO := ConvertTodoEntitytoDTO(currentEntity)
+	for _, field := range fields {
+		historyField, ok := SyncFields[field]
+		if !ok {
+			return nil, fiber.ErrBadRequest
+		}
+		if serverFields[historyField] {
+			result.Conflicts = append(result.Conflicts, field)
+			continue
+		}
+		MergeTodoField(mergedDTO, changeDTO.Todo, field)
+	}
+	if len(result.Conflicts) > 0 {
+		result.Status = SyncMerged
+	}
+
+	result.Todo, err = service.UpdateTodoService(actor, changeDTO.ID, mergedDTO)
+	return &result, err
+}
+
+// changedFieldsSince returns the fields of a to-do the history recorded a
+// change of after the given time.
+func (service *Service) changedFieldsSince(actor *Actor, id string, since time.Time) (map[string]bool, error) {
+	historyEntities, _, err := service.repository.GetHistoryRepository(&AuditFilterModel{OwnerID: actor.OwnerID, TodoID: id, From: &since}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]bool{}
+	for _, historyEntity := range historyEntities {
+		for _, change := range historyEntity.Changes {
+			fields[change.Field] = true
+		}
+	}
+	return fields, nil
+}
+
+// ExportTodosService passes the to-dos of the list that are not in the
+// trash to encode, in the order of the list.
+func (service *Service) ExportTodosService(actor *Actor, encode func(*TodoDTO) error) error {
+	return service.repository.ForEachTodoRepository(actor.OwnerID, &TodoFilterModel{}, func(todoEntity *TodoEntity) error {
+		return encode(ConvertTodoEntitytoDTO(todoEntity))
+	})
+}
+
+// ImportTodosService adds the rows of an imported file on top of the list,
+// keeping the order of the file: the first row gets the highest index.
+// Rows that fail are reported and skipped. A dry run only checks the rows.
+func (service *Service) ImportTodosService(actor *Actor, rows []ImportRowModel, dryRun bool) (*ImportResultDTO, error) {
+	result := ImportResultDTO{DryRun: dryRun, Rows: []ImportRowDTO{}}
+
+	room := -1
+	if service.todoQuota > 0 {
+		count, err := service.repository.CountTodosRepository(actor.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+		room = service.todoQuota - count
+	}
+
+	ids := map[string]bool{}
+	valid := []int{}
+	for i := range rows {
+		row := &rows[i]
+		if row.Error == "" {
+			row.Error = checkImportRow(row.Todo, ids)
+		}
+		if row.Error == "" && room >= 0 && len(valid) >= room {
+			row.Error = ErrQuotaExceeded.Message
+		}
+		if row.Error == "" {
+			valid = append(valid, i)
+		}
+	}
+
+	if !dryRun {
+		service.addImportedTodos(actor, rows, valid)
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		rowDTO := ImportRowDTO{Row: row.Row, Status: ImportCreated, Todo: row.Todo}
+		switch {
+		case row.Error != "":
+			rowDTO.Status = ImportFailed
+			rowDTO.Error = row.Error
+			result.Failed++
+		case dryRun:
+			rowDTO.Status = ImportValid
+			result.Valid++
+		default:
+			result.Valid++
+			result.Created++
+		}
+		result.Rows = append(result.Rows, rowDTO)
+	}
+	return &result, nil
+}
+
+// addImportedTodos places the valid rows above the top of the list. The
+// whole import is undone in one step. A row whose id is already taken, by
+// an earlier import of the same file or by another list, is added under a
+// new id, so no row tells whether an id exists elsewhere.
+func (service *Service) addImportedTodos(actor *Actor, rows []ImportRowModel, valid []int) {
+	index, rank := service.nextPosition(actor.OwnerID)
+	ranks := RanksBetween(rank, "", len(valid))
+
+	operation := Operation{Action: ActionCreate}
+	for position, i := range valid {
+		row := &rows[i]
+		id := row.Todo.ID
+		if id == "" {
+			id = uuid.New().String()
+		}
+		todoModel := NewTodoModel(actor, id, row.Todo)
+		todoModel.Index = index + float64(10*(len(valid)-1-position))
+		todoModel.Rank = ranks[len(valid)-1-position]
+		if !row.Todo.CreatedAt.IsZero() {
+			todoModel.CratedAt = row.Todo.CreatedAt.UTC()
+		}
+		if todoModel.Done {
+			completedAt := time.Now().UTC()
+			if row.Todo.CompletedAt != nil {
+				completedAt = row.Todo.CompletedAt.UTC()
+			}
+			todoModel.CompletedAt = &completedAt
+		}
+
+		todoEntity, err := service.repository.AddTodoRepository(todoModel)
+		if mongo.IsDuplicateKeyError(err) {
+			todoModel.ID = uuid.New().String()
+			todoEntity, err = service.repository.AddTodoRepository(todoModel)
+		}
+		if err != nil {
+			row.Error = err.Error()
+			continue
+		}
+		row.Todo = ConvertTodoEntitytoDTO(todoEntity)
+		operation.Changes = append(operation.Changes, service.record(actor, ActionCreate, nil, todoEntity))
+	}
+	if len(operation.Changes) > 0 {
+		service.undoStack.Push(actor.ID, &operation)
+	}
+}
+
+// checkImportRow applies the checks of PostTodoService to an imported row.
+// Ids are kept where they are free so files can be moved between lists,
+// but must be unique within the file.
+func checkImportRow(todoDTO *TodoDTO, ids map[string]bool) string {
+	if len(todoDTO.Content) < 1 {
+		return "content is required"
+	}
+	if !ValidateTodoDTO(todoDTO) {
+		return "invalid to-do"
+	}
+	if todoDTO.ID == "" {
+		return ""
+	}
+	if _, err := uuid.Parse(todoDTO.ID); err != nil {
+		return "invalid id"
+	}
+	if ids[todoDTO.ID] {
+		return "duplicate id"
+	}
+	ids[todoDTO.ID] = true
+	return ""
+}
+
+// CreateCalendarFeedService creates a secret token that reads the list as
+// an iCalendar feed with the rights of the actor. The token is only
+// returned here.
+func (service *Service) CreateCalendarFeedService(actor *Actor, tenant string) (*CalendarFeedDTO, error) {
+	token := NewWebhookSecret()
+	calendarFeedEntity := CalendarFeedEntity{
+		ID:        uuid.New().String(),
+		Hash:      HashAPIKey(token),
+		Tenant:    tenant,
+		OwnerID:   actor.OwnerID,
+		ActorID:   actor.ID,
+		CreatedAt: time.Now().Round(time.Minute).UTC(),
+	}
+	if err := service.repository.AddCalendarFeedRepository(&calendarFeedEntity); err != nil {
+		return nil, err
+	}
+
+	returnedData := ConvertCalendarFeedEntitytoDTO(&calendarFeedEntity)
+	returnedData.Token = token
+	return returnedData, nil
+}
+
+func (service *Service) GetCalendarFeedsService(actor *Actor, tenant string) (*CalendarFeedListDTO, error) {
+	calendarFeedEntities, err := service.repository.GetCalendarFeedsRepository(tenant, actor.OwnerID, actor.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	calendarFeedListDTO := CalendarFeedListDTO{Feeds: []CalendarFeedDTO{}}
+	for i := range calendarFeedEntities {
+		calendarFeedListDTO.Feeds = append(calendarFeedListDTO.Feeds, *ConvertCalendarFeedEntitytoDTO(&calendarFeedEntities[i]))
+	}
+	return &calendarFeedListDTO, nil
+}
+
+func (service *Service) DeleteCalendarFeedService(actor *Actor, tenant string, id string) error {
+	err := service.repository.DeleteCalendarFeedRepository(tenant, actor.OwnerID, actor.ID, id)
+	if err == mongo.ErrNoDocuments {
+		return fiber.ErrNotFound
+	}
+	return err
+}
+
+// CalendarFeedService resolves a feed token to the Service of its tenant
+// and its actor. Feeds of actors that lost access to the list are not
+// found.
+func (service *Service) CalendarFeedService(token string) (*Service, *Actor, error) {
+	calendarFeedEntity, err := service.repository.GetCalendarFeedByHashRepository(HashAPIKey(token))
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, fiber.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tenantService, err := service.ForTenant(calendarFeedEntity.Tenant)
+	if err != nil {
+		return nil, nil, err
+	}
+	actor := &Actor{ID: calendarFeedEntity.ActorID, OwnerID: calendarFeedEntity.OwnerID}
+	err = tenantService.AuthorizeService(actor, RoleViewer)
+	if err == fiber.ErrForbidden {
+		return nil, nil, fiber.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return tenantService, actor, nil
+}
+
+func (service *Service) GetTodoHistoryService(actor *Actor, id string, page int, size int) (*HistoryListDTO, error) {
+	return service.GetAuditService(actor, &AuditFilterDTO{TodoID: id}, page, size)
+}
+
+func (service *Service) GetAuditService(actor *Actor, auditFilterDTO *AuditFilterDTO, page int, size int) (*HistoryListDTO, error) {
+	auditFilterModel, err := ConvertAuditFilterDTOtoModel(auditFilterDTO)
+	if err != nil {
+		return nil, err
+	}
+	auditFilterModel.OwnerID = actor.OwnerID
+
+	historyEntities, totalElements, err := service.repository.GetHistoryRepository(auditFilterModel, page, size)
+	if err != nil {
+		return nil, err
+	}
+
+	historyListDTO := HistoryListDTO{
+		History: []HistoryDTO{},
+		Page: Page{
+			Number:        page,
+			Size:          size,
+			TotalElements: totalElements,
+			TotalPages:    int(math.Ceil(float64(totalElements) / float64(size))),
+		},
+	}
+	for _, historyEntity := range historyEntities {
+		historyListDTO.History = append(historyListDTO.History, *ConvertHistoryEntitytoDTO(&historyEntity))
+	}
+	return &historyListDTO, nil
+}
+
+func ConvertTodoDTOtoModel(todoDTO *TodoDTO) *TodoModel {
+	todoModel := TodoModel{
+		ID:         todoDTO.ID,
+		Content:    todoDTO.Content,
+		Done:       todoDTO.Done,
+		Index:      todoDTO.Index,
+		Rank:       todoDTO.Rank,
+		DueAt:      ToUTC(todoDTO.DueAt),
+		StartAt:    ToUTC(todoDTO.StartAt),
+		Recurrence: todoDTO.Recurrence,
+		Timezone:   todoDTO.Timezone,
+	}
+	todoModel.Priority, _ = ParsePriority(todoDTO.Priority)
+	todoModel.Tags = NormalizeTags(todoDTO.Tags)
+	todoModel.Notes = todoDTO.Notes
+	for _, reminderDTO := range todoDTO.Reminders {
+		todoModel.Reminders = append(todoModel.Reminders, *ConvertReminderDTOtoModel(&reminderDTO))
+	}
+	return &todoModel
+}
+
+// NewTodoModel prepares a new to-do of the actor's list; the caller places
+// it in the list.
+func NewTodoModel(actor *Actor, id string, todoDTO *TodoDTO) *TodoModel {
+	todoModel := ConvertTodoDTOtoModel(todoDTO)
+	todoModel.ID = id
+	todoModel.OwnerID = actor.OwnerID
+	if todoModel.Recurrence != "" {
+		todoModel.SeriesID = todoModel.ID
+		todoModel.Occurrence = 1
+	}
+	todoModel.Reminders = ScheduleReminders(todoModel.Reminders, todoModel.DueAt, nil)
+	todoModel.CratedAt = TodoTime()
+	todoModel.UpdatedAt = todoModel.CratedAt
+	return todoModel
+}
+
+// TodoTime is the time written on a to-do, at the millisecond precision
+// Mongo stores, so last-writer-wins sync can order writes of one minute.
+func TodoTime() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+func ConvertTodoEntitytoDTO(todoEntity *TodoEntity) *TodoDTO {
+	todoDTO := TodoDTO{
+		ID:          todoEntity.ID,
+		OwnerID:     todoEntity.OwnerID,
+		Content:     todoEntity.Content,
+		Done:        todoEntity.Done,
+		Index:       todoEntity.Index,
+		Rank:        todoEntity.Rank,
+		DueAt:       todoEntity.DueAt,
+		StartAt:     todoEntity.StartAt,
+		Overdue:     IsOverdue(todoEntity, time.Now()),
+		Recurrence:  todoEntity.Recurrence,
+		Timezone:    todoEntity.Timezone,
+		SeriesID:    todoEntity.SeriesID,
+		Occurrence:  todoEntity.Occurrence,
+		Priority:    PriorityName(todoEntity.Priority),
+		Tags:        todoEntity.Tags,
+		Notes:       todoEntity.Notes,
+		DeletedAt:   todoEntity.DeletedAt,
+		Archived:    todoEntity.ArchivedAt != nil,
+		ArchivedAt:  todoEntity.ArchivedAt,
+		Version:     todoEntity.Version,
+		CompletedAt: todoEntity.CompletedAt,
+		CreatedAt:   todoEntity.CratedAt,
+		UpdatedAt:   todoEntity.UpdatedAt,
+	}
+	for _, reminderEntity := range todoEntity.Reminders {
+		todoDTO.Reminders = append(todoDTO.Reminders, *ConvertReminderEntitytoDTO(&reminderEntity))
+	}
+	return &todoDTO
+}
+
+func ConvertReminderDTOtoModel(reminderDTO *ReminderDTO) *ReminderModel {
+	reminderModel := ReminderModel{
+		ID: reminderDTO.ID,
+		At: ToUTC(reminderDTO.At),
+	}
+	if reminderModel.ID == "" {
+		reminderModel.ID = uuid.New().String()
+	}
+	if reminderDTO.At == nil && reminderDTO.BeforeDue != "" {
+		reminderModel.BeforeDue, _ = time.ParseDuration(reminderDTO.BeforeDue)
+	}
+	return &reminderModel
+}
+
+func ConvertReminderEntitytoDTO(reminderEntity *ReminderEntity) *ReminderDTO {
+	fireAt := reminderEntity.FireAt
+	reminderDTO := ReminderDTO{
+		ID:     reminderEntity.ID,
+		At:     reminderEntity.At,
+		FireAt: &fireAt,
+		SentAt: reminderEntity.SentAt,
+	}
+	if reminderEntity.At == nil {
+		reminderDTO.BeforeDue = (time.Duration(reminderEntity.BeforeDue) * time.Second).String()
+	}
+	return &reminderDTO
+}
+
+// ScheduleReminders computes when each reminder fires. A reminder that
+// already fired keeps its sent state as long as its fire time is unchanged,
+// so moving the due date re-arms relative reminders.
+func ScheduleReminders(reminderModels []ReminderModel, dueAt *time.Time, currentEntities []ReminderEntity) []ReminderModel {
+	scheduled := []ReminderModel{}
+	for _, reminderModel := range reminderModels {
+		if reminderModel.At != nil {
+			reminderModel.FireAt = *reminderModel.At
+		} else if dueAt != nil {
+			reminderModel.FireAt = dueAt.Add(-reminderModel.BeforeDue)
+		} else {
+			continue
+		}
+
+		for _, currentEntity := range currentEntities {
+			if currentEntity.ID == reminderModel.ID && currentEntity.FireAt.Equal(reminderModel.FireAt) {
+				reminderModel.SentAt = currentEntity.SentAt
+			}
+		}
+		scheduled = append(scheduled, reminderModel)
+	}
+	return scheduled
+}
+
+func ConvertTodoListEntitytoDTO(todoListEntity *TodoListEntity) *TodoListDTO {
+	todoListDTO := TodoListDTO{}
+	for _, v := range todoListEntity.TodoList {
+		todoListDTO.TodoList = append(todoListDTO.TodoList, *ConvertTodoEntitytoDTO(&v))
+	}
+	return &todoListDTO
+}
+
+func ConvertShareEntitytoDTO(shareEntity *ShareEntity) *ShareDTO {
+	return &ShareDTO{
+		OwnerID:   shareEntity.OwnerID,
+		UserID:    shareEntity.UserID,
+		Role:      shareEntity.Role,
+		InvitedBy: shareEntity.InvitedBy,
+		CreatedAt: shareEntity.CreatedAt,
+	}
+}
+
+func ConvertShareEntitiestoDTO(shareEntities []ShareEntity) *ShareListDTO {
+	shareListDTO := ShareListDTO{Shares: []ShareDTO{}}
+	for i := range shareEntities {
+		shareListDTO.Shares = append(shareListDTO.Shares, *ConvertShareEntitytoDTO(&shareEntities[i]))
+	}
+	return &shareListDTO
+}
+
+func ConvertCalendarFeedEntitytoDTO(calendarFeedEntity *CalendarFeedEntity) *CalendarFeedDTO {
+	return &CalendarFeedDTO{
+		ID:        calendarFeedEntity.ID,
+		CreatedAt: calendarFeedEntity.CreatedAt,
+	}
+}
+
+// ConvertWebhookEntitytoDTO leaves out the secret.
+func ConvertWebhookEntitytoDTO(webhookEntity *WebhookEntity) *WebhookDTO {
+	return &WebhookDTO{
+		ID:        webhookEntity.ID,
+		URL:       webhookEntity.URL,
+		Events:    webhookEntity.Events,
+		CreatedAt: webhookEntity.CreatedAt,
+	}
+}
+
+func ConvertWebhookDeliveryEntitytoDTO(deliveryEntity *WebhookDeliveryEntity) *WebhookDeliveryDTO {
+	deliveryDTO := WebhookDeliveryDTO{
+		ID:             deliveryEntity.ID,
+		WebhookID:      deliveryEntity.WebhookID,
+		Event:          deliveryEntity.Event,
+		Status:         deliveryEntity.Status,
+		Attempts:       deliveryEntity.Attempts,
+		LastAttemptAt:  deliveryEntity.LastAttemptAt,
+		LastStatusCode: deliveryEntity.LastStatusCode,
+		LastError:      deliveryEntity.LastError,
+		Payload:        json.RawMessage(deliveryEntity.Payload),
+		CreatedAt:      deliveryEntity.CreatedAt,
+	}
+	if deliveryEntity.Status == WebhookDeliveryPending {
+		nextAttemptAt := deliveryEntity.NextAttemptAt
+		deliveryDTO.NextAttemptAt = &nextAttemptAt
+	}
+	return &deliveryDTO
+}
+
+func ConvertTenantEntitytoDTO(tenantEntity *TenantEntity) *TenantDTO {
+	return &TenantDTO{
+		Name:      tenantEntity.Name,
+		Isolation: tenantEntity.Isolation,
+		CreatedAt: tenantEntity.CreatedAt,
+	}
+}
+
+func ConvertHistoryEntitytoDTO(historyEntity *HistoryEntity) *HistoryDTO {
+	historyDTO := HistoryDTO{
+		ID:      historyEntity.ID,
+		TodoID:  historyEntity.TodoID,
+		Actor:   historyEntity.Actor,
+		Action:  historyEntity.Action,
+		At:      historyEntity.At,
+		Changes: []FieldChangeDTO{},
+	}
+	for _, change := range historyEntity.Changes {
+		historyDTO.Changes = append(historyDTO.Changes, FieldChangeDTO{
+			Field:  change.Field,
+			Before: change.Before,
+			After:  change.After,
+		})
+	}
+	return &historyDTO
+}
+
+func ConvertAuditFilterDTOtoModel(auditFilterDTO *AuditFilterDTO) (*AuditFilterModel, error) {
+	auditFilterModel := AuditFilterModel{
+		TodoID: auditFilterDTO.TodoID,
+		Actor:  auditFilterDTO.Actor,
+		Action: auditFilterDTO.Action,
+	}
+
+	if auditFilterDTO.From != "" {
+		from, err := time.Parse(time.RFC3339, auditFilterDTO.From)
+		if err != nil {
+			return nil, fiber.ErrBadRequest
+		}
+		auditFilterModel.From = ToUTC(&from)
+	}
+	if auditFilterDTO.To != "" {
+		to, err := time.Parse(time.RFC3339, auditFilterDTO.To)
+		if err != nil {
+			return nil, fiber.ErrBadRequest
+		}
+		auditFilterModel.To = ToUTC(&to)
+	}
+
+	return &auditFilterModel, nil
+}
+
+func ConvertTodoFilterDTOtoModel(filterDTO *TodoFilterDTO, now time.Time) (*TodoFilterModel, error) {
+	filterModel := TodoFilterModel{}
+	if filterDTO == nil {
+		return &filterModel, nil
+	}
+	filterModel.SeriesID = filterDTO.SeriesID
+	filterModel.Search = strings.TrimSpace(filterDTO.Search)
+
+	switch filterDTO.Sort {
+	case "", SortSmart:
+		filterModel.Sort = filterDTO.Sort
+	default:
+		return nil, fiber.ErrBadRequest
+	}
+
+	// Archived to-dos are hidden unless asked for, to keep active lists small.
+	switch filterDTO.Archived {
+	case "", "false":
+		archived := false
+		filterModel.Archived = &archived
+	case "true":
+		archived := true
+		filterModel.Archived = &archived
+	case "all":
+	default:
+		return nil, fiber.ErrBadRequest
+	}
+
+	filterModel.AllTags = NormalizeTags(strings.Split(filterDTO.Tags, ","))
+	filterModel.AnyTags = NormalizeTags(strings.Split(filterDTO.AnyTags, ","))
+	filterModel.NoTags = NormalizeTags(strings.Split(filterDTO.NoTags, ","))
+
+	if len(filterDTO.Priority) != 0 {
+		for _, name := range strings.Split(filterDTO.Priority, ",") {
+			priority, ok := ParsePriority(name)
+			if !ok {
+				return nil, fiber.ErrBadRequest
+			}
+			filterModel.Priorities = append(filterModel.Priorities, priority)
+		}
+	}
+
+	location := time.UTC
+	if len(filterDTO.Timezone) != 0 {
+		var err error
+		location, err = time.LoadLocation(filterDTO.Timezone)
+		if err != nil {
+			return nil, fiber.ErrBadRequest
+		}
+	}
+
+	now = now.In(location)
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+
+	switch filterDTO.Due {
+	case "":
+	case DueOverdue:
+		dueTo := now.UTC()
+		filterModel.DueTo = &dueTo
+		filterModel.NotDone = true
+	case DueToday:
+		dueFrom := startOfDay.UTC()
+		dueTo := startOfDay.AddDate(0, 0, 1).UTC()
+		filterModel.DueFrom = &dueFrom
+		filterModel.DueTo = &dueTo
+	case DueWeek:
+		// Weeks start on Monday, following ISO 8601.
+		startOfWeek := startOfDay.AddDate(0, 0, -((int(now.Weekday()) + 6) % 7))
+		dueFrom := startOfWeek.UTC()
+		dueTo := startOfWeek.AddDate(0, 0, 7).UTC()
+		filterModel.DueFrom = &dueFrom
+		filterModel.DueTo = &dueTo
+	case DueNone:
+		filterModel.NoDue = true
+	default:
+		return nil, fiber.ErrBadRequest
+	}
+
+	return &filterModel, nil
+}
+
+func ValidateTodoDTO(todoDTO *TodoDTO) bool {
+	if _, ok := ParsePriority(todoDTO.Priority); !ok {
+		return false
+	}
+	if utf8.RuneCountInString(todoDTO.Notes) > MaxNotesLength {
+		return false
+	}
+	if todoDTO.DueAt != nil && todoDTO.StartAt != nil && todoDTO.StartAt.After(*todoDTO.DueAt) {
+		return false
+	}
+	if todoDTO.Recurrence != "" {
+		if _, err := ParseRecurrenceRule(todoDTO.Recurrence); err != nil {
+			return false
+		}
+	}
+	if todoDTO.Timezone != "" {
+		if _, err := time.LoadLocation(todoDTO.Timezone); err != nil {
+			return false
+		}
+	}
+	for _, reminderDTO := range todoDTO.Reminders {
+		if reminderDTO.At != nil {
+			if reminderDTO.BeforeDue != "" {
+				return false
+			}
+			continue
+		}
+		if todoDTO.DueAt == nil {
+			return false
+		}
+		if reminderDTO.BeforeDue != "" {
+			beforeDue, err := time.ParseDuration(reminderDTO.BeforeDue)
+			if err != nil || beforeDue < 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// NormalizeTags lower-cases and trims tags, dropping empty and duplicate ones.
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// ParsePriority accepts a priority name; an empty name means no priority.
+func ParsePriority(name string) (int, bool) {
+	if name == "" {
+		return PriorityNone, true
+	}
+	for priority, priorityName := range PriorityNames {
+		if strings.EqualFold(strings.TrimSpace(name), priorityName) {
+			return priority, true
+		}
+	}
+	return PriorityNone, false
+}
+
+func PriorityName(priority int) string {
+	if priority < 0 || priority >= len(PriorityNames) {
+		return PriorityNames[PriorityNone]
+	}
+	return PriorityNames[priority]
+}
+
+func IsOverdue(todoEntity *TodoEntity, now time.Time) bool {
+	return todoEntity.DueAt != nil && !todoEntity.Done && todoEntity.DueAt.Before(now)
+}
+
+func ToUTC(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
 }
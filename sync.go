@@ -0,0 +1,98 @@
+package main
+
+import "sort"
+
+const DefaultSyncLimit = 500
+
+const (
+	SyncLastWriterWins = "lww"
+	SyncFieldMerge     = "merge"
+)
+
+const (
+	SyncApplied  = "applied"
+	SyncMerged   = "merged"
+	SyncConflict = "conflict"
+	SyncRejected = "rejected"
+)
+
+// SyncFields maps the to-do fields clients can change offline to the field
+// names recorded in the history.
+var SyncFields = map[string]string{
+	"content":    "content",
+	"done":       "done",
+	"dueAt":      "dueat",
+	"startAt":    "startat",
+	"priority":   "priority",
+	"tags":       "tags",
+	"notes":      "notes",
+	"recurrence": "recurrence",
+	"reminders":  "reminders",
+}
+
+// MergeTodoField copies one field from a client change onto a to-do.
+func MergeTodoField(todoDTO *TodoDTO, changeDTO *TodoDTO, field string) {
+	switch field {
+	case "content":
+		todoDTO.Content = changeDTO.Content
+	case "done":
+		todoDTO.Done = changeDTO.Done
+	case "dueAt":
+		todoDTO.DueAt = changeDTO.DueAt
+	case "startAt":
+		todoDTO.StartAt = changeDTO.StartAt
+	case "priority":
+		todoDTO.Priority = changeDTO.Priority
+	case "tags":
+		todoDTO.Tags = changeDTO.Tags
+	case "notes":
+		todoDTO.Notes = changeDTO.Notes
+	case "recurrence":
+		todoDTO.Recurrence = changeDTO.Recurrence
+		todoDTO.Timezone = changeDTO.Timezone
+	case "reminders":
+		todoDTO.Reminders = changeDTO.Reminders
+	}
+}
+
+// syncItem is a to-do or a tombstone in the change sequence.
+type syncItem struct {
+	seq       int64
+	todo      *TodoEntity
+	tombstone *TombstoneEntity
+}
+
+func syncItems(todoEntities []TodoEntity, tombstoneEntities []TombstoneEntity) []syncItem {
+	items := []syncItem{}
+	for i := range todoEntities {
+		items = append(items, syncItem{seq: todoEntities[i].Seq, todo: &todoEntities[i]})
+	}
+	for i := range tombstoneEntities {
+		items = append(items, syncItem{seq: tombstoneEntities[i].Seq, tombstone: &tombstoneEntities[i]})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].seq < items[j].seq
+	})
+	return items
+}
+
+// cutSyncItems keeps at most limit items without splitting the items of
+// one sequence value, which a single write can share, across two pages.
+// It returns false when the page is cut.
+func cutSyncItems(items []syncItem, limit int) ([]syncItem, bool) {
+	if limit == 0 || len(items) <= limit {
+		return items, true
+	}
+
+	boundary := items[limit].seq
+	end := limit
+	for end > 0 && items[end-1].seq == boundary {
+		end--
+	}
+	if end == 0 {
+		for end < len(items) && items[end].seq == boundary {
+			end++
+		}
+	}
+	return items[:end], false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CutSyncItems(t *testing.T) {
+	Convey("Given changes where one write changed two to-dos", t, func() {
+		items := syncItems(
+			[]TodoEntity{{ID: "1", Seq: 1}, {ID: "2", Seq: 3}, {ID: "3", Seq: 3}},
+			[]TombstoneEntity{{ID: "4", Seq: 2}, {ID: "5", Seq: 4}},
+		)
+
+		Convey("Then they should be in sequence order", func() {
+			So(items[1].tombstone.ID, ShouldEqual, "4")
+			So(items[4].tombstone.ID, ShouldEqual, "5")
+		})
+
+		Convey("When a page would split that write", func() {
+			page, complete := cutSyncItems(items, 3)
+
+			Convey("Then the page should end before it", func() {
+				So(complete, ShouldBeFalse)
+				So(len(page), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the write alone does not fit on a page", func() {
+			page, complete := cutSyncItems(items[2:], 1)
+
+			Convey("Then the page should hold the whole write", func() {
+				So(complete, ShouldBeFalse)
+				So(len(page), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When every change fits on a page", func() {
+			page, complete := cutSyncItems(items, 5)
+
+			Convey("Then the page should be complete", func() {
+				So(complete, ShouldBeTrue)
+				So(len(page), ShouldEqual, 5)
+			})
+		})
+	})
+}
+
+func Test_MergeTodoField(t *testing.T) {
+	Convey("Given a to-do and a client change", t, func() {
+		todoDTO := TodoDTO{Content: "Sut al.", Priority: "low", Tags: []string{"market"}}
+		changeDTO := TodoDTO{Content: "Ekmek al.", Priority: "high", Done: true}
+
+		Convey("When only the content is merged", func() {
+			MergeTodoField(&todoDTO, &changeDTO, "content")
+
+			Convey("Then the other fields should be kept", func() {
+				So(todoDTO.Content, ShouldEqual, "Ekmek al.")
+				So(todoDTO.Priority, ShouldEqual, "low")
+				So(todoDTO.Done, ShouldBeFalse)
+				So(todoDTO.Tags, ShouldResemble, []string{"market"})
+			})
+		})
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const TenantKey = "tenant"
+
+const (
+	TenantSourceHeader    = "header"
+	TenantSourceSubdomain = "subdomain"
+	TenantSourceClaim     = "claim"
+)
+
+// TenantConfig selects where the tenant of a request comes from. Header
+// names the request header, Domain the base domain whose subdomains are
+// tenants and Claim the token claim holding the tenant.
+type TenantConfig struct {
+	Source string
+	Header string
+	Domain string
+	Claim  string
+}
+
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// ValidTenantName only accepts names that are safe to use in database and
+// collection names.
+func ValidTenantName(name string) bool {
+	return tenantNamePattern.MatchString(name)
+}
+
+// TenantMiddleware resolves the tenant of every request and stores it in
+// ctx.Locals(TenantKey). Requests without a tenant use the default
+// database. A tenant is only accepted for a principal that belongs to it,
+// so the middleware has to run after Authenticator.Middleware; without
+// authentication every tenant is forbidden.
+func TenantMiddleware(config TenantConfig) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		tenant := ResolveTenant(config, ctx)
+		if tenant != "" && !ValidTenantName(tenant) {
+			ctx.Status(fiber.StatusBadRequest)
+			return fiber.ErrBadRequest
+		}
+		principal, _ := ctx.Locals(PrincipalKey).(*Principal)
+		if tenant != "" && (principal == nil || !principal.InTenant(tenant, config.Claim)) {
+			ctx.Status(fiber.StatusForbidden)
+			return fiber.ErrForbidden
+		}
+
+		ctx.Locals(TenantKey, tenant)
+		return ctx.Next()
+	}
+}
+
+func ResolveTenant(config TenantConfig, ctx *fiber.Ctx) string {
+	switch config.Source {
+	case TenantSourceHeader:
+		return ctx.Get(config.Header)
+
+	case TenantSourceSubdomain:
+		host := ctx.Hostname()
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if !strings.HasSuffix(host, "."+config.Domain) {
+			return ""
+		}
+		return strings.TrimSuffix(host, "."+config.Domain)
+
+	case TenantSourceClaim:
+		principal, ok := ctx.Locals(PrincipalKey).(*Principal)
+		if !ok {
+			return ""
+		}
+		tenant, _ := principal.Claims[config.Claim].(string)
+		return tenant
+
+	default:
+		return ""
+	}
+}
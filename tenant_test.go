@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_TenantMiddleware(t *testing.T) {
+	Convey("Given tenant sources", t, func() {
+		request := func(config TenantConfig, principal *Principal, host string, header string) (int, string) {
+			app := fiber.New()
+			if principal != nil {
+				app.Use(func(ctx *fiber.Ctx) error {
+					ctx.Locals(PrincipalKey, principal)
+					return ctx.Next()
+				})
+			}
+			app.Use(TenantMiddleware(config))
+			app.Get("/todo", func(ctx *fiber.Ctx) error {
+				return ctx.SendString(ctx.Locals(TenantKey).(string))
+			})
+
+			req := httptest.NewRequest("GET", "/todo", nil)
+			req.Host = host
+			if header != "" {
+				req.Header.Set("X-Tenant-ID", header)
+			}
+			resp, err := app.Test(req)
+			So(err, ShouldBeNil)
+			body, _ := ioutil.ReadAll(resp.Body)
+			return resp.StatusCode, string(body)
+		}
+
+		member := &Principal{ID: "murat", Tenants: []string{"acme"}}
+
+		Convey("When the tenant comes from a header", func() {
+			config := TenantConfig{Source: TenantSourceHeader, Header: "X-Tenant-ID", Claim: "tenant"}
+
+			Convey("Then a tenant of the principal should be resolved", func() {
+				status, body := request(config, member, "example.com", "acme")
+				So(status, ShouldEqual, fiber.StatusOK)
+				So(body, ShouldEqual, "acme")
+			})
+
+			Convey("Then a tenant of another principal should be forbidden", func() {
+				status, _ := request(config, member, "example.com", "other")
+				So(status, ShouldEqual, fiber.StatusForbidden)
+			})
+
+			Convey("Then a tenant should be forbidden without a principal", func() {
+				status, _ := request(config, nil, "example.com", "acme")
+				So(status, ShouldEqual, fiber.StatusForbidden)
+			})
+
+			Convey("Then a missing header should use the default database", func() {
+				status, body := request(config, nil, "example.com", "")
+				So(status, ShouldEqual, fiber.StatusOK)
+				So(body, ShouldEqual, "")
+			})
+
+			Convey("Then an unsafe tenant name should be rejected", func() {
+				status, _ := request(config, member, "example.com", "../admin")
+				So(status, ShouldEqual, fiber.StatusBadRequest)
+			})
+		})
+
+		Convey("When the tenant comes from a subdomain", func() {
+			config := TenantConfig{Source: TenantSourceSubdomain, Domain: "todo.example.com", Claim: "tenant"}
+
+			Convey("Then the subdomain should be resolved", func() {
+				status, body := request(config, member, "acme.todo.example.com:8080", "")
+				So(status, ShouldEqual, fiber.StatusOK)
+				So(body, ShouldEqual, "acme")
+			})
+
+			Convey("Then a subdomain of another tenant should be forbidden", func() {
+				status, _ := request(config, member, "other.todo.example.com", "")
+				So(status, ShouldEqual, fiber.StatusForbidden)
+			})
+
+			Convey("Then a tenant in the token claims should be accepted", func() {
+				principal := &Principal{ID: "murat", Claims: jwt.MapClaims{"tenant": []interface{}{"other", "acme"}}}
+				status, body := request(config, principal, "acme.todo.example.com", "")
+				So(status, ShouldEqual, fiber.StatusOK)
+				So(body, ShouldEqual, "acme")
+			})
+
+			Convey("Then the base domain should use the default database", func() {
+				_, body := request(config, nil, "todo.example.com", "")
+				So(body, ShouldEqual, "")
+			})
+		})
+
+		Convey("When the tenant comes from a token claim", func() {
+			config := TenantConfig{Source: TenantSourceClaim, Claim: "tenant"}
+			principal := &Principal{ID: "murat", Claims: jwt.MapClaims{"tenant": "acme"}}
+
+			Convey("Then the claim should be resolved and headers ignored", func() {
+				_, body := request(config, principal, "example.com", "other")
+				So(body, ShouldEqual, "acme")
+			})
+		})
+	})
+}
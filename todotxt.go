@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const FormatTodoTxt = "todotxt"
+
+const todoTxtDate = "2006-01-02"
+
+// todoTxtPriorities are the todo.txt priority letters of the priorities;
+// imported letters after D are low.
+var todoTxtPriorities = map[string]string{"urgent": "A", "high": "B", "medium": "C", "low": "D"}
+
+var todoTxtPriority = regexp.MustCompile(`^\(([A-Z])\)$`)
+
+// Keys of the key:value tokens that carry the fields todo.txt has no syntax
+// for. Other key:value tokens stay in the content.
+const (
+	todoTxtDueKey        = "due"
+	todoTxtThresholdKey  = "t"
+	todoTxtRecurrenceKey = "rrule"
+	todoTxtPriorityKey   = "pri"
+	todoTxtNotesKey      = "note"
+	todoTxtIDKey         = "id"
+	todoTxtTextKey       = "text"
+	todoTxtCreatedKey    = "created"
+)
+
+var todoTxtKeys = map[string]bool{
+	todoTxtDueKey: true, todoTxtThresholdKey: true, todoTxtRecurrenceKey: true, todoTxtPriorityKey: true,
+	todoTxtNotesKey: true, todoTxtIDKey: true, todoTxtTextKey: true, todoTxtCreatedKey: true,
+}
+
+// todoTxtEscape starts content words that would otherwise be read as
+// todo.txt syntax.
+const todoTxtEscape = `\`
+
+// TodoTxtTodoEncoder writes exported to-dos as todo.txt lines. Tags become
+// +project tokens, and tags that start with @ become @context tokens.
+type TodoTxtTodoEncoder struct {
+	writer *bufio.Writer
+}
+
+func NewTodoTxtTodoEncoder(w io.Writer) (*TodoTxtTodoEncoder, error) {
+	return &TodoTxtTodoEncoder{writer: bufio.NewWriter(w)}, nil
+}
+
+func (encoder *TodoTxtTodoEncoder) Encode(todoDTO *TodoDTO) error {
+	_, err := encoder.writer.WriteString(FormatTodoTxtLine(todoDTO) + "\n")
+	return err
+}
+
+func (encoder *TodoTxtTodoEncoder) Close() error {
+	return encoder.writer.Flush()
+}
+
+// FormatTodoTxtLine writes a to-do in todo.txt syntax. Completed to-dos
+// keep their priority in a pri: token, as the priority marker is only for
+// open ones. Notes are escaped so they fit on the line, and content whose
+// spacing the words do not keep is also written whole in a text: token.
+func FormatTodoTxtLine(todoDTO *TodoDTO) string {
+	tokens := []string{}
+	letter := todoTxtPriorities[todoDTO.Priority]
+	if todoDTO.Done {
+		tokens = append(tokens, "x")
+		if todoDTO.CompletedAt != nil {
+			tokens = append(tokens, todoDTO.CompletedAt.UTC().Format(todoTxtDate))
+		}
+	} else if letter != "" {
+		tokens = append(tokens, "("+letter+")")
+	}
+	// todo.txt only has a creation date after a completion date, so a
+	// completed to-do without one keeps it in a created: token.
+	createdKey := todoDTO.Done && todoDTO.CompletedAt == nil
+	if !todoDTO.CreatedAt.IsZero() && !createdKey {
+		tokens = append(tokens, todoDTO.CreatedAt.UTC().Format(todoTxtDate))
+	}
+
+	words := strings.Fields(todoDTO.Content)
+	for i, word := range words {
+		tokens = append(tokens, escapeTodoTxtWord(word, i == 0))
+	}
+	for _, tag := range todoDTO.Tags {
+		tag = escapeTodoTxtTag(tag)
+		if !strings.HasPrefix(tag, "@") {
+			tag = "+" + tag
+		}
+		tokens = append(tokens, tag)
+	}
+
+	if todoDTO.DueAt != nil {
+		tokens = append(tokens, todoTxtDueKey+":"+formatTodoTxtTime(*todoDTO.DueAt))
+	}
+	if todoDTO.StartAt != nil {
+		tokens = append(tokens, todoTxtThresholdKey+":"+formatTodoTxtTime(*todoDTO.StartAt))
+	}
+	if todoDTO.Recurrence != "" {
+		tokens = append(tokens, todoTxtRecurrenceKey+":"+todoDTO.Recurrence)
+	}
+	if todoDTO.Done && letter != "" {
+		tokens = append(tokens, todoTxtPriorityKey+":"+letter)
+	}
+	if todoDTO.Notes != "" {
+		tokens = append(tokens, todoTxtNotesKey+":"+url.PathEscape(todoDTO.Notes))
+	}
+	if todoDTO.Content != strings.Join(words, " ") {
+		tokens = append(tokens, todoTxtTextKey+":"+url.PathEscape(todoDTO.Content))
+	}
+	if createdKey && !todoDTO.CreatedAt.IsZero() {
+		tokens = append(tokens, todoTxtCreatedKey+":"+formatTodoTxtTime(todoDTO.CreatedAt))
+	}
+	if todoDTO.ID != "" {
+		tokens = append(tokens, todoTxtIDKey+":"+todoDTO.ID)
+	}
+	return strings.Join(tokens, " ")
+}
+
+// DecodeTodoTxtTodos reads one to-do per non-empty line. Row numbers are
+// line numbers.
+func DecodeTodoTxtTodos(r io.Reader) ([]ImportRowModel, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	rows := []ImportRowModel{}
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" {
+			continue
+		}
+		row := ImportRowModel{Row: number}
+		todoDTO, err := ParseTodoTxtLine(line)
+		if err != nil {
+			row.Error = err.Error()
+		}
+		row.Todo = todoDTO
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// ParseTodoTxtLine reads a line written by FormatTodoTxtLine or by another
+// todo.txt tool. +project and @context tokens are taken out of the content
+// and become tags, unless they are escaped.
+func ParseTodoTxtLine(line string) (*TodoDTO, error) {
+	todoDTO := TodoDTO{Tags: []string{}}
+	tokens := strings.Fields(line)
+
+	i := 0
+	if i < len(tokens) && tokens[i] == "x" {
+		todoDTO.Done = true
+		i++
+		if completedAt, ok := parseTodoTxtDate(tokens, i); ok {
+			todoDTO.CompletedAt = &completedAt
+			i++
+		}
+	}
+	if i < len(tokens) {
+		if match := todoTxtPriority.FindStringSubmatch(tokens[i]); match != nil {
+			todoDTO.Priority = todoTxtPriorityName(match[1])
+			i++
+		}
+	}
+	if createdAt, ok := parseTodoTxtDate(tokens, i); ok {
+		todoDTO.CreatedAt = createdAt
+		i++
+	}
+
+	words := []string{}
+	text := ""
+	for _, token := range tokens[i:] {
+		if strings.HasPrefix(token, todoTxtEscape) && isTodoTxtSyntax(token, len(words) == 0) {
+			words = append(words, token[len(todoTxtEscape):])
+			continue
+		}
+		if len(token) > 1 && token[0] == '+' {
+			todoDTO.Tags = append(todoDTO.Tags, unescapeTodoTxtTag(token[1:]))
+			continue
+		}
+		if len(token) > 1 && token[0] == '@' {
+			todoDTO.Tags = append(todoDTO.Tags, unescapeTodoTxtTag(token))
+			continue
+		}
+
+		var err error
+		key, value := todoTxtKeyValue(token)
+		switch key {
+		case todoTxtDueKey:
+			todoDTO.DueAt, err = parseTodoTxtTime(value)
+		case todoTxtThresholdKey:
+			todoDTO.StartAt, err = parseTodoTxtTime(value)
+		case todoTxtRecurrenceKey:
+			todoDTO.Recurrence = value
+		case todoTxtPriorityKey:
+			todoDTO.Priority = todoTxtPriorityName(value)
+		case todoTxtNotesKey:
+			todoDTO.Notes, err = url.PathUnescape(value)
+		case todoTxtIDKey:
+			todoDTO.ID = value
+		case todoTxtTextKey:
+			text, err = url.PathUnescape(value)
+		case todoTxtCreatedKey:
+			var createdAt *time.Time
+			if createdAt, err = parseTodoTxtTime(value); err == nil {
+				todoDTO.CreatedAt = *createdAt
+			}
+		default:
+			words = append(words, token)
+		}
+		if err != nil {
+			return &todoDTO, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	todoDTO.Content = strings.Join(words, " ")
+	if text != "" {
+		todoDTO.Content = text
+	}
+	todoDTO.Tags = NormalizeTags(todoDTO.Tags)
+	return &todoDTO, nil
+}
+
+// escapeTodoTxtWord escapes a content word that would be read as a tag or
+// a field, or, as the first word, as a completion mark, priority or date.
+// Words that already start with the escape before such a word get one
+// more, so the import can always take exactly one off.
+func escapeTodoTxtWord(word string, first bool) string {
+	if isTodoTxtSyntax(word, first) {
+		return todoTxtEscape + word
+	}
+	return word
+}
+
+func isTodoTxtSyntax(word string, first bool) bool {
+	word = strings.TrimLeft(word, todoTxtEscape)
+	if len(word) > 1 && (word[0] == '+' || word[0] == '@') {
+		return true
+	}
+	if key, _ := todoTxtKeyValue(word); todoTxtKeys[key] {
+		return true
+	}
+	if first {
+		_, isDate := parseTodoTxtDate([]string{word}, 0)
+		return word == "x" || todoTxtPriority.MatchString(word) || isDate
+	}
+	return false
+}
+
+// escapeTodoTxtTag percent-escapes the spaces of a tag, which would end
+// the token, and the percent signs.
+func escapeTodoTxtTag(tag string) string {
+	var builder strings.Builder
+	for _, r := range tag {
+		if r == '%' || unicode.IsSpace(r) {
+			builder.WriteString(url.PathEscape(string(r)))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// unescapeTodoTxtTag keeps tags of other tools that are not escapes as
+// they are.
+func unescapeTodoTxtTag(tag string) string {
+	if unescaped, err := url.PathUnescape(tag); err == nil {
+		return unescaped
+	}
+	return tag
+}
+
+func todoTxtPriorityName(letter string) string {
+	for name, priorityLetter := range todoTxtPriorities {
+		if priorityLetter == letter {
+			return name
+		}
+	}
+	if len(letter) == 1 && letter[0] > 'D' && letter[0] <= 'Z' {
+		return "low"
+	}
+	return ""
+}
+
+// todoTxtKeyValue splits a key:value token. Tokens with an empty key or
+// value, and URLs, are not key:value tokens.
+func todoTxtKeyValue(token string) (string, string) {
+	parts := strings.SplitN(token, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.HasPrefix(parts[1], "//") {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+func parseTodoTxtDate(tokens []string, i int) (time.Time, bool) {
+	if i >= len(tokens) {
+		return time.Time{}, false
+	}
+	date, err := time.Parse(todoTxtDate, tokens[i])
+	return date, err == nil
+}
+
+// formatTodoTxtTime writes a date, which is what todo.txt tools expect, and
+// the full time only when there is one.
+func formatTodoTxtTime(t time.Time) string {
+	t = t.UTC()
+	if t.Equal(t.Truncate(24 * time.Hour)) {
+		return t.Format(todoTxtDate)
+	}
+	return t.Format(time.RFC3339)
+}
+
+func parseTodoTxtTime(value string) (*time.Time, error) {
+	for _, layout := range []string{todoTxtDate, time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			t = t.UTC()
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid date %q", value)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_TodoTxtTodos(t *testing.T) {
+	Convey("Given an exported to-do", t, func() {
+		dueAt := time.Date(2021, time.August, 6, 0, 0, 0, 0, time.UTC)
+		startAt := time.Date(2021, time.August, 4, 9, 30, 0, 0, time.UTC)
+		completedAt := time.Date(2021, time.August, 5, 0, 0, 0, 0, time.UTC)
+		todoDTO := TodoDTO{
+			ID:          "6f1c1d2e-4b0a-4a57-9a52-3f5a3b8d2c11",
+			Content:     "Buy milk and eggs",
+			Done:        true,
+			Priority:    "high",
+			Tags:        []string{"home", "@market"},
+			Notes:       "two large ones\nfrom the corner shop, 100%",
+			DueAt:       &dueAt,
+			StartAt:     &startAt,
+			Recurrence:  "FREQ=WEEKLY;BYDAY=FR",
+			CompletedAt: &completedAt,
+			CreatedAt:   time.Date(2021, time.August, 1, 0, 0, 0, 0, time.UTC),
+		}
+
+		var buffer bytes.Buffer
+		encoder, err := NewTodoTxtTodoEncoder(&buffer)
+		So(err, ShouldBeNil)
+		So(encoder.Encode(&todoDTO), ShouldBeNil)
+		So(encoder.Close(), ShouldBeNil)
+
+		Convey("Then it should be one todo.txt line", func() {
+			So(buffer.String(), ShouldStartWith, "x 2021-08-05 2021-08-01 Buy milk and eggs +home @market due:2021-08-06 t:2021-08-04T09:30:00Z ")
+			So(buffer.String(), ShouldContainSubstring, " pri:B ")
+			So(strings.Count(buffer.String(), "\n"), ShouldEqual, 1)
+		})
+
+		Convey("When it is imported again", func() {
+			rows, err := DecodeTodoTxtTodos(&buffer)
+			So(err, ShouldBeNil)
+
+			Convey("Then the fields should survive the round trip", func() {
+				So(len(rows), ShouldEqual, 1)
+				So(rows[0].Error, ShouldBeEmpty)
+				So(rows[0].Todo.ID, ShouldEqual, todoDTO.ID)
+				So(rows[0].Todo.Content, ShouldEqual, todoDTO.Content)
+				So(rows[0].Todo.Done, ShouldBeTrue)
+				So(rows[0].Todo.Priority, ShouldEqual, "high")
+				So(rows[0].Todo.Tags, ShouldResemble, todoDTO.Tags)
+				So(rows[0].Todo.Notes, ShouldEqual, todoDTO.Notes)
+				So(rows[0].Todo.Recurrence, ShouldEqual, todoDTO.Recurrence)
+				So(*rows[0].Todo.DueAt, ShouldEqual, dueAt)
+				So(*rows[0].Todo.StartAt, ShouldEqual, startAt)
+				So(*rows[0].Todo.CompletedAt, ShouldEqual, completedAt)
+				So(rows[0].Todo.CreatedAt, ShouldEqual, todoDTO.CreatedAt)
+			})
+		})
+	})
+
+	Convey("Given to-dos whose text looks like todo.txt syntax", t, func() {
+		roundTrip := func(todoDTO TodoDTO) *TodoDTO {
+			todoDTO.Tags = append([]string{}, todoDTO.Tags...)
+			parsed, err := ParseTodoTxtLine(FormatTodoTxtLine(&todoDTO))
+			So(err, ShouldBeNil)
+			return parsed
+		}
+
+		Convey("Then words with + or @ should stay in the content", func() {
+			parsed := roundTrip(TodoDTO{Content: "Email @bob about +1 votes"})
+			So(parsed.Content, ShouldEqual, "Email @bob about +1 votes")
+			So(parsed.Tags, ShouldBeEmpty)
+		})
+
+		Convey("Then words shaped like fields should stay in the content", func() {
+			content := "Ask due:friday t:now note:me id:7 rrule:x pri:A text:y created:z"
+			parsed := roundTrip(TodoDTO{Content: content})
+			So(parsed.Content, ShouldEqual, content)
+			So(parsed.DueAt, ShouldBeNil)
+			So(parsed.ID, ShouldBeEmpty)
+		})
+
+		Convey("Then a first word shaped like a mark, priority or date should stay in the content", func() {
+			So(roundTrip(TodoDTO{Content: "x marks the spot"}).Content, ShouldEqual, "x marks the spot")
+			So(roundTrip(TodoDTO{Content: "(A) grade"}).Content, ShouldEqual, "(A) grade")
+			parsed := roundTrip(TodoDTO{Content: "2021-08-05 retro", Done: true})
+			So(parsed.Content, ShouldEqual, "2021-08-05 retro")
+			So(parsed.CompletedAt, ShouldBeNil)
+		})
+
+		Convey("Then words that start with the escape should keep it", func() {
+			content := `\+tag C:\dir \x`
+			So(roundTrip(TodoDTO{Content: content}).Content, ShouldEqual, content)
+		})
+
+		Convey("Then the spacing of the content should be kept", func() {
+			content := "Pack:  shoes\n\tsocks "
+			So(roundTrip(TodoDTO{Content: content}).Content, ShouldEqual, content)
+		})
+
+		Convey("Then tags with spaces and percent signs should be kept", func() {
+			parsed := roundTrip(TodoDTO{Content: "Plan", Tags: []string{"road trip", "@at home", "100%"}})
+			So(parsed.Tags, ShouldResemble, []string{"road trip", "@at home", "100%"})
+		})
+
+		Convey("Then a completed to-do without a completion date should not get one", func() {
+			createdAt := time.Date(2021, time.August, 1, 8, 30, 0, 0, time.UTC)
+			parsed := roundTrip(TodoDTO{Content: "Done", Done: true, CreatedAt: createdAt})
+			So(parsed.Done, ShouldBeTrue)
+			So(parsed.CompletedAt, ShouldBeNil)
+			So(parsed.CreatedAt, ShouldEqual, createdAt)
+		})
+	})
+
+	Convey("Given a todo.txt file from another tool", t, func() {
+		source := "(A) 2021-08-01 Call Mom +Family @phone about http://example.com/trip h:1\n" +
+			"\n" +
+			"(E) Pay the rent due:tomorrow\n"
+
+		Convey("When it is imported", func() {
+			rows, err := DecodeTodoTxtTodos(strings.NewReader(source))
+			So(err, ShouldBeNil)
+
+			Convey("Then projects and contexts should become tags", func() {
+				So(len(rows), ShouldEqual, 2)
+				So(rows[0].Todo.Content, ShouldEqual, "Call Mom about http://example.com/trip h:1")
+				So(rows[0].Todo.Tags, ShouldResemble, []string{"family", "@phone"})
+				So(rows[0].Todo.Priority, ShouldEqual, "urgent")
+				So(rows[0].Todo.Done, ShouldBeFalse)
+				So(rows[0].Todo.CreatedAt, ShouldEqual, time.Date(2021, time.August, 1, 0, 0, 0, 0, time.UTC))
+			})
+
+			Convey("Then a line that cannot be read should be reported", func() {
+				So(rows[1].Row, ShouldEqual, 3)
+				So(rows[1].Todo.Priority, ShouldEqual, "low")
+				So(rows[1].Error, ShouldContainSubstring, "due")
+			})
+		})
+	})
+}
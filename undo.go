@@ -0,0 +1,107 @@
+package main
+
+import "sync"
+
+const DefaultUndoDepth = 20
+
+// TodoChange is one to-do before and after an operation. A nil Before is a
+// creation and a nil After a permanent removal.
+type TodoChange struct {
+	Before *TodoEntity
+	After  *TodoEntity
+}
+
+type Operation struct {
+	Action  string
+	Changes []TodoChange
+}
+
+type undoStacks struct {
+	undo []*Operation
+	redo []*Operation
+}
+
+// UndoStack keeps the most recent operations of every user in memory.
+type UndoStack struct {
+	mutex sync.Mutex
+	depth int
+	users map[string]*undoStacks
+}
+
+func NewUndoStack(depth int) *UndoStack {
+	return &UndoStack{
+		depth: depth,
+		users: map[string]*undoStacks{},
+	}
+}
+
+// Push records a new operation and clears the redo stack, as a fresh change
+// makes previously undone operations unreachable.
+func (stack *UndoStack) Push(userId string, operation *Operation) {
+	stack.mutex.Lock()
+	defer stack.mutex.Unlock()
+
+	stacks := stack.stacks(userId)
+	stacks.undo = pushBounded(stacks.undo, operation, stack.depth)
+	stacks.redo = nil
+}
+
+func (stack *UndoStack) PopUndo(userId string) *Operation {
+	stack.mutex.Lock()
+	defer stack.mutex.Unlock()
+
+	stacks := stack.stacks(userId)
+	var operation *Operation
+	stacks.undo, operation = pop(stacks.undo)
+	return operation
+}
+
+func (stack *UndoStack) PopRedo(userId string) *Operation {
+	stack.mutex.Lock()
+	defer stack.mutex.Unlock()
+
+	stacks := stack.stacks(userId)
+	var operation *Operation
+	stacks.redo, operation = pop(stacks.redo)
+	return operation
+}
+
+func (stack *UndoStack) PushUndo(userId string, operation *Operation) {
+	stack.mutex.Lock()
+	defer stack.mutex.Unlock()
+
+	stacks := stack.stacks(userId)
+	stacks.undo = pushBounded(stacks.undo, operation, stack.depth)
+}
+
+func (stack *UndoStack) PushRedo(userId string, operation *Operation) {
+	stack.mutex.Lock()
+	defer stack.mutex.Unlock()
+
+	stacks := stack.stacks(userId)
+	stacks.redo = pushBounded(stacks.redo, operation, stack.depth)
+}
+
+func (stack *UndoStack) stacks(userId string) *undoStacks {
+	stacks, ok := stack.users[userId]
+	if !ok {
+		stacks = &undoStacks{}
+		stack.users[userId] = stacks
+	}
+	return stacks
+}
+
+func pushBounded(operations []*Operation, operation *Operation, depth int) []*Operation {
+	operations = append(operations, operation)
+	if len(operations) > depth {
+		operations = operations[len(operations)-depth:]
+	}
+	return operations
+}
+
+func pop(operations []*Operation) ([]*Operation, *Operation) {
+	if len(operations) == 0 {
+		return operations, nil
+	}
+	return operations[:len(operations)-1], operations[len(operations)-1]
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// EventCompleted is only delivered to webhooks, for updates that marked a
+// to-do as done.
+const EventCompleted = "completed"
+
+var WebhookEventTypes = map[string]bool{
+	EventCreated:   true,
+	EventUpdated:   true,
+	EventReordered: true,
+	EventDeleted:   true,
+	EventCompleted: true,
+}
+
+const (
+	WebhookSignatureHeader = "X-Todo-Signature"
+	WebhookEventHeader     = "X-Todo-Event"
+	WebhookDeliveryHeader  = "X-Todo-Delivery"
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead"
+)
+
+const DefaultWebhookMaxAttempts = 8
+
+type WebhookPayload struct {
+	DeliveryID string    `json:"deliveryId"`
+	Event      string    `json:"event"`
+	OwnerID    string    `json:"ownerId,omitempty"`
+	Todo       *TodoDTO  `json:"todo"`
+	At         time.Time `json:"at"`
+}
+
+// SignWebhookPayload returns the value of the signature header: the hex
+// HMAC-SHA256 of the body keyed with the secret of the webhook.
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewWebhookSecret is used when a webhook is created without a secret.
+func NewWebhookSecret() string {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return hex.EncodeToString(secret)
+}
+
+// nonPublicNetworks are the loopback, private, link-local, shared and
+// reserved ranges, which webhooks must not reach.
+var nonPublicNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/3",
+	"::/127", "64:ff9b::/96", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// PublicAddress tells whether a webhook may connect to ip.
+func PublicAddress(ip net.IP) bool {
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// WebhookHosts are the hosts webhooks may reach although they are not
+// public, such as a receiver on localhost for local testing. Every other
+// webhook has to connect to a public address.
+type WebhookHosts []string
+
+func (hosts WebhookHosts) Allowed(host string) bool {
+	for _, allowed := range hosts {
+		if strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// DialContext connects to a webhook. The address is checked after the name
+// is resolved, so a name that is pointed at the local network after the
+// webhook was created is refused too, and so is every redirect.
+func (hosts WebhookHosts) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	if host, _, err := net.SplitHostPort(address); err != nil || !hosts.Allowed(host) {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !PublicAddress(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		}
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// ValidWebhookURL accepts absolute HTTP URLs. Hosts that are addresses or
+// localhost must be public or allowed; names are checked when a delivery
+// connects.
+func ValidWebhookURL(rawURL string, hosts WebhookHosts) bool {
+	webhookURL, err := url.Parse(rawURL)
+	if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Host == "" {
+		return false
+	}
+
+	host := webhookURL.Hostname()
+	if hosts.Allowed(host) {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return PublicAddress(ip)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host != "localhost" && !strings.HasSuffix(host, ".localhost")
+}
+
+type WebhookDispatcher struct {
+	repository  *Repository
+	client      *http.Client
+	lease       time.Duration
+	maxAttempts int
+}
+
+// NewWebhookDispatcher delivers to public addresses and to the allowed
+// hosts. Proxies from the environment are not used, as they would connect
+// on behalf of the dispatcher without the check.
+func NewWebhookDispatcher(repository *Repository, hosts WebhookHosts) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		repository: repository,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: hosts.DialContext},
+		},
+		lease:       time.Minute,
+		maxAttempts: DefaultWebhookMaxAttempts,
+	}
+}
+
+func (dispatcher *WebhookDispatcher) Start(interval time.Duration) func() {
+	return StartWorker("webhook dispatcher", interval, dispatcher.RunOnce)
+}
+
+// RunOnce sends every delivery that is due, in the default database and in
+// every tenant. Failed deliveries are retried with an exponential backoff
+// and moved to the dead letters after the last attempt.
+func (dispatcher *WebhookDispatcher) RunOnce() error {
+	return dispatcher.repository.ForEachTenantRepository(dispatcher.deliver)
+}
+
+func (dispatcher *WebhookDispatcher) deliver(repository *Repository) error {
+	for {
+		now := time.Now().UTC()
+
+		deliveryEntity, err := repository.ClaimWebhookDeliveryRepository(now, now.Add(dispatcher.lease))
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		deliveryEntity.Attempts++
+		deliveryEntity.LastAttemptAt = &now
+		webhookEntity, err := repository.GetWebhookRepository(deliveryEntity.OwnerID, deliveryEntity.WebhookID)
+		switch err {
+		case nil:
+			deliveryEntity.LastStatusCode, err = dispatcher.send(webhookEntity, deliveryEntity)
+		case mongo.ErrNoDocuments:
+			err = errors.New("webhook was deleted")
+			deliveryEntity.Attempts = dispatcher.maxAttempts
+		default:
+			return err
+		}
+
+		switch {
+		case err == nil:
+			deliveryEntity.Status = WebhookDeliveryDelivered
+			deliveryEntity.LastError = ""
+		case deliveryEntity.Attempts >= dispatcher.maxAttempts:
+			log.Printf("webhook delivery %s: %v, giving up", deliveryEntity.ID, err)
+			deliveryEntity.Status = WebhookDeliveryDead
+			deliveryEntity.LastError = err.Error()
+		default:
+			log.Printf("webhook delivery %s: %v", deliveryEntity.ID, err)
+			deliveryEntity.NextAttemptAt = now.Add(dispatcher.backoff(deliveryEntity.Attempts))
+			deliveryEntity.LastError = err.Error()
+		}
+
+		if err := repository.UpdateWebhookDeliveryRepository(deliveryEntity); err != nil {
+			return err
+		}
+	}
+}
+
+func (dispatcher *WebhookDispatcher) send(webhookEntity *WebhookEntity, deliveryEntity *WebhookDeliveryEntity) (int, error) {
+	body := []byte(deliveryEntity.Payload)
+	request, err := http.NewRequest(http.MethodPost, webhookEntity.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, deliveryEntity.Event)
+	request.Header.Set(WebhookDeliveryHeader, deliveryEntity.ID)
+	request.Header.Set(WebhookSignatureHeader, SignWebhookPayload(webhookEntity.Secret, body))
+
+	response, err := dispatcher.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("webhook responded with status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+func (dispatcher *WebhookDispatcher) backoff(attempts int) time.Duration {
+	backoff := time.Duration(float64(dispatcher.lease) * math.Pow(2, float64(attempts-1)))
+	if backoff > 6*time.Hour {
+		return 6 * time.Hour
+	}
+	return backoff
+}
+
+func webhookSubscribed(webhookEntity *WebhookEntity, eventType string) bool {
+	for _, event := range webhookEntity.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_SignWebhookPayload(t *testing.T) {
+	Convey("Given a webhook payload and secret", t, func() {
+		body := []byte(`{"event":"completed"}`)
+
+		Convey("When the payload is signed", func() {
+			signature := SignWebhookPayload("s3cret", body)
+
+			Convey("Then it should be the HMAC-SHA256 of the body", func() {
+				mac := hmac.New(sha256.New, []byte("s3cret"))
+				mac.Write(body)
+				So(signature, ShouldEqual, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+				So(SignWebhookPayload("other", body), ShouldNotEqual, signature)
+			})
+		})
+	})
+}
+
+func Test_WebhookDispatcherBackoff(t *testing.T) {
+	Convey("Given a dispatcher with a one minute lease", t, func() {
+		dispatcher := NewWebhookDispatcher(nil, nil)
+
+		Convey("Then retries should back off exponentially up to six hours", func() {
+			So(dispatcher.backoff(1), ShouldEqual, time.Minute)
+			So(dispatcher.backoff(2), ShouldEqual, 2*time.Minute)
+			So(dispatcher.backoff(4), ShouldEqual, 8*time.Minute)
+			So(dispatcher.backoff(20), ShouldEqual, 6*time.Hour)
+		})
+	})
+}
+
+func Test_ValidWebhookURL(t *testing.T) {
+	Convey("Given webhook URLs", t, func() {
+		Convey("Then only absolute HTTP URLs should be accepted", func() {
+			So(ValidWebhookURL("https://ci.example.com/hooks/todo", nil), ShouldBeTrue)
+			So(ValidWebhookURL("http://93.184.216.34:8080", nil), ShouldBeTrue)
+			So(ValidWebhookURL("ftp://example.com", nil), ShouldBeFalse)
+			So(ValidWebhookURL("/hooks/todo", nil), ShouldBeFalse)
+		})
+
+		Convey("Then local and private addresses should be refused", func() {
+			So(ValidWebhookURL("http://127.0.0.1:8080", nil), ShouldBeFalse)
+			So(ValidWebhookURL("http://localhost/hooks", nil), ShouldBeFalse)
+			So(ValidWebhookURL("http://169.254.169.254/latest/meta-data", nil), ShouldBeFalse)
+			So(ValidWebhookURL("http://10.0.0.7", nil), ShouldBeFalse)
+			So(ValidWebhookURL("http://[::1]:8080", nil), ShouldBeFalse)
+			So(ValidWebhookURL("http://[::ffff:192.168.1.1]", nil), ShouldBeFalse)
+		})
+
+		Convey("Then allowed hosts should be accepted for local testing", func() {
+			So(ValidWebhookURL("http://127.0.0.1:8080", WebhookHosts{"127.0.0.1"}), ShouldBeTrue)
+			So(ValidWebhookURL("http://localhost/hooks", WebhookHosts{"localhost"}), ShouldBeTrue)
+		})
+	})
+}
+
+func Test_WebhookDispatcherAddresses(t *testing.T) {
+	Convey("Given a receiver on the loopback address", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+		webhookEntity := &WebhookEntity{URL: server.URL, Secret: "s3cret"}
+		deliveryEntity := &WebhookDeliveryEntity{ID: "delivery", Event: EventCompleted, Payload: "{}"}
+
+		Convey("When the dispatcher does not allow the host", func() {
+			_, err := NewWebhookDispatcher(nil, nil).send(webhookEntity, deliveryEntity)
+
+			Convey("Then the delivery should not connect", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "not public")
+			})
+		})
+
+		Convey("When the dispatcher allows the host", func() {
+			status, err := NewWebhookDispatcher(nil, WebhookHosts{"127.0.0.1"}).send(webhookEntity, deliveryEntity)
+
+			Convey("Then the delivery should be sent", func() {
+				So(err, ShouldBeNil)
+				So(status, ShouldEqual, http.StatusNoContent)
+			})
+		})
+	})
+}
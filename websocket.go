@@ -0,0 +1,352 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+const (
+	LivePingInterval = 30 * time.Second
+	LiveReadTimeout  = 2 * LivePingInterval
+	LiveWriteTimeout = 10 * time.Second
+)
+
+const (
+	LiveSubscribe   = "subscribe"
+	LiveUnsubscribe = "unsubscribe"
+	LiveCreate      = "create"
+	LiveUpdate      = "update"
+	LiveReorder     = "reorder"
+	LivePing        = "ping"
+
+	LiveEvent    = "event"
+	LivePresence = "presence"
+	LiveResult   = "result"
+	LiveError    = "error"
+	LiveReset    = "reset"
+	LivePong     = "pong"
+)
+
+// LiveMessageDTO is every message exchanged on /ws. List is the owner of
+// the list a message is about; an empty List is the caller's own list.
+type LiveMessageDTO struct {
+	Type      string   `json:"type"`
+	List      string   `json:"list"`
+	RequestID string   `json:"requestId,omitempty"`
+	TodoID    string   `json:"todoId,omitempty"`
+	BackID    string   `json:"backId,omitempty"`
+	FrontID   string   `json:"frontId,omitempty"`
+	Todo      *TodoDTO `json:"todo,omitempty"`
+	Event     string   `json:"event,omitempty"`
+	EventID   uint64   `json:"eventId,omitempty"`
+	Viewers   []string `json:"viewers,omitempty"`
+	Status    int      `json:"status,omitempty"`
+	Message   string   `json:"message,omitempty"`
+}
+
+// Presence tracks who is viewing each list over /ws.
+type Presence struct {
+	mutex sync.Mutex
+	lists map[*Service]map[string]map[*liveClient]string
+}
+
+func NewPresence() *Presence {
+	return &Presence{
+		lists: map[*Service]map[string]map[*liveClient]string{},
+	}
+}
+
+func (presence *Presence) join(client *liveClient, list string, viewer string) {
+	presence.mutex.Lock()
+	defer presence.mutex.Unlock()
+
+	lists, ok := presence.lists[client.service]
+	if !ok {
+		lists = map[string]map[*liveClient]string{}
+		presence.lists[client.service] = lists
+	}
+	if _, ok := lists[list]; !ok {
+		lists[list] = map[*liveClient]string{}
+	}
+	lists[list][client] = viewer
+	presence.broadcast(client.service, list)
+}
+
+func (presence *Presence) leave(client *liveClient, list string) {
+	presence.mutex.Lock()
+	defer presence.mutex.Unlock()
+
+	clients := presence.lists[client.service][list]
+	if _, ok := clients[client]; !ok {
+		return
+	}
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(presence.lists[client.service], list)
+	}
+	presence.broadcast(client.service, list)
+}
+
+func (presence *Presence) broadcast(service *Service, list string) {
+	clients := presence.lists[service][list]
+
+	viewers := []string{}
+	seen := map[string]bool{}
+	for _, viewer := range clients {
+		if !seen[viewer] {
+			seen[viewer] = true
+			viewers = append(viewers, viewer)
+		}
+	}
+	sort.Strings(viewers)
+
+	for client := range clients {
+		client.send(&LiveMessageDTO{Type: LivePresence, List: list, Viewers: viewers})
+	}
+}
+
+type liveClient struct {
+	service       *Service
+	actor         *Actor
+	authenticated bool
+	outbox        chan *LiveMessageDTO
+	done          chan struct{}
+	stopOnce      sync.Once
+	mutex         sync.Mutex
+	subscriptions map[string]*EventSubscription
+}
+
+// send queues a message without blocking; a client that does not keep up
+// is disconnected.
+func (client *liveClient) send(message *LiveMessageDTO) {
+	select {
+	case client.outbox <- message:
+	case <-client.done:
+	default:
+		client.stop()
+	}
+}
+
+func (client *liveClient) stop() {
+	client.stopOnce.Do(func() {
+		close(client.done)
+	})
+}
+
+// listActor is the actor working on a list. Only authenticated callers can
+// pick a list other than their own.
+func (client *liveClient) listActor(list string) (*Actor, error) {
+	if list == "" || list == client.actor.OwnerID {
+		return client.actor, nil
+	}
+	if !client.authenticated {
+		return nil, fiber.ErrForbidden
+	}
+	return &Actor{ID: client.actor.ID, OwnerID: list}, nil
+}
+
+// UpgradeLiveApi authorizes the caller before the connection is upgraded to
+// a WebSocket handled by LiveApi.
+func (api *Api) UpgradeLiveApi(ctx *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(ctx) {
+		ctx.Status(fiber.StatusUpgradeRequired)
+		return fiber.ErrUpgradeRequired
+	}
+
+	service, actor, err := api.authorize(ctx, RoleViewer)
+	if err != nil {
+		return err
+	}
+	_, authenticated := ctx.Locals(PrincipalKey).(*Principal)
+
+	ctx.Locals("liveClient", &liveClient{
+		service:       service,
+		actor:         actor,
+		authenticated: authenticated,
+		outbox:        make(chan *LiveMessageDTO, 64),
+		done:          make(chan struct{}),
+		subscriptions: map[string]*EventSubscription{},
+	})
+	return ctx.Next()
+}
+
+// LiveApi serves a collaborative session: clients subscribe to lists,
+// receive their change events and presence, and send commands that go
+// through the same Service methods as the REST endpoints.
+func (api *Api) LiveApi(conn *websocket.Conn) {
+	client := conn.Locals("liveClient").(*liveClient)
+
+	// The connection is recycled once LiveApi returns, so it waits for
+	// the writer.
+	written := make(chan struct{})
+	go func() {
+		api.writeLive(conn, client)
+		close(written)
+	}()
+
+	defer func() {
+		client.stop()
+		<-written
+		client.mutex.Lock()
+		lists := []string{}
+		for list, subscription := range client.subscriptions {
+			subscription.Close()
+			lists = append(lists, list)
+		}
+		client.mutex.Unlock()
+		for _, list := range lists {
+			api.presence.leave(client, list)
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(LiveReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(LiveReadTimeout))
+	})
+
+	for {
+		message := LiveMessageDTO{}
+		if err := conn.ReadJSON(&message); err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(LiveReadTimeout))
+		api.handleLive(client, &message)
+	}
+}
+
+func (api *Api) writeLive(conn *websocket.Conn, client *liveClient) {
+	ping := time.NewTicker(LivePingInterval)
+	defer ping.Stop()
+	defer conn.Close()
+
+	for {
+		select {
+		case message := <-client.outbox:
+			conn.SetWriteDeadline(time.Now().Add(LiveWriteTimeout))
+			if err := conn.WriteJSON(message); err != nil {
+				client.stop()
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(LiveWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				client.stop()
+				return
+			}
+		case <-client.done:
+			return
+		}
+	}
+}
+
+func (api *Api) handleLive(client *liveClient, message *LiveMessageDTO) {
+	if message.Type == LivePing {
+		client.send(&LiveMessageDTO{Type: LivePong, RequestID: message.RequestID})
+		return
+	}
+
+	role := RoleEditor
+	if message.Type == LiveSubscribe || message.Type == LiveUnsubscribe {
+		role = RoleViewer
+	}
+	actor, err := client.listActor(message.List)
+	if err == nil {
+		err = client.service.AuthorizeService(actor, role)
+	}
+	if err != nil {
+		client.send(liveError(message, err))
+		return
+	}
+
+	var todoDTO *TodoDTO
+	switch message.Type {
+	case LiveSubscribe:
+		api.subscribeLive(client, actor, message.List)
+	case LiveUnsubscribe:
+		api.unsubscribeLive(client, message.List)
+	case LiveCreate:
+		todoDTO, err = client.service.PostTodoService(actor, liveTodo(message))
+	case LiveUpdate:
+		todoDTO, err = client.service.UpdateTodoService(actor, message.TodoID, liveTodo(message))
+	case LiveReorder:
+		todoDTO, err = client.service.UpdateTodoSortService(actor, message.TodoID, message.BackID, message.FrontID)
+	default:
+		err = fiber.ErrBadRequest
+	}
+
+	if err != nil {
+		client.send(liveError(message, err))
+		return
+	}
+	client.send(&LiveMessageDTO{Type: LiveResult, List: message.List, RequestID: message.RequestID, Todo: todoDTO})
+}
+
+func (api *Api) subscribeLive(client *liveClient, actor *Actor, list string) {
+	client.mutex.Lock()
+	if _, ok := client.subscriptions[list]; ok {
+		client.mutex.Unlock()
+		return
+	}
+	subscription := client.service.SubscribeTodoEventsService(actor, 0)
+	client.subscriptions[list] = subscription
+	client.mutex.Unlock()
+
+	api.presence.join(client, list, actor.ID)
+
+	go func() {
+		for event := range subscription.Events {
+			client.send(&LiveMessageDTO{Type: LiveEvent, List: list, Event: event.Type, EventID: event.ID, Todo: event.Todo})
+		}
+
+		// The bus drops subscribers that fall behind; the client has to
+		// reload the list and subscribe again.
+		client.mutex.Lock()
+		current, ok := client.subscriptions[list]
+		dropped := ok && current == subscription
+		if dropped {
+			delete(client.subscriptions, list)
+		}
+		client.mutex.Unlock()
+		if dropped {
+			api.presence.leave(client, list)
+			client.send(&LiveMessageDTO{Type: LiveReset, List: list})
+		}
+	}()
+}
+
+func (api *Api) unsubscribeLive(client *liveClient, list string) {
+	client.mutex.Lock()
+	subscription, ok := client.subscriptions[list]
+	delete(client.subscriptions, list)
+	client.mutex.Unlock()
+
+	if ok {
+		subscription.Close()
+		api.presence.leave(client, list)
+	}
+}
+
+func liveTodo(message *LiveMessageDTO) *TodoDTO {
+	if message.Todo == nil {
+		return &TodoDTO{}
+	}
+	return message.Todo
+}
+
+func liveError(message *LiveMessageDTO, err error) *LiveMessageDTO {
+	status := fiber.StatusInternalServerError
+	if fiberError, ok := err.(*fiber.Error); ok {
+		status = fiberError.Code
+	}
+	return &LiveMessageDTO{
+		Type:      LiveError,
+		List:      message.List,
+		RequestID: message.RequestID,
+		Status:    status,
+		Message:   err.Error(),
+	}
+}
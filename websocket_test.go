@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_LiveApi(t *testing.T) {
+	Convey("Given a WebSocket endpoint for the anonymous list", t, func() {
+		service := NewService(nil)
+		api := NewAPI(service)
+		app := fiber.New()
+		app.Get("/ws", api.UpgradeLiveApi, websocket.New(api.LiveApi))
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		go app.Listener(listener)
+		defer app.Shutdown()
+
+		dial := func(actor string) *fastws.Conn {
+			header := http.Header{}
+			header.Set("X-Actor", actor)
+			conn, _, err := fastws.DefaultDialer.Dial("ws://"+listener.Addr().String()+"/ws", header)
+			So(err, ShouldBeNil)
+			return conn
+		}
+		// receive skips the acknowledgements of subscriptions.
+		receive := func(conn *fastws.Conn) LiveMessageDTO {
+			for {
+				message := LiveMessageDTO{}
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				So(conn.ReadJSON(&message), ShouldBeNil)
+				if message.Type != LiveResult {
+					return message
+				}
+			}
+		}
+
+		murat := dial("murat")
+		defer murat.Close()
+
+		Convey("When a client pings", func() {
+			murat.WriteJSON(LiveMessageDTO{Type: LivePing, RequestID: "1"})
+
+			Convey("Then it should receive a pong", func() {
+				message := receive(murat)
+				So(message.Type, ShouldEqual, LivePong)
+				So(message.RequestID, ShouldEqual, "1")
+			})
+		})
+
+		Convey("When two clients subscribe to the list", func() {
+			murat.WriteJSON(LiveMessageDTO{Type: LiveSubscribe})
+			So(receive(murat).Viewers, ShouldResemble, []string{"murat"})
+
+			ayse := dial("ayse")
+			defer ayse.Close()
+			ayse.WriteJSON(LiveMessageDTO{Type: LiveSubscribe})
+
+			Convey("Then both should see who is viewing", func() {
+				So(receive(murat).Viewers, ShouldResemble, []string{"ayse", "murat"})
+				So(receive(ayse).Viewers, ShouldResemble, []string{"ayse", "murat"})
+			})
+
+			Convey("Then both should receive the changes of the list", func() {
+				receive(murat)
+				receive(ayse)
+				service.events.Publish(TodoEvent{Type: EventCreated, Todo: &TodoDTO{ID: "1"}})
+
+				for _, conn := range []*fastws.Conn{murat, ayse} {
+					message := receive(conn)
+					So(message.Type, ShouldEqual, LiveEvent)
+					So(message.Event, ShouldEqual, EventCreated)
+					So(message.Todo.ID, ShouldEqual, "1")
+				}
+			})
+
+			Convey("Then the others should see a client leave", func() {
+				receive(murat)
+				receive(ayse)
+				ayse.Close()
+
+				So(receive(murat).Viewers, ShouldResemble, []string{"murat"})
+			})
+		})
+
+		Convey("When an unauthenticated client subscribes to another list", func() {
+			murat.WriteJSON(LiveMessageDTO{Type: LiveSubscribe, List: "ayse", RequestID: "2"})
+
+			Convey("Then it should be forbidden", func() {
+				message := receive(murat)
+				So(message.Type, ShouldEqual, LiveError)
+				So(message.Status, ShouldEqual, fiber.StatusForbidden)
+				So(message.RequestID, ShouldEqual, "2")
+			})
+		})
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// StartWorker runs job on its own goroutine every interval until the
+// returned stop function is called. Errors are logged and the job is tried
+// again on the next tick.
+func StartWorker(name string, interval time.Duration, job func() error) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := job(); err != nil {
+					log.Printf("%s: %v", name, err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}